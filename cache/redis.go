@@ -48,6 +48,36 @@ func (rc *RedisCache) ZRevrange(set string, start, end int) []interface{} {
 	return list
 }
 
+// ZIncrbyEX is ZIncrby for keys that expire by themselves,
+// they are not tracked for ClearAll.
+func (rc *RedisCache) ZIncrbyEX(set string, score int, key string, timeout time.Duration) error {
+	if _, err := rc.do("ZINCRBY", set, score, key); err != nil {
+		return err
+	}
+	_, err := rc.do("EXPIRE", set, int64(timeout/time.Second))
+	return err
+}
+
+func (rc *RedisCache) HIncrbyEX(set, key string, value int, timeout time.Duration) error {
+	if _, err := rc.do("HINCRBY", set, key, value); err != nil {
+		return err
+	}
+	_, err := rc.do("EXPIRE", set, int64(timeout/time.Second))
+	return err
+}
+
+func (rc *RedisCache) HSetEX(set, key string, value interface{}, timeout time.Duration) error {
+	if _, err := rc.do("HSET", set, key, value); err != nil {
+		return err
+	}
+	_, err := rc.do("EXPIRE", set, int64(timeout/time.Second))
+	return err
+}
+
+func (rc *RedisCache) HGetAll(set string) (map[string]string, error) {
+	return redis.StringMap(rc.do("HGETALL", set))
+}
+
 func (rc *RedisCache) HSetNX(set, key string, value interface{}) (bool, error) {
 	has, err := rc.do("HSETNX", set, key, value)
 	if err != nil {
@@ -22,6 +22,14 @@ type RedisInfo struct {
 	Password string
 }
 
+// 消息存档, 保存天数为0时永久保存, 分群聊/私聊的天数优先
+type MsgArchiveInfo struct {
+	IfArchive           bool
+	RetentionDays       int64
+	GroupRetentionDays  int64
+	PeopleRetentionDays int64
+}
+
 type RobotAccount struct {
 	Host string
 }
@@ -32,6 +40,9 @@ type Config struct {
 	Host    string
 	Version string
 
+	// 把旧格式的事件文件转换为yaml规则文件后退出
+	ConvertEventFile string
+
 	TestNickName string
 	UploadFile   string
 	IfInvite     bool
@@ -40,14 +51,17 @@ type Config struct {
 	IfShowSqlLog  bool
 	IfNeedOwnerDB bool
 
-	WxEventFile string
-	QRCodeDir   string
-	TempPicDir  string
+	WxEventFile   string
+	RobotRoleFile string // json, 按RobotType的角色配置
+	QRCodeDir     string
+	TempPicDir    string
 
 	MemberRedis  RedisInfo
 	RankRedis    RedisInfo
 	SessionRedis RedisInfo
 
+	MsgArchive MsgArchiveInfo
+
 	DBInfo
 	RobotAccount
 }
@@ -56,6 +70,9 @@ func NewConfig() *Config {
 	c := new(Config)
 	initFlag(c)
 
+	if c.ConvertEventFile != "" {
+		return c
+	}
 	if c.Path == "" {
 		fmt.Println("wxrobot must run with config file, please check.")
 		os.Exit(0)
@@ -81,6 +98,7 @@ func initFlag(c *Config) {
 	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
 	v := fs.Bool("v", false, "Print version and exit")
 	fs.StringVar(&c.Path, "c", "", "wxrobot config file.")
+	fs.StringVar(&c.ConvertEventFile, "convert-event", "", "convert the event file to yaml rules on stdout and exit.")
 
 	fs.Parse(os.Args[1:])
 	fs.Usage = func() {
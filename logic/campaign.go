@@ -0,0 +1,456 @@
+package logic
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/reechou/wxrobot/config"
+	"github.com/reechou/wxrobot/models"
+	"github.com/reechou/wxrobot/wxweb"
+)
+
+type CampaignMsg struct {
+	MsgType string `json:"msgType"` // text img link
+	Msg     string `json:"msg,omitempty"`
+	Title   string `json:"title,omitempty"`
+	Desc    string `json:"desc,omitempty"`
+	Url     string `json:"url,omitempty"`
+}
+
+// campaignRunner controls a running campaign, pause blocks the runner before
+// the next target and cancel stops it.
+type campaignRunner struct {
+	sync.Mutex
+	status string
+	ctrl   chan struct{}
+}
+
+func (self *campaignRunner) setStatus(status string) {
+	self.Lock()
+	self.status = status
+	self.Unlock()
+	select {
+	case self.ctrl <- struct{}{}:
+	default:
+	}
+}
+
+func (self *campaignRunner) getStatus() string {
+	self.Lock()
+	defer self.Unlock()
+	return self.status
+}
+
+// wait blocks while paused, false if cancelled.
+func (self *campaignRunner) wait() bool {
+	for {
+		switch self.getStatus() {
+		case CAMPAIGN_STATUS_CANCELLED:
+			return false
+		case CAMPAIGN_STATUS_PAUSED:
+			<-self.ctrl
+		default:
+			return true
+		}
+	}
+}
+
+// sleep waits d and then while paused, false if cancelled.
+func (self *campaignRunner) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return self.wait()
+		case <-self.ctrl:
+			if self.getStatus() == CAMPAIGN_STATUS_CANCELLED {
+				return false
+			}
+		}
+	}
+}
+
+// campaignRunners keeps the runners of the running campaigns by id.
+type campaignRunners struct {
+	sync.Mutex
+	runners map[int64]*campaignRunner
+}
+
+func newCampaignRunners() *campaignRunners {
+	return &campaignRunners{runners: make(map[int64]*campaignRunner)}
+}
+
+// start runs the campaign in a new goroutine unless it is running.
+func (self *campaignRunners) start(id int64, status string, run func(r *campaignRunner)) {
+	self.Lock()
+	defer self.Unlock()
+
+	if _, ok := self.runners[id]; ok {
+		return
+	}
+	r := &campaignRunner{
+		status: status,
+		ctrl:   make(chan struct{}, 1),
+	}
+	self.runners[id] = r
+	go func() {
+		defer func() {
+			self.Lock()
+			delete(self.runners, id)
+			self.Unlock()
+		}()
+		run(r)
+	}()
+}
+
+// control sets the status of the running campaign, false if it is not running.
+func (self *campaignRunners) control(id int64, status string) bool {
+	self.Lock()
+	r := self.runners[id]
+	self.Unlock()
+	if r == nil {
+		return false
+	}
+	r.setStatus(status)
+	return true
+}
+
+func campaignActionStatus(action string) (string, error) {
+	switch action {
+	case CAMPAIGN_ACTION_PAUSE:
+		return CAMPAIGN_STATUS_PAUSED, nil
+	case CAMPAIGN_ACTION_RESUME:
+		return CAMPAIGN_STATUS_RUNNING, nil
+	case CAMPAIGN_ACTION_CANCEL:
+		return CAMPAIGN_STATUS_CANCELLED, nil
+	}
+	return "", fmt.Errorf("unknown campaign action[%s]", action)
+}
+
+// campaignThrottle is the pacing of a campaign, name is used in logs.
+type campaignThrottle struct {
+	name        string
+	robotWx     string
+	intervalMin int64
+	intervalMax int64
+}
+
+// throttle sends n targets one at a time with a random interval, waiting while
+// the robot is not login, ready is checked before every target. It reports
+// whether the campaign may be marked finished: false when it is cancelled, or
+// paused or cancelled while the last target is sent.
+func (self *campaignRunner) throttle(wxm *WxManager, t *campaignThrottle, n int, ready func() bool, send func(wx *wxweb.WxWeb, i int)) bool {
+	for i := 0; i < n; i++ {
+		if !self.wait() || (ready != nil && !ready()) {
+			return false
+		}
+		wx := wxm.GetWx(t.robotWx)
+		for wx == nil || !wx.IfLogin() {
+			logrus.Debugf("%s robot[%s] not login, waiting.", t.name, t.robotWx)
+			if !self.sleep(CAMPAIGN_WX_WAIT_INTERVAL * time.Second) {
+				return false
+			}
+			wx = wxm.GetWx(t.robotWx)
+		}
+
+		send(wx, i)
+
+		if i == n-1 {
+			break
+		}
+		interval := t.intervalMin
+		if t.intervalMax > t.intervalMin {
+			interval += rand.Int63n(t.intervalMax - t.intervalMin + 1)
+		}
+		if !self.sleep(time.Duration(interval) * time.Second) {
+			return false
+		}
+	}
+	// 发最后一个对象时可能被暂停或取消, 不能覆盖Control保存的状态
+	return self.getStatus() == CAMPAIGN_STATUS_RUNNING
+}
+
+// CampaignManager sends a message sequence to the groups or friends selected
+// by a filter, one target at a time with a random interval. The delivery
+// status of every target is kept in db, running campaigns go on after restart.
+type CampaignManager struct {
+	wxm *WxManager
+	cfg *config.Config
+
+	runners *campaignRunners
+}
+
+func NewCampaignManager(wxm *WxManager, cfg *config.Config) *CampaignManager {
+	cm := &CampaignManager{
+		wxm:     wxm,
+		cfg:     cfg,
+		runners: newCampaignRunners(),
+	}
+	if cfg.IfNeedOwnerDB {
+		cm.resume()
+	}
+	return cm
+}
+
+func (self *CampaignManager) resume() {
+	list, err := models.GetRobotCampaignsByStatus(CAMPAIGN_STATUS_RUNNING)
+	if err != nil {
+		logrus.Errorf("resume get running campaigns error: %v", err)
+		return
+	}
+	for i := range list {
+		logrus.Infof("resume robot[%s] campaign[%d][%s]", list[i].RobotWx, list[i].ID, list[i].Name)
+		self.start(&list[i])
+	}
+}
+
+func (self *CampaignManager) Create(info *RobotCampaignCreateReq) (*models.RobotCampaign, error) {
+	if !self.cfg.IfNeedOwnerDB {
+		return nil, fmt.Errorf("campaign needs owner db")
+	}
+	if len(info.Msgs) == 0 {
+		return nil, fmt.Errorf("campaign msgs is empty")
+	}
+	for _, v := range info.Msgs {
+		if v.MsgType != MSG_TYPE_TEXT && v.MsgType != MSG_TYPE_IMG && v.MsgType != MSG_TYPE_LINK {
+			return nil, fmt.Errorf("unknown campaign msg type[%s]", v.MsgType)
+		}
+	}
+	if info.IntervalMin <= 0 {
+		info.IntervalMin = CAMPAIGN_DEFAULT_INTERVAL_MIN
+	}
+	if info.IntervalMax < info.IntervalMin {
+		info.IntervalMax = info.IntervalMin
+		if info.IntervalMax < CAMPAIGN_DEFAULT_INTERVAL_MAX {
+			info.IntervalMax = CAMPAIGN_DEFAULT_INTERVAL_MAX
+		}
+	}
+	wx := self.wxm.GetWx(info.WechatNick)
+	if wx == nil {
+		return nil, fmt.Errorf("unknown this wechat[%s]", info.WechatNick)
+	}
+
+	var names []string
+	switch info.TargetType {
+	case CAMPAIGN_TARGET_GROUP:
+		for _, v := range wx.Contact.GroupsSnapshot() {
+			if ExecCheckGroupFunc(info.Filter, v.GetNickName(), v.IsOwner()) {
+				names = append(names, v.GetNickName())
+			}
+		}
+	case CAMPAIGN_TARGET_PEOPLE:
+		for _, v := range wx.Contact.FriendsSnapshot() {
+			// 好友以备注查找, 没有备注的找不回来
+			if v.RemarkName == "" {
+				continue
+			}
+			if self.wxm.label.CheckFriendFunc(info.WechatNick, info.Filter, &v) {
+				names = append(names, v.RemarkName)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown campaign target type[%s]", info.TargetType)
+	}
+	targets := make([]models.RobotCampaignTarget, 0, len(names))
+	seen := make(map[string]bool)
+	for _, v := range names {
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		targets = append(targets, models.RobotCampaignTarget{TargetName: v, Status: CAMPAIGN_TARGET_PENDING})
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no %s matches filter[%s]", info.TargetType, info.Filter)
+	}
+
+	msgs, err := json.Marshal(info.Msgs)
+	if err != nil {
+		return nil, err
+	}
+	campaign := &models.RobotCampaign{
+		RobotWx:     info.WechatNick,
+		Name:        info.Name,
+		TargetType:  info.TargetType,
+		Filter:      info.Filter,
+		Msgs:        string(msgs),
+		IntervalMin: info.IntervalMin,
+		IntervalMax: info.IntervalMax,
+		Status:      CAMPAIGN_STATUS_RUNNING,
+		Total:       int64(len(targets)),
+	}
+	if err = models.CreateRobotCampaign(campaign); err != nil {
+		return nil, err
+	}
+	for i := range targets {
+		targets[i].CampaignID = campaign.ID
+	}
+	if err = models.CreateRobotCampaignTargets(targets); err != nil {
+		campaign.Status = CAMPAIGN_STATUS_CANCELLED
+		models.UpdateRobotCampaignStatus(campaign)
+		return nil, err
+	}
+	self.start(campaign)
+
+	return campaign, nil
+}
+
+func (self *CampaignManager) Control(info *RobotCampaignControlReq) error {
+	if !self.cfg.IfNeedOwnerDB {
+		return fmt.Errorf("campaign needs owner db")
+	}
+	campaign := &models.RobotCampaign{ID: info.ID}
+	has, err := models.GetRobotCampaign(campaign)
+	if err != nil {
+		return err
+	}
+	if !has {
+		return fmt.Errorf("cannot found campaign[%d]", info.ID)
+	}
+	if campaign.Status == CAMPAIGN_STATUS_CANCELLED || campaign.Status == CAMPAIGN_STATUS_FINISHED {
+		return fmt.Errorf("campaign[%d] is already %s", info.ID, campaign.Status)
+	}
+
+	status, err := campaignActionStatus(info.Action)
+	if err != nil {
+		return err
+	}
+	campaign.Status = status
+	if err = models.UpdateRobotCampaignStatus(campaign); err != nil {
+		return err
+	}
+
+	if !self.runners.control(campaign.ID, status) && status == CAMPAIGN_STATUS_RUNNING {
+		// 重启后暂停的任务没有runner
+		self.start(campaign)
+	}
+	logrus.Infof("robot[%s] campaign[%d][%s] %s", campaign.RobotWx, campaign.ID, campaign.Name, info.Action)
+
+	return nil
+}
+
+func (self *CampaignManager) Progress(info *RobotCampaignProgressReq) (*RobotCampaignProgressRsp, error) {
+	if !self.cfg.IfNeedOwnerDB {
+		return nil, fmt.Errorf("campaign needs owner db")
+	}
+	campaign := &models.RobotCampaign{ID: info.ID}
+	has, err := models.GetRobotCampaign(campaign)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, fmt.Errorf("cannot found campaign[%d]", info.ID)
+	}
+	failed, err := models.GetRobotCampaignTargets(campaign.ID, CAMPAIGN_TARGET_FAILED)
+	if err != nil {
+		return nil, err
+	}
+	return &RobotCampaignProgressRsp{
+		Campaign: campaign,
+		Pending:  campaign.Total - campaign.Sent - campaign.Failed,
+		Failed:   failed,
+	}, nil
+}
+
+func (self *CampaignManager) start(campaign *models.RobotCampaign) {
+	var msgs []CampaignMsg
+	if err := json.Unmarshal([]byte(campaign.Msgs), &msgs); err != nil {
+		logrus.Errorf("campaign[%d] msgs[%s] json decode error: %v", campaign.ID, campaign.Msgs, err)
+		return
+	}
+
+	self.runners.start(campaign.ID, campaign.Status, func(r *campaignRunner) {
+		self.run(r, campaign, msgs)
+	})
+}
+
+func (self *CampaignManager) run(r *campaignRunner, campaign *models.RobotCampaign, msgs []CampaignMsg) {
+	targets, err := models.GetRobotCampaignTargets(campaign.ID, CAMPAIGN_TARGET_PENDING)
+	if err != nil {
+		logrus.Errorf("campaign[%d] get pending targets error: %v", campaign.ID, err)
+		return
+	}
+	throttle := &campaignThrottle{
+		name:        fmt.Sprintf("campaign[%d]", campaign.ID),
+		robotWx:     campaign.RobotWx,
+		intervalMin: campaign.IntervalMin,
+		intervalMax: campaign.IntervalMax,
+	}
+	finished := r.throttle(self.wxm, throttle, len(targets), nil, func(wx *wxweb.WxWeb, i int) {
+		t := &targets[i]
+		if err := self.sendTarget(wx, campaign, t.TargetName, msgs); err != nil {
+			logrus.Errorf("campaign[%d] send to %s[%s] error: %v", campaign.ID, campaign.TargetType, t.TargetName, err)
+			t.Status = CAMPAIGN_TARGET_FAILED
+			t.ErrMsg = err.Error()
+			campaign.Failed++
+		} else {
+			t.Status = CAMPAIGN_TARGET_SENT
+			campaign.Sent++
+		}
+		t.SentAt = time.Now().Unix()
+		if err := models.UpdateRobotCampaignTarget(t); err != nil {
+			logrus.Errorf("campaign[%d] update target[%s] error: %v", campaign.ID, t.TargetName, err)
+		}
+		if err := models.UpdateRobotCampaignProgress(campaign); err != nil {
+			logrus.Errorf("campaign[%d] update progress error: %v", campaign.ID, err)
+		}
+	})
+	if !finished {
+		return
+	}
+
+	campaign.Status = CAMPAIGN_STATUS_FINISHED
+	ok, err := models.UpdateRobotCampaignStatusFrom(campaign, CAMPAIGN_STATUS_RUNNING)
+	if err != nil {
+		logrus.Errorf("campaign[%d] update status error: %v", campaign.ID, err)
+		return
+	}
+	if !ok {
+		return
+	}
+	logrus.Infof("robot[%s] campaign[%d][%s] finished, sent[%d] failed[%d]",
+		campaign.RobotWx, campaign.ID, campaign.Name, campaign.Sent, campaign.Failed)
+}
+
+func (self *CampaignManager) sendTarget(wx *wxweb.WxWeb, campaign *models.RobotCampaign, name string, msgs []CampaignMsg) error {
+	var userName string
+	switch campaign.TargetType {
+	case CAMPAIGN_TARGET_GROUP:
+		ug := wx.Contact.FindGroup("", name)
+		if ug == nil {
+			return fmt.Errorf("cannot found group")
+		}
+		userName = ug.UserName
+	case CAMPAIGN_TARGET_PEOPLE:
+		uf := wx.Contact.FindFriend("", name)
+		if uf == nil {
+			return fmt.Errorf("cannot found friend")
+		}
+		userName = uf.UserName
+	}
+
+	for i, v := range msgs {
+		if i != 0 {
+			time.Sleep(time.Second)
+		}
+		var ok bool
+		switch v.MsgType {
+		case MSG_TYPE_TEXT:
+			ok = wx.Webwxsendmsg(strings.Replace(v.Msg, CAMPAIGN_NAME, name, -1), userName)
+		case MSG_TYPE_IMG:
+			ok = self.wxm.sendImg(userName, v.Msg, wx)
+		case MSG_TYPE_LINK:
+			ok = wx.WebwxsendmsgLink(strings.Replace(v.Title, CAMPAIGN_NAME, name, -1), v.Desc, v.Url, userName)
+		}
+		if !ok {
+			return fmt.Errorf("send %s msg error", v.MsgType)
+		}
+	}
+	return nil
+}
@@ -2,11 +2,12 @@ package logic
 
 // 变量
 const (
-	ALLWECHAT = "allwechat"
-	EMPTY     = "$empty"
-	FROMGROUP = "$fromgroup"
-	FROMUSER  = "$fromuser"
-	FROMMSG   = "$frommsg"
+	ALLWECHAT   = "allwechat"
+	ROLE_WECHAT = "$role" // 角色配置中的事件, 后接RobotType
+	EMPTY       = "$empty"
+	FROMGROUP   = "$fromgroup"
+	FROMUSER    = "$fromuser"
+	FROMMSG     = "$frommsg"
 )
 
 // 函数
@@ -14,7 +15,20 @@ const (
 	NOTINCLUDE      = "notinclude()"
 	INCLUDE         = "include()"
 	EQUAL           = "equal()"
+	PREFIX          = "prefix()"
+	SUFFIX          = "suffix()"
+	REGEX           = "regex()"  // 命名分组可在动作消息中用${name}引用
+	NOCASE          = "nocase()" // 忽略大小写, 可接其他函数: nocase()include()abc
+	NUM_GT          = "gt()"
+	NUM_GE          = "ge()"
+	NUM_LT          = "lt()"
+	NUM_LE          = "le()"
+	NUM_EQ          = "eq()"
+	NUM_NE          = "ne()"
 	STATE_GROUP_NUM = "stategroupnum()"
+	GROUP_STATS     = "groupstats()" // 群发言排行, 可接天数: groupstats()7
+	IS_OWNER        = "isowner()"    // 只匹配机器人是群主的群, 可接其他函数: isowner()include()xx
+	HAS_LABEL       = "haslabel()"   // 匹配有其中任一标签的联系人: haslabel()vip,svip
 )
 
 // 参数
@@ -36,6 +50,15 @@ const (
 	MSG_TYPE_TEXT  = "text"
 	MSG_TYPE_IMG   = "img"
 	MSG_TYPE_VIDEO = "video"
+	MSG_TYPE_LINK  = "link"
+)
+
+// 欢迎语变量
+const (
+	WELCOME_NICK     = "{nick}"
+	WELCOME_COUNT    = "{count}"
+	WELCOME_GROUP    = "{group}"
+	WELCOME_MAX_NICK = 10
 )
 
 // allevent默认不处理verifyuser消息
@@ -46,13 +69,155 @@ const (
 	DO_EVENT_CALLBACK     = "callback"
 	DO_EVENT_CALLBACK_RPC = "callbackrpc"
 	DO_EVENT_START_WEB_WX = "startwebwx"
+	DO_EVENT_FUNCTION     = "function"
+	DO_EVENT_ADD_LABEL    = "addlabel"
+	DO_EVENT_DEL_LABEL    = "dellabel"
 )
 
 const (
 	FUNC_EVENT_CHECK_GROUP_CHAT = "checkgroupchat"
 )
 
+// 事件规则类型
+const (
+	EVENT_RULE_FILTER = "filter"
+	EVENT_RULE_CRON   = "cron"
+)
+
+// checkgroupchat的处理动作
+const (
+	CHECK_GROUP_CHAT_NOTIFY = "notify"
+	CHECK_GROUP_CHAT_REVIVE = "revive"
+	CHECK_GROUP_CHAT_KICK   = "kick"
+	// 踢人间隔, 避免被限频
+	CHECK_GROUP_CHAT_KICK_INTERVAL = 2
+	// 发言记录只在内存里, 登录后跟踪群满3天才踢人, 未发言时长也不能小于3天
+	CHECK_GROUP_CHAT_KICK_MIN_TRACK = 3 * 24 * 3600
+	// 每次最多踢的人数
+	CHECK_GROUP_CHAT_KICK_LIMIT = 20
+)
+
+const (
+	GROUP_MSGS_MAX_WAIT = 30
+)
+
+// 拉群后私聊消息的替换
+const (
+	INVITE_MSG_NICK  = "{nick}"
+	INVITE_MSG_GROUP = "{group}"
+)
+
+// 后台任务控制
+const (
+	JOB_ACTION_STATUS = "status"
+	JOB_ACTION_PAUSE  = "pause"
+	JOB_ACTION_RESUME = "resume"
+	JOB_ACTION_CONFIG = "config"
+	JOB_ACTION_START  = "start"
+	JOB_ACTION_STOP   = "stop"
+	JOB_ACTION_REPORT = "report"
+	JOB_ACTION_LIST   = "list"
+	JOB_ACTION_CANCEL = "cancel"
+)
+
+// 群发任务
+const (
+	CAMPAIGN_TARGET_GROUP  = "group"
+	CAMPAIGN_TARGET_PEOPLE = "people"
+
+	CAMPAIGN_STATUS_RUNNING   = "running"
+	CAMPAIGN_STATUS_PAUSED    = "paused"
+	CAMPAIGN_STATUS_CANCELLED = "cancelled"
+	CAMPAIGN_STATUS_FINISHED  = "finished"
+	CAMPAIGN_STATUS_ABORTED   = "aborted"
+
+	CAMPAIGN_TARGET_PENDING = "pending"
+	CAMPAIGN_TARGET_SENT    = "sent"
+	CAMPAIGN_TARGET_FAILED  = "failed"
+
+	CAMPAIGN_ACTION_PAUSE  = "pause"
+	CAMPAIGN_ACTION_RESUME = "resume"
+	CAMPAIGN_ACTION_CANCEL = "cancel"
+
+	CAMPAIGN_DEFAULT_INTERVAL_MIN = 5
+	CAMPAIGN_DEFAULT_INTERVAL_MAX = 15
+	// 机器人未登录时检查的间隔
+	CAMPAIGN_WX_WAIT_INTERVAL = 30
+	// 消息中替换为群名或好友备注
+	CAMPAIGN_NAME = "{name}"
+)
+
+// 群统计
+const (
+	GROUP_STATS_KEY_GROUP    = "wxstat:g"
+	GROUP_STATS_KEY_MEMBER   = "wxstat:gm"
+	GROUP_STATS_KEY_MEDIA    = "wxstat:gmm"
+	GROUP_STATS_KEY_LAST     = "wxstat:gl"
+	GROUP_STATS_KEY_HOUR     = "wxstat:gmh"
+	GROUP_STATS_DAY_FORMAT   = "20060102"
+	GROUP_STATS_KEEP_DAYS    = 35
+	GROUP_STATS_DEFAULT_DAYS = 7
+	GROUP_STATS_DEFAULT_TOP  = 10
+)
+
+// 消息存档
+const (
+	MSG_ARCHIVE_CLEAN_INTERVAL = 3600
+	MSG_ARCHIVE_DEFAULT_LIMIT  = 20
+	MSG_ARCHIVE_MAX_LIMIT      = 100
+)
+
+const (
+	EVENT_MSG_CHAN_LEN     = 1024
+	WAIT_LOGIN_MAX_TIME    = 360
+	EXEC_MATCHER_CACHE_LEN = 1024 // 条件缓存上限, 满了清空
+)
+
+// 好友请求通过策略
+const (
+	FRIEND_POLICY_ACTION_ACCEPT = "accept"
+	FRIEND_POLICY_ACTION_REJECT = "reject"
+	FRIEND_POLICY_ACTION_DEFER  = "defer"
+
+	FRIEND_POLICY_OP_LIST = "list"
+	FRIEND_POLICY_OP_SET  = "set"
+	FRIEND_POLICY_OP_DEL  = "del"
+
+	FRIEND_REQUEST_STATUS_ACCEPTING = "accepting"
+	FRIEND_REQUEST_STATUS_ACCEPTED  = "accepted"
+	FRIEND_REQUEST_STATUS_FAILED    = "failed"
+	FRIEND_REQUEST_STATUS_DEFERRED  = "deferred"
+	FRIEND_REQUEST_STATUS_REJECTED  = "rejected"
+	FRIEND_REQUEST_STATUS_DECLINED  = "declined"
+	FRIEND_REQUEST_STATUS_EXPIRED   = "expired"
+
+	FRIEND_REQUEST_OP_APPROVE = "approve"
+	FRIEND_REQUEST_OP_REJECT  = "reject"
+	FRIEND_REQUEST_EXPIRE     = 3 * 24 * 3600
+
+	FRIEND_GREETING_INTERVAL = 2
+)
+
+// 批量加好友
+const (
+	FRIEND_CAMPAIGN_TARGET_ACCEPTED = "accepted"
+
+	FRIEND_CAMPAIGN_DEFAULT_INTERVAL_MIN = 60
+	FRIEND_CAMPAIGN_DEFAULT_INTERVAL_MAX = 180
+)
+
+// 联系人标签
+const (
+	LABEL_OP_LIST = "list"
+	LABEL_OP_ADD  = "add"
+	LABEL_OP_DEL  = "del"
+)
+
+// 群管规则
 const (
-	EVENT_MSG_CHAN_LEN  = 1024
-	WAIT_LOGIN_MAX_TIME = 360
+	GROUP_MOD_OP_LIST    = "list"
+	GROUP_MOD_OP_SET     = "set"
+	GROUP_MOD_OP_DEL     = "del"
+	GROUP_MOD_OP_LOG     = "log"
+	GROUP_MOD_OP_STRIKES = "strikes"
 )
@@ -1,6 +1,7 @@
 package logic
 
 import (
+	"strconv"
 	"strings"
 
 	"github.com/Sirupsen/logrus"
@@ -10,9 +11,9 @@ import (
 
 type EventCron struct {
 	wxm     *WxManager
+	Name    string
 	WeChat  string
 	Time    string
-	Do      string
 	DoEvent []DoEvent
 	stop    chan struct{}
 }
@@ -20,42 +21,57 @@ type EventCron struct {
 func (self *EventCron) Init(stop chan struct{}) {
 	self.stop = stop
 
-	doList := strings.Split(self.Do, ",")
-	for _, v := range doList {
-		doDetail := strings.Split(v, "^")
-		if len(doDetail) == 0 {
-			continue
+	go self.Run()
+}
+
+// newFunctionEvent: function^checkgroupchat^群过滤^间隔秒数^动作^参数, 动作和参数可省略
+func newFunctionEvent(wxm *WxManager, wechat string, argv []string) *FunctionEvent {
+	if len(argv) == 0 {
+		return nil
+	}
+	fe := &FunctionEvent{wxm: wxm, Function: argv[0]}
+	switch argv[0] {
+	case FUNC_EVENT_CHECK_GROUP_CHAT:
+		if len(argv) < 3 {
+			return nil
 		}
-		switch doDetail[0] {
-		case DO_EVENT_SENDMSG:
-			if len(doDetail) != 4 {
-				continue
-			}
-			msg := &SendMsgInfo{
-				WeChat:   self.WeChat,
-				ChatType: doDetail[1],
-				Name:     doDetail[2],
-			}
-			msgInfo := strings.Split(doDetail[3], ">>>")
-			if len(msgInfo) != 2 {
-				continue
+		interval, err := strconv.Atoi(argv[2])
+		if err != nil || interval <= 0 {
+			return nil
+		}
+		info := &CheckGroupChatInfo{
+			WeChat:           wechat,
+			Group:            argv[1],
+			LastChatInterval: interval,
+		}
+		if info.Group == EMPTY {
+			info.Group = ""
+		}
+		if len(argv) > 3 {
+			info.Action = argv[3]
+		}
+		if len(argv) > 4 {
+			info.Arg = strings.Replace(argv[4], "<br/>", "\n", -1)
+			// kick的参数是本次最多踢的人数
+			if info.Action == CHECK_GROUP_CHAT_KICK {
+				if info.KickLimit, err = strconv.Atoi(argv[4]); err != nil {
+					return nil
+				}
 			}
-			msg.MsgType = msgInfo[0]
-			msg.Msg = msgInfo[1]
-			msg.Msg = strings.Replace(msg.Msg, "<br/>", "\n", -1)
-			self.DoEvent = append(self.DoEvent, DoEvent{wxm: self.wxm, Type: DO_EVENT_SENDMSG, DoMsg: msg})
-		case DO_EVENT_VERIFY_USER:
-			self.DoEvent = append(self.DoEvent, DoEvent{wxm: self.wxm, Type: DO_EVENT_VERIFY_USER})
 		}
+		fe.Argv = info
+	default:
+		return nil
 	}
-	go self.Run()
+	return fe
 }
 
 func (self *EventCron) Run() {
-	logrus.Debugf("cron wechat[%s] time[%s] Do[%s] start run.", self.WeChat, self.Time, self.Do)
-	self.Time = strings.Replace(self.Time, ",", ", ", -1)
+	logrus.Debugf("cron[%s] wechat[%s] time[%s] start run.", self.Name, self.WeChat, self.Time)
 	c := cron.New()
-	c.AddFunc(self.Time, self.cronRun)
+	if err := c.AddFunc(self.Time, self.cronRun); err != nil {
+		logrus.Errorf("cron[%s] time[%s] error: %v", self.Name, self.Time, err)
+	}
 	c.Start()
 
 	select {
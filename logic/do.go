@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/Sirupsen/logrus"
@@ -33,6 +34,9 @@ func (self *DoEvent) Do(rMsg *ReceiveMsgInfo) {
 				MsgType:  msg.MsgType,
 				Msg:      msg.Msg,
 			}
+			if strings.HasPrefix(msgCopy.WeChat, ROLE_WECHAT) {
+				msgCopy.WeChat = rMsg.msg.BaseInfo.WechatNick
+			}
 			if msgCopy.Name == "$from" {
 				msgCopy.Name = rMsg.msg.BaseInfo.FromNickName
 			}
@@ -43,13 +47,25 @@ func (self *DoEvent) Do(rMsg *ReceiveMsgInfo) {
 			logrus.Errorf("translate to SendMsgInfo error.")
 		}
 	case DO_EVENT_VERIFY_USER:
-		self.wxm.VerifyUser(rMsg.msg)
+		self.wxm.friendPolicy.Verify(rMsg.msg)
+	case DO_EVENT_ADD_LABEL, DO_EVENT_DEL_LABEL:
+		label, ok := self.DoMsg.(string)
+		if ok {
+			self.wxm.label.LabelSender(label, self.Type == DO_EVENT_ADD_LABEL, rMsg.msg)
+		}
 	case DO_EVENT_CALLBACK:
 		self.call(rMsg)
 	case DO_EVENT_CALLBACK_RPC:
 		self.callrpc(rMsg)
 	case DO_EVENT_START_WEB_WX:
 		self.startwebwx(rMsg)
+	case DO_EVENT_FUNCTION:
+		fe, ok := self.DoMsg.(*FunctionEvent)
+		if ok {
+			fe.function()
+		} else {
+			logrus.Errorf("translate to FunctionEvent error.")
+		}
 	}
 }
 
@@ -238,11 +254,23 @@ func (self *DoEvent) changeString(sm *SendMsgInfo, rm *ReceiveMsgInfo) string {
 	if strings.Contains(sm.Msg, FROMMSG) {
 		result = strings.Replace(result, FROMMSG, rm.msg.Msg, -1)
 	}
+	for k, v := range rm.captures {
+		result = strings.Replace(result, "${"+k+"}", v, -1)
+	}
 
 	if strings.HasPrefix(sm.Msg, STATE_GROUP_NUM) {
 		result = strings.Replace(result, STATE_GROUP_NUM, "", -1)
 		result = self.wxm.StateGroupNum(sm.WeChat, result)
 	}
+	if strings.HasPrefix(sm.Msg, GROUP_STATS) {
+		days, _ := strconv.Atoi(strings.TrimSpace(strings.Replace(result, GROUP_STATS, "", -1)))
+		group := rm.msg.BaseInfo.FromGroupName
+		if group == "" {
+			// 定时任务发到指定的群
+			group = sm.Name
+		}
+		result = self.wxm.groupStats.Leaderboard(sm.WeChat, group, days)
+	}
 
 	return result
 }
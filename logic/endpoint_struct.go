@@ -1,5 +1,12 @@
 package logic
 
+import (
+	"encoding/json"
+
+	"github.com/reechou/wxrobot/models"
+	"github.com/reechou/wxrobot/wxweb"
+)
+
 const (
 	WX_RESPONSE_OK = iota
 	WX_RESPONSE_ERR
@@ -37,6 +44,231 @@ type RobotGetGroupMemberListReq struct {
 	GroupNickName string `json:"groupNickName"`
 }
 
+type RobotGetGroupInfoReq struct {
+	WechatNick    string `json:"wechatNick"`
+	GroupUserName string `json:"groupUserName"`
+	GroupNickName string `json:"groupNickName"`
+}
+
+type RobotGetGroupListReq struct {
+	WechatNick string `json:"wechatNick"`
+	OnlyOwner  bool   `json:"onlyOwner"`
+}
+
+type RobotCreateGroupReq struct {
+	WechatNick string   `json:"wechatNick"`
+	Topic      string   `json:"topic"`
+	Members    []string `json:"members"` // 好友username或昵称(备注)
+}
+
+type RobotGroupInviteReq struct {
+	WechatNick    string   `json:"wechatNick"`
+	GroupUserName string   `json:"groupUserName"`
+	GroupNickName string   `json:"groupNickName"`
+	Members       []string `json:"members"` // 好友username或昵称(备注)
+}
+
+type RobotGroupInviteRsp struct {
+	Group   wxweb.WxGroup             `json:"group"`
+	Results []wxweb.GroupInviteResult `json:"results"`
+}
+
+type RobotGroupRenameReq struct {
+	WechatNick    string `json:"wechatNick"`
+	GroupUserName string `json:"groupUserName"`
+	GroupNickName string `json:"groupNickName"`
+	Topic         string `json:"topic"`
+}
+
+type RobotGroupNameLockReq struct {
+	WechatNick    string `json:"wechatNick"`
+	GroupUserName string `json:"groupUserName"`
+	GroupNickName string `json:"groupNickName"`
+	Lock          bool   `json:"lock"`
+}
+
+type RobotGroupWelcomeReq struct {
+	WechatNick      string       `json:"wechatNick"`
+	GroupNickName   string       `json:"groupNickName"` // 为空时设置所有群的默认欢迎语
+	Enable          bool         `json:"enable"`
+	Msgs            []WelcomeMsg `json:"msgs"`
+	BatchSeconds    int64        `json:"batchSeconds"`
+	CooldownSeconds int64        `json:"cooldownSeconds"`
+}
+
+type RobotGetMessagesReq struct {
+	WechatNick string `json:"wechatNick"`
+	ChatType   string `json:"chatType"`
+	ChatName   string `json:"chatName"`
+	Sender     string `json:"sender"`
+	StartTime  int64  `json:"startTime"`
+	EndTime    int64  `json:"endTime"`
+	Keyword    string `json:"keyword"`
+	Cursor     int64  `json:"cursor"`
+	Limit      int    `json:"limit"`
+}
+
+type RobotGetMessagesRsp struct {
+	Messages   []models.RobotMessage `json:"messages"`
+	NextCursor int64                 `json:"nextCursor,omitempty"` // 为0时没有更多
+}
+
+type RobotGetGroupMsgsReq struct {
+	WechatNick    string `json:"wechatNick"`
+	GroupUserName string `json:"groupUserName"`
+	GroupNickName string `json:"groupNickName"`
+	LastMsgId     *int   `json:"lastMsgId"` // 已读到的msgId, -1或不传从缓冲中最早的开始
+	Wait          int    `json:"wait"`      // 没有新消息时最多等待的秒数
+}
+
+type RobotGetGroupMsgsRsp struct {
+	Msgs      []wxweb.MsgInfo `json:"msgs"`
+	Lost      bool            `json:"lost"` // lastMsgId之后的消息已不在缓冲中, msgs从最早的开始
+	LastMsgId int             `json:"lastMsgId"`
+}
+
+type RobotGetGroupStatsReq struct {
+	WechatNick    string `json:"wechatNick"`
+	GroupUserName string `json:"groupUserName"`
+	GroupNickName string `json:"groupNickName"`
+	Days          int    `json:"days"` // 默认7天
+	Top           int    `json:"top"`  // 发言排行人数, 默认10
+}
+
+type RobotCheckGroupChatReq struct {
+	WechatNick       string `json:"wechatNick"`
+	Group            string `json:"group"`            // 群过滤条件, 为空检查所有群
+	LastChatInterval int    `json:"lastChatInterval"` // 秒
+	Action           string `json:"action"`           // notify/revive/kick, 为空只检查
+	Arg              string `json:"arg"`
+	KickLimit        int    `json:"kickLimit"` // kick: 本次最多踢的人数, 0为默认
+	DryRun           bool   `json:"dryRun"`    // kick: 只列出要踢的人
+}
+
+type CheckGroupChatGroup struct {
+	GroupUserName string                `json:"groupUserName"`
+	GroupNickName string                `json:"groupNickName"`
+	LastMsgTime   int64                 `json:"lastMsgTime"` // 0表示登录后无人发言
+	Silent        bool                  `json:"silent"`
+	SilentMembers []wxweb.GroupUserInfo `json:"silentMembers"`
+	ToKick        []string              `json:"toKick,omitempty"` // 本次要踢的人, dryRun时不踢
+	Kicked        []string              `json:"kicked,omitempty"`
+}
+
+type RobotCheckGroupChatRsp struct {
+	Groups []CheckGroupChatGroup `json:"groups"`
+}
+
+type RobotCampaignCreateReq struct {
+	WechatNick  string        `json:"wechatNick"`
+	Name        string        `json:"name"`
+	TargetType  string        `json:"targetType"` // group people
+	Filter      string        `json:"filter"`     // include()/notinclude()/equal(), 群可加isowner(), 好友可用haslabel(), 为空选全部
+	Msgs        []CampaignMsg `json:"msgs"`
+	IntervalMin int64         `json:"intervalMin"` // 秒
+	IntervalMax int64         `json:"intervalMax"`
+}
+
+type RobotCampaignControlReq struct {
+	ID     int64  `json:"id"`
+	Action string `json:"action"` // pause resume cancel
+}
+
+type RobotCampaignProgressReq struct {
+	ID int64 `json:"id"`
+}
+
+type RobotCampaignProgressRsp struct {
+	Campaign *models.RobotCampaign        `json:"campaign"`
+	Pending  int64                        `json:"pending"`
+	Failed   []models.RobotCampaignTarget `json:"failed"`
+}
+
+type RobotFriendCampaignCreateReq struct {
+	WechatNick  string                 `json:"wechatNick"`
+	Name        string                 `json:"name"`
+	VerifyMsg   string                 `json:"verifyMsg"`   // 可用{nick} {group}
+	DayLimit    int64                  `json:"dayLimit"`    // 机器人每天最多发送, 0不限制
+	IntervalMin int64                  `json:"intervalMin"` // 秒
+	IntervalMax int64                  `json:"intervalMax"`
+	Targets     []FriendCampaignTarget `json:"targets"`
+	GroupFilter string                 `json:"groupFilter"` // 不为空时加入匹配群的全部成员
+}
+
+type RobotFriendCampaignProgressRsp struct {
+	Campaign *models.RobotFriendCampaign        `json:"campaign"`
+	Pending  int64                              `json:"pending"`
+	Targets  []models.RobotFriendCampaignTarget `json:"targets"`
+}
+
+type RobotAddGroupMemberJobReq struct {
+	WechatNick string                    `json:"wechatNick"`
+	Action     string                    `json:"action"` // status pause resume config, 默认status
+	Conf       *wxweb.AddGroupMemberConf `json:"conf,omitempty"`
+}
+
+type RobotDeadFriendScanReq struct {
+	WechatNick string                    `json:"wechatNick"`
+	Action     string                    `json:"action"` // start stop status report, 默认status
+	Conf       *wxweb.DeadFriendScanConf `json:"conf,omitempty"`
+	ID         int64                     `json:"id,omitempty"` // report: 查询db中的报告
+}
+
+type RobotFriendPolicyReq struct {
+	WechatNick string        `json:"wechatNick"`
+	Action     string        `json:"action"` // list set del, 默认list
+	Policy     *FriendPolicy `json:"policy,omitempty"`
+}
+
+type RobotGroupModerationReq struct {
+	WechatNick    string        `json:"wechatNick"`
+	Action        string        `json:"action"` // list set del log strikes, 默认list
+	Rule          *GroupModRule `json:"rule,omitempty"`
+	GroupNickName string        `json:"groupNickName,omitempty"` // log strikes: 为空返回所有群
+	Cursor        int64         `json:"cursor,omitempty"`        // log: 上一页最后一条的ID
+	Limit         int           `json:"limit,omitempty"`
+}
+
+type RobotFriendRequestsReq struct {
+	WechatNick string `json:"wechatNick"`
+	Action     string `json:"action"`           // list approve reject, 默认list
+	Status     string `json:"status,omitempty"` // list: 为空返回所有状态
+	Cursor     int64  `json:"cursor,omitempty"` // list: 上一页最后一条的ID
+	Limit      int    `json:"limit,omitempty"`
+	ID         int64  `json:"id,omitempty"`     // approve reject
+	Reason     string `json:"reason,omitempty"` // approve reject: 审核备注
+}
+
+type RobotContactLabelReq struct {
+	WechatNick string `json:"wechatNick"`
+	Action     string `json:"action"`             // list add del, 默认list
+	Label      string `json:"label,omitempty"`    // list: 为空返回所有标签; del: 为空删除联系人的所有标签
+	UserName   string `json:"userName,omitempty"` // 好友username, 或用备注nickName查找
+	NickName   string `json:"nickName,omitempty"`
+	ID         int64  `json:"id,omitempty"` // del: 按标签记录ID删除
+}
+
+type RobotJobsReq struct {
+	WechatNick string          `json:"wechatNick"`
+	Action     string          `json:"action"` // list start pause resume cancel status, 默认list
+	Name       string          `json:"name,omitempty"`
+	Argv       json.RawMessage `json:"argv,omitempty"` // start: 任务参数, 为空用启动时的argv
+}
+
+type RobotJobsListRsp struct {
+	Names []string        `json:"names"`
+	Jobs  []wxweb.JobInfo `json:"jobs"`
+}
+
+type RobotInviteCampaignReq struct {
+	WechatNick string                   `json:"wechatNick"`
+	Conf       wxweb.InviteCampaignConf `json:"conf"`
+}
+
+type RobotInviteReportReq struct {
+	ID int64 `json:"id"`
+}
+
 type RobotAddFriendReq struct {
 	WechatNick    string `json:"wechatNick"`
 	UserName      string `json:"userName"`
@@ -1,9 +1,9 @@
 package logic
 
 import (
-	"bufio"
-	"io"
-	"os"
+	"fmt"
+	"io/ioutil"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -55,99 +55,121 @@ func (self *EventManager) Reset() {
 	self.stop = make(chan struct{})
 }
 
-func (self *EventManager) ReloadFile() {
+// ReloadFile reloads the event file, the bad rules are skipped and returned.
+func (self *EventManager) ReloadFile() error {
 	self.Lock()
 	defer self.Unlock()
 
 	self.Reset()
-	self.loadFile()
+	errs := self.loadFile()
 	go self.Run()
+
+	if len(errs) == 0 {
+		return nil
+	}
+	msgs := make([]string, 0, len(errs))
+	for _, v := range errs {
+		msgs = append(msgs, v.Error())
+	}
+	return fmt.Errorf("%d bad event rules: %s", len(errs), strings.Join(msgs, "; "))
 }
 
-func (self *EventManager) loadFile() {
+func (self *EventManager) loadFile() []error {
+	errs := self.loadRoles()
+	defer func() {
+		for _, v := range errs {
+			logrus.Errorf("load event rule error: %v", v)
+		}
+	}()
 	logrus.Debugf("start load event file")
 	if self.cfg.WxEventFile == "" {
-		return
+		return errs
 	}
-	defer func() {
-		logrus.Infof("load event filter: %v.", self.filters)
-		logrus.Infof("load event file[%s] success.", self.cfg.WxEventFile)
-	}()
-	f, err := os.Open(self.cfg.WxEventFile)
+	data, err := ioutil.ReadFile(self.cfg.WxEventFile)
 	if err != nil {
 		logrus.Errorf("open file[%s] error: %v", self.cfg.WxEventFile, err)
-		return
+		return append(errs, err)
 	}
-	defer f.Close()
-	buf := bufio.NewReader(f)
-	for {
-		line, _, err := buf.ReadLine()
-		//logrus.Debug("************* ", string(line))
-		//line, err := buf.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			logrus.Errorf("load event file[%s] error: %v", self.cfg.WxEventFile, err)
-			break
-		}
-		if strings.HasPrefix(string(line), "#") {
+	if isStructuredEventFile(self.cfg.WxEventFile) {
+		errs = append(errs, self.loadRuleFile(data)...)
+	} else {
+		errs = append(errs, self.loadLineFile(data)...)
+	}
+	logrus.Infof("load event filter: %v.", self.filters)
+	logrus.Infof("load event file[%s] success.", self.cfg.WxEventFile)
+	return errs
+}
+
+func (self *EventManager) loadRuleFile(data []byte) []error {
+	rules, err := parseEventRuleFile(self.cfg.WxEventFile, data)
+	if err != nil {
+		return []error{err}
+	}
+	var errs []error
+	names := make(map[string]bool)
+	for i := range rules {
+		rule := &rules[i]
+		if names[rule.Name] {
+			errs = append(errs, &EventRuleError{File: self.cfg.WxEventFile, Line: rule.line, Rule: rule.Name, Err: fmt.Errorf("duplicate rule name")})
 			continue
 		}
-		//line = strings.Replace(line, "\n", "", -1)
-		argv := strings.Split(string(line), " ")
-		if len(argv) == 0 {
+		names[rule.Name] = true
+		if err = self.addRule(rule); err != nil {
+			errs = append(errs, &EventRuleError{File: self.cfg.WxEventFile, Line: rule.line, Rule: rule.Name, Err: err})
+		}
+	}
+	return errs
+}
+
+// loadLineFile loads the old event file, one rule a line.
+func (self *EventManager) loadLineFile(data []byte) []error {
+	var errs []error
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
-		switch argv[0] {
-		case "filter":
-			if len(argv) != 8 {
-				logrus.Errorf("filter argv error: %s len(argv)=%d", string(line), len(argv))
-				continue
-			}
-			f := &EventFilter{
-				wxm:      self.wxm,
-				WeChat:   argv[1],
-				Time:     argv[2],
-				Event:    argv[3],
-				Msg:      argv[4],
-				From:     argv[5],
-				FromType: argv[6],
-				Do:       argv[7],
-			}
-			if f.Msg == EMPTY {
-				f.Msg = ""
-			}
-			if f.From == EMPTY {
-				f.From = ""
-			}
-			if f.FromType == EMPTY {
-				f.FromType = ""
-			}
-			self.eventId++
-			f.Init(self.eventId, self.stop)
-			fv := self.filters[f.WeChat]
-			fv = append(fv, f)
-			self.filters[f.WeChat] = fv
-		case "timer":
-		case "cron":
-			if len(argv) != 4 {
-				logrus.Errorf("cron argv error: %s", string(line))
+		if err := self.loadLine(line, "", fmt.Sprintf("line-%d", i+1)); err != nil {
+			errs = append(errs, &EventRuleError{File: self.cfg.WxEventFile, Line: i + 1, Err: err})
+		}
+	}
+	return errs
+}
+
+// loadRoles reloads the robot role file and the filters of the roles, which
+// are kept under the role key instead of the wechat nick.
+func (self *EventManager) loadRoles() []error {
+	if err := wxweb.LoadRobotRoles(self.cfg.RobotRoleFile); err != nil {
+		return []error{err}
+	}
+	var errs []error
+	for _, role := range wxweb.RobotRoles() {
+		for i, line := range role.Events {
+			name := fmt.Sprintf("%s-%d", role.Name, i+1)
+			if !strings.HasPrefix(line, EVENT_RULE_FILTER+" ") {
+				errs = append(errs, &EventRuleError{File: self.cfg.RobotRoleFile, Rule: name, Err: fmt.Errorf("role only supports filter event")})
 				continue
 			}
-			c := &EventCron{
-				wxm:    self.wxm,
-				WeChat: argv[1],
-				Time:   argv[2],
-				Do:     argv[3],
+			if err := self.loadLine(line, roleWeChat(role.RobotType), name); err != nil {
+				errs = append(errs, &EventRuleError{File: self.cfg.RobotRoleFile, Rule: name, Err: err})
 			}
-			c.Init(self.stop)
-			cv := self.crons[c.WeChat]
-			cv = append(cv, c)
-			self.crons[c.WeChat] = cv
 		}
 	}
-	return
+	return errs
+}
+
+// loadLine loads one line of the old event file, wechat replaces the wechat
+// nick of the line when not empty.
+func (self *EventManager) loadLine(line, wechat, name string) error {
+	rule, err := parseEventLine(line)
+	if err != nil {
+		return err
+	}
+	rule.Name = name
+	if wechat != "" {
+		rule.WeChat = wechat
+	}
+	return self.addRule(rule)
 }
 
 func (self *EventManager) Run() {
@@ -165,8 +187,15 @@ func (self *EventManager) Run() {
 					case v.GetMsgChan() <- msg:
 					case <-msg.ctx.Done():
 						logrus.Errorf("receive msg into filter msg channal error: %v", msg.ctx.Err())
-						self.Unlock()
-						continue
+					}
+				}
+			}
+			if wx := self.wxm.GetWx(msg.msg.BaseInfo.WechatNick); wx != nil {
+				for _, v := range self.filters[roleWeChat(wx.RobotType())] {
+					select {
+					case v.GetMsgChan() <- msg:
+					case <-msg.ctx.Done():
+						logrus.Errorf("receive msg into filter msg channal error: %v", msg.ctx.Err())
 					}
 				}
 			}
@@ -177,8 +206,6 @@ func (self *EventManager) Run() {
 					case v.GetMsgChan() <- msg:
 					case <-msg.ctx.Done():
 						logrus.Errorf("receive msg into filter msg channal error: %v", msg.ctx.Err())
-						self.Unlock()
-						continue
 					}
 				}
 			}
@@ -204,3 +231,7 @@ func (self *EventManager) ReceiveMsg(msg *wxweb.ReceiveMsgInfo) {
 		return
 	}
 }
+
+func roleWeChat(robotType int) string {
+	return ROLE_WECHAT + strconv.Itoa(robotType)
+}
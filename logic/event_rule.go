@@ -0,0 +1,442 @@
+package logic
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/reechou/wxrobot/wxweb"
+	"github.com/robfig/cron"
+	"gopkg.in/yaml.v3"
+)
+
+// 结构化的事件规则文件, yaml或json, 文件后缀为.yaml/.yml/.json时按此格式加载
+type EventRuleFile struct {
+	Rules []EventRule `yaml:"rules" json:"rules"`
+}
+
+type EventRule struct {
+	Name   string         `yaml:"name" json:"name"`
+	Type   string         `yaml:"type,omitempty" json:"type,omitempty"` // filter cron, 默认filter
+	WeChat string         `yaml:"wechat" json:"wechat"`                 // 机器人昵称, allwechat匹配所有
+	Time   string         `yaml:"time,omitempty" json:"time,omitempty"` // filter: anytime; cron: cron表达式
+	When   EventCondition `yaml:"when,omitempty" json:"when,omitempty"` // filter的匹配条件
+	Do     []EventAction  `yaml:"do" json:"do"`
+
+	line int
+}
+
+type EventCondition struct {
+	Event    string `yaml:"event,omitempty" json:"event,omitempty"`
+	Msg      string `yaml:"msg,omitempty" json:"msg,omitempty"`   // include()/notinclude()/equal()/prefix()/suffix()/regex(), 可加nocase()
+	From     string `yaml:"from,omitempty" json:"from,omitempty"` // 群名条件, 可用isowner() haslabel()
+	FromType string `yaml:"fromType,omitempty" json:"fromType,omitempty"`
+	// 群人数, gt()100 ge() lt() le() eq() ne()
+	MemberNum string `yaml:"memberNum,omitempty" json:"memberNum,omitempty"`
+}
+
+type EventAction struct {
+	Type string `yaml:"type" json:"type"`
+	// sendmsg
+	ChatType string `yaml:"chatType,omitempty" json:"chatType,omitempty"`
+	Name     string `yaml:"name,omitempty" json:"name,omitempty"` // $from为消息发送者
+	MsgType  string `yaml:"msgType,omitempty" json:"msgType,omitempty"`
+	Msg      string `yaml:"msg,omitempty" json:"msg,omitempty"`
+	// callback callbackrpc startwebwx
+	Url string `yaml:"url,omitempty" json:"url,omitempty"`
+	// startwebwx, 同/startwx2的参数
+	Argv map[string]interface{} `yaml:"argv,omitempty" json:"argv,omitempty"`
+	// addlabel dellabel
+	Label string `yaml:"label,omitempty" json:"label,omitempty"`
+	// function
+	Function string   `yaml:"function,omitempty" json:"function,omitempty"`
+	Args     []string `yaml:"args,omitempty" json:"args,omitempty"`
+
+	line int
+}
+
+// EventRuleError reports a bad rule with the line of the rule file.
+type EventRuleError struct {
+	File string
+	Line int
+	Rule string
+	Err  error
+}
+
+func (self *EventRuleError) Error() string {
+	return fmt.Sprintf("%s:%d rule[%s]: %v", self.File, self.Line, self.Rule, self.Err)
+}
+
+func isStructuredEventFile(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// parseEventRuleFile decodes the yaml or json rule file, unknown fields are
+// errors so that typos are not dropped silently.
+func parseEventRuleFile(file string, data []byte) ([]EventRule, error) {
+	var rf EventRuleFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&rf); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+
+	// 再按节点解析一次, 取每条规则和动作的行号
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	if rules := yamlMapValue(yamlDocument(&root), "rules"); rules != nil && len(rules.Content) == len(rf.Rules) {
+		for i, r := range rules.Content {
+			rf.Rules[i].line = r.Line
+			if do := yamlMapValue(r, "do"); do != nil && len(do.Content) == len(rf.Rules[i].Do) {
+				for j, a := range do.Content {
+					rf.Rules[i].Do[j].line = a.Line
+				}
+			}
+		}
+	}
+	return rf.Rules, nil
+}
+
+func yamlDocument(n *yaml.Node) *yaml.Node {
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		return n.Content[0]
+	}
+	return n
+}
+
+func yamlMapValue(n *yaml.Node, key string) *yaml.Node {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// parseEventLine parses one line of the old event file:
+//
+//	filter 微信 时间 事件 消息条件 来源条件 来源类型 动作1|||动作2
+//	cron 微信 时间 动作1,动作2
+func parseEventLine(line string) (*EventRule, error) {
+	argv := strings.Split(line, " ")
+	rule := &EventRule{Type: argv[0]}
+	switch argv[0] {
+	case EVENT_RULE_FILTER:
+		if len(argv) != 8 {
+			return nil, fmt.Errorf("filter needs 8 fields, got %d", len(argv))
+		}
+		rule.WeChat = argv[1]
+		rule.Time = argv[2]
+		rule.When = EventCondition{
+			Event:    argv[3],
+			Msg:      argv[4],
+			From:     argv[5],
+			FromType: argv[6],
+		}
+		if rule.When.Msg == EMPTY {
+			rule.When.Msg = ""
+		}
+		if rule.When.From == EMPTY {
+			rule.When.From = ""
+		}
+		if rule.When.FromType == EMPTY {
+			rule.When.FromType = ""
+		}
+		for _, v := range strings.Split(argv[7], "|||") {
+			a, err := parseEventAction(v)
+			if err != nil {
+				return nil, err
+			}
+			rule.Do = append(rule.Do, *a)
+		}
+	case EVENT_RULE_CRON:
+		if len(argv) != 4 {
+			return nil, fmt.Errorf("cron needs 4 fields, got %d", len(argv))
+		}
+		rule.WeChat = argv[1]
+		// 旧格式的时间里不能有空格, 用逗号分隔
+		rule.Time = strings.Replace(argv[2], ",", ", ", -1)
+		for _, v := range strings.Split(argv[3], ",") {
+			a, err := parseEventAction(v)
+			if err != nil {
+				return nil, err
+			}
+			rule.Do = append(rule.Do, *a)
+		}
+	default:
+		return nil, fmt.Errorf("unknown event type[%s]", argv[0])
+	}
+	return rule, nil
+}
+
+func parseEventAction(do string) (*EventAction, error) {
+	doDetail := strings.Split(do, "^")
+	a := &EventAction{Type: doDetail[0]}
+	switch a.Type {
+	case DO_EVENT_SENDMSG:
+		if len(doDetail) != 4 {
+			return nil, fmt.Errorf("sendmsg[%s] needs sendmsg^chatType^name^msgType>>>msg", do)
+		}
+		msgInfo := strings.Split(doDetail[3], ">>>")
+		if len(msgInfo) != 2 {
+			return nil, fmt.Errorf("sendmsg[%s] needs msgType>>>msg", do)
+		}
+		a.ChatType = doDetail[1]
+		a.Name = doDetail[2]
+		a.MsgType = msgInfo[0]
+		a.Msg = strings.Replace(msgInfo[1], "<br/>", "\n", -1)
+	case DO_EVENT_VERIFY_USER:
+	case DO_EVENT_CALLBACK, DO_EVENT_CALLBACK_RPC:
+		if len(doDetail) != 2 {
+			return nil, fmt.Errorf("%s[%s] needs %s^url", a.Type, do, a.Type)
+		}
+		a.Url = doDetail[1]
+	case DO_EVENT_START_WEB_WX:
+		if len(doDetail) != 2 && len(doDetail) != 3 {
+			return nil, fmt.Errorf("startwebwx[%s] needs startwebwx^url^k>>>v&&&k>>>v", do)
+		}
+		a.Url = doDetail[1]
+		if len(doDetail) == 3 {
+			argv, err := parseStartWxArgv(doDetail[2])
+			if err != nil {
+				return nil, err
+			}
+			a.Argv = argv
+		}
+	case DO_EVENT_ADD_LABEL, DO_EVENT_DEL_LABEL:
+		if len(doDetail) != 2 {
+			return nil, fmt.Errorf("%s[%s] needs %s^label", a.Type, do, a.Type)
+		}
+		a.Label = doDetail[1]
+	case DO_EVENT_FUNCTION:
+		if len(doDetail) < 2 {
+			return nil, fmt.Errorf("function[%s] needs function^name^args", do)
+		}
+		a.Function = doDetail[1]
+		a.Args = doDetail[2:]
+	default:
+		return nil, fmt.Errorf("unknown action[%s]", do)
+	}
+	return a, nil
+}
+
+// parseStartWxArgv turns IfInvite>>>true&&&InviteMsg>>>xx into the /startwx2 argv.
+func parseStartWxArgv(s string) (map[string]interface{}, error) {
+	argv := &wxweb.StartWxArgv{}
+	for _, argvV := range strings.Split(s, "&&&") {
+		argvEqual := strings.Split(argvV, ">>>")
+		if len(argvEqual) != 2 {
+			return nil, fmt.Errorf("startwebwx argv[%s] needs k>>>v", argvV)
+		}
+		switch argvEqual[0] {
+		case START_WX_IfInvite:
+			argv.IfInvite = argvEqual[1] == "true"
+		case START_WX_IfInviteEndExit:
+			argv.IfInviteEndExit = argvEqual[1] == "true"
+		case START_WX_InviteMsg:
+			argv.InviteMsg = argvEqual[1]
+		case START_WX_IfClearWx:
+			argv.IfClearWx = argvEqual[1] == "true"
+		case START_WX_ClearWxMsg:
+			argv.ClearWxMsg = argvEqual[1]
+		case START_WX_ClearWxPrefix:
+			argv.ClearWxPrefix = argvEqual[1]
+		default:
+			return nil, fmt.Errorf("unknown startwebwx argv[%s]", argvEqual[0])
+		}
+	}
+	data, err := json.Marshal(argv)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err = json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// addRule validates the rule and starts the filter or the cron of it.
+func (self *EventManager) addRule(rule *EventRule) error {
+	if rule.Name == "" {
+		return fmt.Errorf("name cannot be empty")
+	}
+	if rule.WeChat == "" {
+		return fmt.Errorf("wechat cannot be empty")
+	}
+	if len(rule.Do) == 0 {
+		return fmt.Errorf("do cannot be empty")
+	}
+	var doEvents []DoEvent
+	for i := range rule.Do {
+		do, err := self.newDoEvent(rule.WeChat, &rule.Do[i])
+		if err != nil {
+			if rule.Do[i].line != 0 {
+				return fmt.Errorf("line %d: %v", rule.Do[i].line, err)
+			}
+			return err
+		}
+		doEvents = append(doEvents, *do)
+	}
+
+	switch rule.Type {
+	case "", EVENT_RULE_FILTER:
+		if rule.When.Event == "" {
+			return fmt.Errorf("when.event cannot be empty")
+		}
+		switch rule.When.FromType {
+		case "", CHAT_TYPE_PEOPLE, CHAT_TYPE_GROUP:
+		default:
+			return fmt.Errorf("unknown when.fromType[%s]", rule.When.FromType)
+		}
+		f := &EventFilter{
+			wxm:       self.wxm,
+			Name:      rule.Name,
+			WeChat:    rule.WeChat,
+			Time:      rule.Time,
+			Event:     rule.When.Event,
+			Msg:       rule.When.Msg,
+			From:      rule.When.From,
+			FromType:  rule.When.FromType,
+			MemberNum: rule.When.MemberNum,
+			DoEvent:   doEvents,
+		}
+		self.eventId++
+		if err := f.Init(self.eventId, self.stop); err != nil {
+			return err
+		}
+		self.filters[f.WeChat] = append(self.filters[f.WeChat], f)
+	case EVENT_RULE_CRON:
+		if _, err := cron.Parse(rule.Time); err != nil {
+			return fmt.Errorf("bad cron time[%s]: %v", rule.Time, err)
+		}
+		c := &EventCron{
+			wxm:     self.wxm,
+			Name:    rule.Name,
+			WeChat:  rule.WeChat,
+			Time:    rule.Time,
+			DoEvent: doEvents,
+		}
+		c.Init(self.stop)
+		self.crons[c.WeChat] = append(self.crons[c.WeChat], c)
+	default:
+		return fmt.Errorf("unknown rule type[%s]", rule.Type)
+	}
+	return nil
+}
+
+func (self *EventManager) newDoEvent(wechat string, a *EventAction) (*DoEvent, error) {
+	do := &DoEvent{wxm: self.wxm, Type: a.Type}
+	switch a.Type {
+	case DO_EVENT_SENDMSG:
+		switch a.ChatType {
+		case CHAT_TYPE_PEOPLE, CHAT_TYPE_GROUP:
+		default:
+			return nil, fmt.Errorf("sendmsg unknown chatType[%s]", a.ChatType)
+		}
+		if a.Name == "" || a.MsgType == "" || a.Msg == "" {
+			return nil, fmt.Errorf("sendmsg needs name, msgType and msg")
+		}
+		do.DoMsg = &SendMsgInfo{
+			WeChat:   wechat,
+			ChatType: a.ChatType,
+			Name:     a.Name,
+			MsgType:  a.MsgType,
+			Msg:      a.Msg,
+		}
+	case DO_EVENT_VERIFY_USER:
+	case DO_EVENT_CALLBACK, DO_EVENT_CALLBACK_RPC:
+		if a.Url == "" {
+			return nil, fmt.Errorf("%s needs url", a.Type)
+		}
+		do.DoMsg = a.Url
+	case DO_EVENT_START_WEB_WX:
+		if a.Url == "" {
+			return nil, fmt.Errorf("startwebwx needs url")
+		}
+		startWxArgv := NewStartWxArgv()
+		startWxArgv.Url = a.Url
+		if len(a.Argv) != 0 {
+			data, err := json.Marshal(a.Argv)
+			if err != nil {
+				return nil, fmt.Errorf("startwebwx argv error: %v", err)
+			}
+			dec := json.NewDecoder(bytes.NewReader(data))
+			dec.DisallowUnknownFields()
+			if err = dec.Decode(startWxArgv.Argv); err != nil {
+				return nil, fmt.Errorf("startwebwx argv error: %v", err)
+			}
+		}
+		do.DoMsg = startWxArgv
+	case DO_EVENT_ADD_LABEL, DO_EVENT_DEL_LABEL:
+		if a.Label == "" {
+			return nil, fmt.Errorf("%s needs label", a.Type)
+		}
+		do.DoMsg = a.Label
+	case DO_EVENT_FUNCTION:
+		fe := newFunctionEvent(self.wxm, wechat, append([]string{a.Function}, a.Args...))
+		if fe == nil {
+			return nil, fmt.Errorf("bad function[%s] args%v", a.Function, a.Args)
+		}
+		do.DoMsg = fe
+	default:
+		return nil, fmt.Errorf("unknown action type[%s]", a.Type)
+	}
+	return do, nil
+}
+
+// ConvertEventFile migrates the old event file to the yaml rule file, the
+// commented lines are skipped.
+func ConvertEventFile(file string, w io.Writer) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var rf EventRuleFile
+	var errs []string
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseEventLine(line)
+		if err != nil {
+			errs = append(errs, (&EventRuleError{File: file, Line: lineNo, Err: err}).Error())
+			continue
+		}
+		rule.Name = fmt.Sprintf("%s-%d", rule.Type, lineNo)
+		rf.Rules = append(rf.Rules, *rule)
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("convert event file error:\n%s", strings.Join(errs, "\n"))
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err = enc.Encode(&rf); err != nil {
+		return err
+	}
+	return enc.Close()
+}
@@ -0,0 +1,243 @@
+package logic
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEventLine(t *testing.T) {
+	tests := []struct {
+		line string
+		err  string
+		want *EventRule
+	}{
+		{
+			line: "filter allwechat anytime receivemsg include()hi,hello $empty group sendmsg^group^$from^text>>>hi<br/>there|||verifyuser",
+			want: &EventRule{
+				Type:   EVENT_RULE_FILTER,
+				WeChat: ALLWECHAT,
+				Time:   "anytime",
+				When:   EventCondition{Event: "receivemsg", Msg: "include()hi,hello", FromType: CHAT_TYPE_GROUP},
+				Do: []EventAction{
+					{Type: DO_EVENT_SENDMSG, ChatType: CHAT_TYPE_GROUP, Name: "$from", MsgType: "text", Msg: "hi\nthere"},
+					{Type: DO_EVENT_VERIFY_USER},
+				},
+			},
+		},
+		{
+			line: "cron robot 0,30,9,*,*,* sendmsg^people^bob^text>>>morning,callback^http://127.0.0.1/cb",
+			want: &EventRule{
+				Type:   EVENT_RULE_CRON,
+				WeChat: "robot",
+				Time:   "0, 30, 9, *, *, *",
+				Do: []EventAction{
+					{Type: DO_EVENT_SENDMSG, ChatType: CHAT_TYPE_PEOPLE, Name: "bob", MsgType: "text", Msg: "morning"},
+					{Type: DO_EVENT_CALLBACK, Url: "http://127.0.0.1/cb"},
+				},
+			},
+		},
+		{line: "filter allwechat anytime receivemsg $empty $empty verifyuser", err: "filter needs 8 fields, got 7"},
+		{line: "cron robot 0,0,9,*,*,* verifyuser extra", err: "cron needs 4 fields, got 5"},
+		{line: "timer robot 10 verifyuser", err: "unknown event type[timer]"},
+		{line: "filter allwechat anytime receivemsg $empty $empty $empty verifyuser|||sendmsg^group^g", err: "sendmsg[sendmsg^group^g] needs"},
+	}
+	for _, tt := range tests {
+		rule, err := parseEventLine(tt.line)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parse %q error %v, want %q", tt.line, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parse %q error: %v", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(rule, tt.want) {
+			t.Errorf("parse %q\n got %+v\nwant %+v", tt.line, rule, tt.want)
+		}
+	}
+}
+
+func TestParseEventAction(t *testing.T) {
+	tests := []struct {
+		do   string
+		err  string
+		want *EventAction
+	}{
+		{do: "sendmsg^people^$from^img>>>http://127.0.0.1/a.jpg", want: &EventAction{Type: DO_EVENT_SENDMSG, ChatType: CHAT_TYPE_PEOPLE, Name: "$from", MsgType: "img", Msg: "http://127.0.0.1/a.jpg"}},
+		{do: "sendmsg^people^text>>>hi", err: "needs sendmsg^chatType^name^msgType>>>msg"},
+		{do: "sendmsg^people^$from^text>>>a>>>b", err: "needs msgType>>>msg"},
+		{do: "verifyuser", want: &EventAction{Type: DO_EVENT_VERIFY_USER}},
+		{do: "callbackrpc^http://127.0.0.1/rpc", want: &EventAction{Type: DO_EVENT_CALLBACK_RPC, Url: "http://127.0.0.1/rpc"}},
+		{do: "callback", err: "needs callback^url"},
+		{do: "startwebwx^http://127.0.0.1/start", want: &EventAction{Type: DO_EVENT_START_WEB_WX, Url: "http://127.0.0.1/start"}},
+		{do: "startwebwx^http://127.0.0.1/start^IfInvite=true", err: "needs k>>>v"},
+		{do: "startwebwx^http://127.0.0.1/start^Unknown>>>1", err: "unknown startwebwx argv[Unknown]"},
+		{do: "addlabel^vip", want: &EventAction{Type: DO_EVENT_ADD_LABEL, Label: "vip"}},
+		{do: "dellabel", err: "needs dellabel^label"},
+		{do: "function^groupstats^7^top", want: &EventAction{Type: DO_EVENT_FUNCTION, Function: "groupstats", Args: []string{"7", "top"}}},
+		{do: "function", err: "needs function^name^args"},
+		{do: "kick^bob", err: "unknown action[kick^bob]"},
+	}
+	for _, tt := range tests {
+		a, err := parseEventAction(tt.do)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parse %q error %v, want %q", tt.do, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parse %q error: %v", tt.do, err)
+			continue
+		}
+		if !reflect.DeepEqual(a, tt.want) {
+			t.Errorf("parse %q\n got %+v\nwant %+v", tt.do, a, tt.want)
+		}
+	}
+}
+
+func TestParseEventActionStartWxArgv(t *testing.T) {
+	a, err := parseEventAction("startwebwx^http://127.0.0.1/start^IfInvite>>>true&&&InviteMsg>>>hi")
+	if err != nil {
+		t.Fatalf("parse startwebwx error: %v", err)
+	}
+	if a.Argv["ifInvite"] != true || a.Argv["inviteMsg"] != "hi" {
+		t.Errorf("startwebwx argv %v, want ifInvite and inviteMsg", a.Argv)
+	}
+}
+
+func TestParseEventRuleFile(t *testing.T) {
+	data := []byte(`rules:
+  - name: hello
+    wechat: allwechat
+    time: anytime
+    when:
+      event: receivemsg
+      msg: regex()^订单(?P<id>\d+)$
+      memberNum: gt()100
+    do:
+      - type: verifyuser
+      - type: sendmsg
+        chatType: people
+        name: $from
+        msgType: text
+        msg: 订单${id}
+  - name: morning
+    type: cron
+    wechat: robot
+    time: 0 0 9 * * *
+    do:
+      - type: callback
+        url: http://127.0.0.1/cb
+`)
+	rules, err := parseEventRuleFile("event.yaml", data)
+	if err != nil {
+		t.Fatalf("parse rule file error: %v", err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("got %d rules, want 2", len(rules))
+	}
+	if rules[0].line != 2 || rules[1].line != 16 {
+		t.Errorf("rule lines %d %d, want 2 16", rules[0].line, rules[1].line)
+	}
+	if len(rules[0].Do) != 2 || rules[0].Do[0].line != 10 || rules[0].Do[1].line != 11 {
+		t.Errorf("action lines of %+v, want 10 11", rules[0].Do)
+	}
+	if rules[0].When.Msg != `regex()^订单(?P<id>\d+)$` || rules[0].When.MemberNum != "gt()100" {
+		t.Errorf("rule when %+v", rules[0].When)
+	}
+	if rules[1].Type != EVENT_RULE_CRON || rules[1].Time != "0 0 9 * * *" || rules[1].Do[0].Url != "http://127.0.0.1/cb" {
+		t.Errorf("cron rule %+v", rules[1])
+	}
+}
+
+func TestParseEventRuleFileJson(t *testing.T) {
+	data := []byte(`{"rules": [{"name": "hello", "wechat": "allwechat", "when": {"event": "receivemsg"}, "do": [{"type": "addlabel", "label": "vip"}]}]}`)
+	rules, err := parseEventRuleFile("event.json", data)
+	if err != nil {
+		t.Fatalf("parse json rule file error: %v", err)
+	}
+	if len(rules) != 1 || rules[0].Name != "hello" || rules[0].When.Event != "receivemsg" || rules[0].Do[0].Label != "vip" {
+		t.Errorf("json rules %+v", rules)
+	}
+}
+
+func TestParseEventRuleFileUnknownField(t *testing.T) {
+	data := []byte(`rules:
+  - name: hello
+    wechat: allwechat
+    whn:
+      event: receivemsg
+    do:
+      - type: verifyuser
+`)
+	_, err := parseEventRuleFile("event.yaml", data)
+	if err == nil {
+		t.Fatalf("unknown field should be an error")
+	}
+	if !strings.Contains(err.Error(), "event.yaml") || !strings.Contains(err.Error(), "line 4") || !strings.Contains(err.Error(), "whn") {
+		t.Errorf("error %q should report the file, line and field", err)
+	}
+}
+
+func TestConvertEventFile(t *testing.T) {
+	lines := []string{
+		"# comment",
+		"filter allwechat anytime receivemsg include()hi $empty people sendmsg^people^$from^text>>>hello|||addlabel^vip",
+		"",
+		"cron robot 0,0,9,*,*,* callback^http://127.0.0.1/cb",
+	}
+	dir, err := ioutil.TempDir("", "event")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "event.txt")
+	if err = ioutil.WriteFile(file, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err = ConvertEventFile(file, &buf); err != nil {
+		t.Fatalf("convert event file error: %v", err)
+	}
+	rules, err := parseEventRuleFile("event.yaml", buf.Bytes())
+	if err != nil {
+		t.Fatalf("parse converted file error: %v\n%s", err, buf.String())
+	}
+	want := map[string]string{"filter-2": lines[1], "cron-4": lines[3]}
+	if len(rules) != len(want) {
+		t.Fatalf("got %d rules, want %d\n%s", len(rules), len(want), buf.String())
+	}
+	for _, r := range rules {
+		line, ok := want[r.Name]
+		if !ok {
+			t.Errorf("unexpected rule %q", r.Name)
+			continue
+		}
+		old, _ := parseEventLine(line)
+		old.Name = r.Name
+		r.line = 0
+		for i := range r.Do {
+			r.Do[i].line = 0
+		}
+		if !reflect.DeepEqual(&r, old) {
+			t.Errorf("converted rule %q\n got %+v\nwant %+v", r.Name, r, *old)
+		}
+	}
+
+	if err = ioutil.WriteFile(file, []byte(lines[0]+"\nfilter allwechat anytime verifyuser"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = ConvertEventFile(file, &buf)
+	if err == nil || !strings.Contains(err.Error(), file+":2") {
+		t.Errorf("convert error %v should report line 2", err)
+	}
+}
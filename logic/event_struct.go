@@ -9,6 +9,8 @@ type ReceiveMsgInfo struct {
 	msg    *wxweb.ReceiveMsgInfo
 	ctx    context.Context
 	cancel context.CancelFunc
+	// 条件中regex()的命名分组
+	captures map[string]string
 }
 
 type SendMsgInfo struct {
@@ -36,6 +38,11 @@ func NewStartWxArgv() *StartWxArgv {
 }
 
 type CheckGroupChatInfo struct {
-	Group            string
-	LastChatInterval int
+	WeChat           string
+	Group            string // 群过滤条件, 同filter的from, 为空检查所有群
+	LastChatInterval int    // 秒
+	Action           string // notify/revive/kick, 为空只检查
+	Arg              string // notify: 接收汇总的好友; revive: 在冷群里发送的消息
+	KickLimit        int    // kick: 本次最多踢的人数, 0为默认
+	DryRun           bool   // kick: 只列出要踢的人
 }
@@ -1,7 +1,13 @@
 package logic
 
 import (
+	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
 )
 
 func ExecCheckEqualFunc(f, value string) bool {
@@ -31,40 +37,188 @@ func ExecCheckEqualFunc(f, value string) bool {
 	return true
 }
 
-func ExecCheckFunc(f, value string) bool {
-	value = strings.Replace(value, " ", "", -1)
-	if strings.HasPrefix(f, NOTINCLUDE) {
-		v := strings.Replace(f, NOTINCLUDE, "", -1)
-		vSlice := strings.Split(v, ",")
-		for _, vStr := range vSlice {
-			if strings.Contains(value, vStr) {
-				return false
+// ExecMatcher is a compiled condition, conditions of the event filters are
+// compiled once at EventFilter.Init.
+type ExecMatcher struct {
+	op     string
+	values []string
+	re     *regexp.Regexp
+	num    float64
+	nocase bool
+}
+
+// CompileExecFunc compiles the condition, which may start with nocase():
+//
+//	include()a,b notinclude()a,b equal()a,b 去掉值中的空格后匹配
+//	prefix()a,b suffix()a,b regex()pattern 不去空格, regex的命名分组可在动作中用${name}
+//	gt()1 ge()1 lt()1 le()1 eq()1 ne()1 数值比较
+//
+// Conditions without a function match everything.
+func CompileExecFunc(f string) (*ExecMatcher, error) {
+	m := &ExecMatcher{}
+	if strings.HasPrefix(f, NOCASE) {
+		m.nocase = true
+		f = strings.TrimPrefix(f, NOCASE)
+	}
+	for _, op := range []string{NOTINCLUDE, INCLUDE, EQUAL, PREFIX, SUFFIX, REGEX, NUM_GT, NUM_GE, NUM_LT, NUM_LE, NUM_EQ, NUM_NE} {
+		if strings.HasPrefix(f, op) {
+			m.op = op
+			f = strings.TrimPrefix(f, op)
+			break
+		}
+	}
+	switch m.op {
+	case "":
+	case REGEX:
+		if m.nocase {
+			f = "(?i)" + f
+		}
+		re, err := regexp.Compile(f)
+		if err != nil {
+			return nil, fmt.Errorf("bad regex[%s]: %v", f, err)
+		}
+		m.re = re
+	case NUM_GT, NUM_GE, NUM_LT, NUM_LE, NUM_EQ, NUM_NE:
+		num, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number[%s] of %s", f, m.op)
+		}
+		m.num = num
+	default:
+		if m.nocase {
+			f = strings.ToLower(f)
+		}
+		m.values = strings.Split(f, ",")
+	}
+	return m, nil
+}
+
+// Match checks the value, the named groups of regex() are returned.
+func (self *ExecMatcher) Match(value string) (bool, map[string]string) {
+	if self.nocase && self.re == nil {
+		value = strings.ToLower(value)
+	}
+	switch self.op {
+	case NOTINCLUDE:
+		value = strings.Replace(value, " ", "", -1)
+		for _, v := range self.values {
+			if strings.Contains(value, v) {
+				return false, nil
 			}
 		}
-	} else if strings.HasPrefix(f, INCLUDE) {
-		v := strings.Replace(f, INCLUDE, "", -1)
-		vSlice := strings.Split(v, ",")
-		for _, vStr := range vSlice {
-			if strings.Contains(value, vStr) {
-				return true
+	case INCLUDE:
+		value = strings.Replace(value, " ", "", -1)
+		for _, v := range self.values {
+			if strings.Contains(value, v) {
+				return true, nil
 			}
 		}
-		return false
-	} else if strings.HasPrefix(f, EQUAL) {
-		v := strings.Replace(f, EQUAL, "", -1)
-		vSlice := strings.Split(v, ",")
-		for _, vStr := range vSlice {
-			if value == vStr {
-				return true
+		return false, nil
+	case EQUAL:
+		value = strings.Replace(value, " ", "", -1)
+		return self.any(value, func(s, v string) bool { return s == v }), nil
+	case PREFIX:
+		return self.any(value, strings.HasPrefix), nil
+	case SUFFIX:
+		return self.any(value, strings.HasSuffix), nil
+	case REGEX:
+		sub := self.re.FindStringSubmatch(value)
+		if sub == nil {
+			return false, nil
+		}
+		var captures map[string]string
+		for i, name := range self.re.SubexpNames() {
+			if name == "" {
+				continue
+			}
+			if captures == nil {
+				captures = make(map[string]string)
 			}
+			captures[name] = sub[i]
+		}
+		return true, captures
+	case NUM_GT, NUM_GE, NUM_LT, NUM_LE, NUM_EQ, NUM_NE:
+		num, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return false, nil
 		}
+		switch self.op {
+		case NUM_GT:
+			return num > self.num, nil
+		case NUM_GE:
+			return num >= self.num, nil
+		case NUM_LT:
+			return num < self.num, nil
+		case NUM_LE:
+			return num <= self.num, nil
+		case NUM_EQ:
+			return num == self.num, nil
+		default:
+			return num != self.num, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (self *ExecMatcher) any(value string, f func(s, v string) bool) bool {
+	for _, v := range self.values {
+		if f(value, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// execMatchers caches the compiled conditions of ExecCheckFunc, conditions
+// also come from http requests, so the cache is dropped when it is full.
+var execMatchers = struct {
+	sync.Mutex
+	m map[string]*ExecMatcher
+}{m: make(map[string]*ExecMatcher)}
+
+func getExecMatcher(f string) (*ExecMatcher, error) {
+	execMatchers.Lock()
+	defer execMatchers.Unlock()
+
+	if m, ok := execMatchers.m[f]; ok {
+		return m, nil
+	}
+	m, err := CompileExecFunc(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(execMatchers.m) >= EXEC_MATCHER_CACHE_LEN {
+		execMatchers.m = make(map[string]*ExecMatcher)
+	}
+	execMatchers.m[f] = m
+	return m, nil
+}
+
+// ExecCheckFunc checks the condition, compiled conditions are cached.
+func ExecCheckFunc(f, value string) bool {
+	m, err := getExecMatcher(f)
+	if err != nil {
+		logrus.Errorf("exec check func[%s] error: %v", f, err)
 		return false
 	}
+	ok, _ := m.Match(value)
+	return ok
+}
 
-	return true
+// ExecCheckGroupFunc checks a group condition, which may start with isowner().
+func ExecCheckGroupFunc(f, groupName string, isOwner bool) bool {
+	if strings.HasPrefix(f, IS_OWNER) {
+		if !isOwner {
+			return false
+		}
+		f = strings.TrimPrefix(f, IS_OWNER)
+	}
+	return ExecCheckFunc(f, groupName)
 }
 
 func ExecGetArgvFunc(f string) string {
+	f = strings.TrimPrefix(f, IS_OWNER)
 	var v string
 	if strings.HasPrefix(f, NOTINCLUDE) {
 		v = strings.Replace(f, NOTINCLUDE, "", -1)
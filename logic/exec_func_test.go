@@ -0,0 +1,114 @@
+package logic
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestExecMatcher(t *testing.T) {
+	tests := []struct {
+		f     string
+		value string
+		want  bool
+	}{
+		// 旧函数去掉值中的空格
+		{"include()ab,cd", "x a b", true},
+		{"include()ab,cd", "xyz", false},
+		{"notinclude()ab,cd", "x a b", false},
+		{"notinclude()ab,cd", "xyz", true},
+		{"equal()ab,cd", " c d ", true},
+		{"equal()ab,cd", "abc", false},
+		{"", "anything", true},
+		{"unknown()x", "anything", true},
+
+		{"prefix()订单,order", "订单123", true},
+		{"prefix()订单,order", "my order", false},
+		{"prefix()ab", "a b", false},
+		{"suffix()吗,?", "在吗", true},
+		{"suffix()吗,?", "吗在", false},
+		{"regex()^\\d{3}$", "123", true},
+		{"regex()^\\d{3}$", "1234", false},
+
+		{"nocase()include()ABC", "x a b c", true},
+		{"nocase()equal()Hello", "HELLO", true},
+		{"nocase()prefix()VIP", "vip001", true},
+		{"nocase()suffix()com", "A.COM", true},
+		{"nocase()regex()^hello", "HeLLo world", true},
+		{"regex()^hello", "HeLLo world", false},
+		{"prefix()VIP", "vip001", false},
+
+		{"gt()100", "101", true},
+		{"gt()100", "100", false},
+		{"ge()100", "100", true},
+		{"lt()1.5", "1.4", true},
+		{"lt()1.5", "1.5", false},
+		{"le()1.5", "1.5", true},
+		{"eq()5", " 5.0 ", true},
+		{"ne()5", "6", true},
+		{"ne()5", "5", false},
+		{"gt()100", "abc", false},
+		{"ne()5", "abc", false},
+
+		// equal()是字符串相等, eq()是数值相等
+		{"equal()5", "5.0", false},
+		{"eq()5", "5.0", true},
+		{"equal()5", "5", true},
+	}
+	for _, tt := range tests {
+		m, err := CompileExecFunc(tt.f)
+		if err != nil {
+			t.Errorf("compile %q error: %v", tt.f, err)
+			continue
+		}
+		if ok, _ := m.Match(tt.value); ok != tt.want {
+			t.Errorf("%q match %q = %v, want %v", tt.f, tt.value, ok, tt.want)
+		}
+		if ok := ExecCheckFunc(tt.f, tt.value); ok != tt.want {
+			t.Errorf("ExecCheckFunc(%q, %q) = %v, want %v", tt.f, tt.value, ok, tt.want)
+		}
+	}
+}
+
+func TestCompileExecFuncError(t *testing.T) {
+	for _, f := range []string{"regex()(", "gt()abc", "nocase()eq()"} {
+		if _, err := CompileExecFunc(f); err == nil {
+			t.Errorf("compile %q should fail", f)
+		}
+		if ExecCheckFunc(f, "1") {
+			t.Errorf("bad condition %q should not match", f)
+		}
+	}
+}
+
+func TestExecMatcherCaptures(t *testing.T) {
+	m, err := CompileExecFunc(`nocase()regex()^ORDER(?P<id>\d+)-(?P<sku>\w+)$`)
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	ok, captures := m.Match("order42-abc")
+	want := map[string]string{"id": "42", "sku": "abc"}
+	if !ok || !reflect.DeepEqual(captures, want) {
+		t.Errorf("match = %v %v, want true %v", ok, captures, want)
+	}
+	if ok, captures = m.Match("order-abc"); ok || captures != nil {
+		t.Errorf("no match should have no captures, got %v %v", ok, captures)
+	}
+
+	m, _ = CompileExecFunc(`regex()^\d+$`)
+	if ok, captures = m.Match("42"); !ok || captures != nil {
+		t.Errorf("regex without named groups = %v %v, want true nil", ok, captures)
+	}
+}
+
+func TestExecMatcherCacheBound(t *testing.T) {
+	for i := 0; i < EXEC_MATCHER_CACHE_LEN+10; i++ {
+		ExecCheckFunc(fmt.Sprintf("equal()%d", i), "0")
+	}
+	execMatchers.Lock()
+	n := len(execMatchers.m)
+	execMatchers.Unlock()
+	if n > EXEC_MATCHER_CACHE_LEN {
+		t.Errorf("cache has %d conditions, limit %d", n, EXEC_MATCHER_CACHE_LEN)
+	}
+}
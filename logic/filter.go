@@ -1,6 +1,8 @@
 package logic
 
 import (
+	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/Sirupsen/logrus"
@@ -8,16 +10,22 @@ import (
 )
 
 type EventFilter struct {
-	eventId  int
-	wxm      *WxManager
-	WeChat   string
-	Time     string
-	Event    string
-	Msg      string
-	From     string
-	FromType string
-	Do       string
-	DoEvent  []DoEvent
+	eventId   int
+	wxm       *WxManager
+	Name      string
+	WeChat    string
+	Time      string
+	Event     string
+	Msg       string
+	From      string
+	FromType  string
+	MemberNum string // 群人数条件, gt()100
+	DoEvent   []DoEvent
+
+	msgMatcher       *ExecMatcher
+	fromMatcher      *ExecMatcher
+	fromOwner        bool
+	memberNumMatcher *ExecMatcher
 
 	msgChan chan *ReceiveMsgInfo
 	stop    chan struct{}
@@ -27,86 +35,70 @@ func (self *EventFilter) GetMsgChan() chan *ReceiveMsgInfo {
 	return self.msgChan
 }
 
-func (self *EventFilter) Init(eventId int, stop chan struct{}) {
+// Init compiles the conditions and starts the filter.
+func (self *EventFilter) Init(eventId int, stop chan struct{}) error {
+	var err error
+	if self.msgMatcher, err = CompileExecFunc(self.Msg); err != nil {
+		return fmt.Errorf("msg condition: %v", err)
+	}
+	if !strings.HasPrefix(self.From, HAS_LABEL) {
+		from := self.From
+		if strings.HasPrefix(from, IS_OWNER) {
+			self.fromOwner = true
+			from = strings.TrimPrefix(from, IS_OWNER)
+		}
+		if self.fromMatcher, err = CompileExecFunc(from); err != nil {
+			return fmt.Errorf("from condition: %v", err)
+		}
+	}
+	if self.memberNumMatcher, err = CompileExecFunc(self.MemberNum); err != nil {
+		return fmt.Errorf("memberNum condition: %v", err)
+	}
+
 	self.msgChan = make(chan *ReceiveMsgInfo, EVENT_MSG_CHAN_LEN)
 	self.stop = stop
 	self.eventId = eventId
 
-	doList := strings.Split(self.Do, "|||")
-	for _, v := range doList {
-		doDetail := strings.Split(v, "^")
-		if len(doDetail) == 0 {
-			continue
+	go self.Run()
+	return nil
+}
+
+// match checks the conditions of the message, the named groups of the
+// regex() conditions are returned.
+func (self *EventFilter) match(msg *wxweb.ReceiveMsgInfo) (bool, map[string]string) {
+	ok, captures := self.msgMatcher.Match(msg.Msg)
+	if !ok {
+		return false, nil
+	}
+	if strings.HasPrefix(self.From, HAS_LABEL) {
+		if !self.wxm.label.CheckSender(self.From, msg) {
+			return false, nil
 		}
-		switch doDetail[0] {
-		case DO_EVENT_SENDMSG:
-			if len(doDetail) != 4 {
-				continue
-			}
-			msg := &SendMsgInfo{
-				WeChat:   self.WeChat,
-				ChatType: doDetail[1],
-				Name:     doDetail[2],
-			}
-			msgInfo := strings.Split(doDetail[3], ">>>")
-			if len(msgInfo) != 2 {
-				continue
-			}
-			msg.MsgType = msgInfo[0]
-			msg.Msg = msgInfo[1]
-			msg.Msg = strings.Replace(msg.Msg, "<br/>", "\n", -1)
-			self.DoEvent = append(self.DoEvent, DoEvent{wxm: self.wxm, Type: DO_EVENT_SENDMSG, DoMsg: msg})
-		case DO_EVENT_VERIFY_USER:
-			self.DoEvent = append(self.DoEvent, DoEvent{wxm: self.wxm, Type: DO_EVENT_VERIFY_USER})
-		case DO_EVENT_CALLBACK:
-			if len(doDetail) != 2 {
-				continue
-			}
-			self.DoEvent = append(self.DoEvent, DoEvent{wxm: self.wxm, Type: DO_EVENT_CALLBACK, DoMsg: doDetail[1]})
-		case DO_EVENT_START_WEB_WX:
-			if len(doDetail) != 2 && len(doDetail) != 3 {
-				logrus.Errorf("filter init error: %v len(doDetail)=%d", doDetail, len(doDetail))
-				continue
-			}
-			startWxArgv := NewStartWxArgv()
-			startWxArgv.Url = doDetail[1]
-			if len(doDetail) == 3 {
-				argvInfo := strings.Split(doDetail[2], "&&&")
-				for _, argvV := range argvInfo {
-					argvEqual := strings.Split(argvV, ">>>")
-					if len(argvEqual) != 2 {
-						continue
-					}
-					switch argvEqual[0] {
-					case START_WX_IfInvite:
-						if argvEqual[1] == "true" {
-							startWxArgv.Argv.IfInvite = true
-						}
-					case START_WX_IfInviteEndExit:
-						if argvEqual[1] == "true" {
-							startWxArgv.Argv.IfInviteEndExit = true
-						}
-					case START_WX_InviteMsg:
-						startWxArgv.Argv.InviteMsg = argvEqual[1]
-					case START_WX_IfClearWx:
-						if argvEqual[1] == "true" {
-							startWxArgv.Argv.IfClearWx = true
-						}
-					case START_WX_ClearWxMsg:
-						startWxArgv.Argv.ClearWxMsg = argvEqual[1]
-					case START_WX_ClearWxPrefix:
-						startWxArgv.Argv.ClearWxPrefix = argvEqual[1]
-					}
-				}
+	} else if msg.BaseInfo.FromType == CHAT_TYPE_GROUP {
+		if self.fromOwner && !msg.IsGroupOwner {
+			return false, nil
+		}
+		ok, fromCaptures := self.fromMatcher.Match(msg.BaseInfo.FromGroupName)
+		if !ok {
+			return false, nil
+		}
+		for k, v := range fromCaptures {
+			if captures == nil {
+				captures = make(map[string]string)
 			}
-			self.DoEvent = append(self.DoEvent, DoEvent{wxm: self.wxm, Type: DO_EVENT_START_WEB_WX, DoMsg: startWxArgv})
+			captures[k] = v
 		}
 	}
-	go self.Run()
+	if self.MemberNum != "" {
+		if ok, _ := self.memberNumMatcher.Match(strconv.Itoa(msg.GroupMemberNum)); !ok {
+			return false, nil
+		}
+	}
+	return true, captures
 }
 
 func (self *EventFilter) Run() {
-	logrus.Debugf("filter wechat[%s] Event[%s] Do[%s] start run.", self.WeChat, self.Event, self.Do)
+	logrus.Debugf("filter[%s] wechat[%s] Event[%s] start run.", self.Name, self.WeChat, self.Event)
 	for {
 		select {
 		case msg := <-self.msgChan:
@@ -125,24 +117,19 @@ func (self *EventFilter) Run() {
 					continue
 				}
 			}
-			if self.Msg != "" {
-				if !ExecCheckFunc(self.Msg, msg.msg.Msg) {
-					continue
-				}
-			}
 			//logrus.Debugf("filter[%d] msg: %v", self.eventId, msg.msg)
-			if self.From != "" {
-				if msg.msg.BaseInfo.FromType == CHAT_TYPE_GROUP {
-					if !ExecCheckFunc(self.From, msg.msg.BaseInfo.FromGroupName) {
-						continue
-					}
-				}
+			ok, captures := self.match(msg.msg)
+			if !ok {
+				continue
 			}
+			// 消息会发给所有filter, 分组结果放在副本里
+			m := *msg
+			m.captures = captures
 			for _, v := range self.DoEvent {
-				v.Do(msg)
+				v.Do(&m)
 			}
 		case <-self.stop:
-			logrus.Infof("filter do[%s] stopped", self.Do)
+			logrus.Infof("filter[%s] stopped", self.Name)
 			return
 		}
 	}
@@ -0,0 +1,362 @@
+package logic
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/reechou/wxrobot/config"
+	"github.com/reechou/wxrobot/models"
+	"github.com/reechou/wxrobot/wxweb"
+)
+
+type FriendCampaignTarget struct {
+	UserName  string `json:"userName,omitempty"`
+	NickName  string `json:"nickName,omitempty"`
+	GroupName string `json:"groupName,omitempty"` // 群成员, 发送时按群名和昵称查找
+}
+
+// FriendCampaignManager sends friend requests to the uploaded targets one at
+// a time with a random interval under the daily cap of the robot. The outcome
+// of every target is kept in db, a target is accepted when the robot receives
+// the receiveadd of it, running campaigns go on after restart.
+type FriendCampaignManager struct {
+	wxm *WxManager
+	cfg *config.Config
+
+	runners *campaignRunners
+}
+
+func NewFriendCampaignManager(wxm *WxManager, cfg *config.Config) *FriendCampaignManager {
+	fcm := &FriendCampaignManager{
+		wxm:     wxm,
+		cfg:     cfg,
+		runners: newCampaignRunners(),
+	}
+	if cfg.IfNeedOwnerDB {
+		fcm.resume()
+	}
+	return fcm
+}
+
+func (self *FriendCampaignManager) resume() {
+	list, err := models.GetRobotFriendCampaignsByStatus(CAMPAIGN_STATUS_RUNNING)
+	if err != nil {
+		logrus.Errorf("resume get running friend campaigns error: %v", err)
+		return
+	}
+	for i := range list {
+		logrus.Infof("resume robot[%s] friend campaign[%d][%s]", list[i].RobotWx, list[i].ID, list[i].Name)
+		self.start(&list[i])
+	}
+}
+
+func (self *FriendCampaignManager) Create(info *RobotFriendCampaignCreateReq) (*models.RobotFriendCampaign, error) {
+	if !self.cfg.IfNeedOwnerDB {
+		return nil, fmt.Errorf("friend campaign needs owner db")
+	}
+	if info.IntervalMin <= 0 {
+		info.IntervalMin = FRIEND_CAMPAIGN_DEFAULT_INTERVAL_MIN
+	}
+	if info.IntervalMax < info.IntervalMin {
+		info.IntervalMax = info.IntervalMin
+		if info.IntervalMax < FRIEND_CAMPAIGN_DEFAULT_INTERVAL_MAX {
+			info.IntervalMax = FRIEND_CAMPAIGN_DEFAULT_INTERVAL_MAX
+		}
+	}
+	wx := self.wxm.GetWx(info.WechatNick)
+	if wx == nil {
+		return nil, fmt.Errorf("unknown this wechat[%s]", info.WechatNick)
+	}
+
+	list := info.Targets
+	if info.GroupFilter != "" {
+		for _, ug := range wx.Contact.GroupsSnapshot() {
+			if !ExecCheckGroupFunc(info.GroupFilter, ug.GetNickName(), ug.IsOwner()) {
+				continue
+			}
+			for _, m := range ug.MembersSnapshot() {
+				list = append(list, FriendCampaignTarget{UserName: m.UserName, NickName: m.NickName, GroupName: ug.GetNickName()})
+			}
+		}
+	}
+	var members map[string]FriendCampaignTarget
+	targets := make([]models.RobotFriendCampaignTarget, 0, len(list))
+	seen := make(map[string]bool)
+	for _, v := range list {
+		if v.UserName == "" && (v.GroupName == "" || v.NickName == "") {
+			continue
+		}
+		if v.UserName == wx.Session.MyUserName || (v.UserName != "" && wx.Contact.GetFriend(v.UserName) != nil) {
+			continue
+		}
+		t := models.RobotFriendCampaignTarget{
+			RobotWx:   info.WechatNick,
+			UserName:  v.UserName,
+			NickName:  v.NickName,
+			GroupName: v.GroupName,
+			Status:    CAMPAIGN_TARGET_PENDING,
+		}
+		if t.GroupName == "" {
+			// username重新登录后会变, 群成员按所在的群名和昵称保存, 重启后才能找回,
+			// 其他(如名片)只在本次登录内按username发送
+			if members == nil {
+				members = groupMembersByUserName(wx)
+			}
+			if m, ok := members[t.UserName]; ok {
+				t.GroupName = m.GroupName
+				t.NickName = m.NickName
+			} else {
+				t.LoginUserName = wx.Session.MyUserName
+			}
+		}
+		key := t.UserName
+		if t.GroupName != "" {
+			key = t.GroupName + "\n" + t.NickName
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		targets = append(targets, t)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no friend campaign targets")
+	}
+
+	campaign := &models.RobotFriendCampaign{
+		RobotWx:     info.WechatNick,
+		Name:        info.Name,
+		VerifyMsg:   info.VerifyMsg,
+		DayLimit:    info.DayLimit,
+		IntervalMin: info.IntervalMin,
+		IntervalMax: info.IntervalMax,
+		Status:      CAMPAIGN_STATUS_RUNNING,
+		Total:       int64(len(targets)),
+	}
+	if err := models.CreateRobotFriendCampaign(campaign); err != nil {
+		return nil, err
+	}
+	for i := range targets {
+		targets[i].CampaignID = campaign.ID
+	}
+	if err := models.CreateRobotFriendCampaignTargets(targets); err != nil {
+		campaign.Status = CAMPAIGN_STATUS_CANCELLED
+		models.UpdateRobotFriendCampaignStatus(campaign)
+		return nil, err
+	}
+	self.start(campaign)
+
+	return campaign, nil
+}
+
+func (self *FriendCampaignManager) Control(info *RobotCampaignControlReq) error {
+	if !self.cfg.IfNeedOwnerDB {
+		return fmt.Errorf("friend campaign needs owner db")
+	}
+	campaign := &models.RobotFriendCampaign{ID: info.ID}
+	has, err := models.GetRobotFriendCampaign(campaign)
+	if err != nil {
+		return err
+	}
+	if !has {
+		return fmt.Errorf("cannot found friend campaign[%d]", info.ID)
+	}
+	if campaign.Status == CAMPAIGN_STATUS_CANCELLED || campaign.Status == CAMPAIGN_STATUS_FINISHED {
+		return fmt.Errorf("friend campaign[%d] is already %s", info.ID, campaign.Status)
+	}
+
+	status, err := campaignActionStatus(info.Action)
+	if err != nil {
+		return err
+	}
+	campaign.Status = status
+	if err = models.UpdateRobotFriendCampaignStatus(campaign); err != nil {
+		return err
+	}
+
+	if !self.runners.control(campaign.ID, status) && status == CAMPAIGN_STATUS_RUNNING {
+		self.start(campaign)
+	}
+	logrus.Infof("robot[%s] friend campaign[%d][%s] %s", campaign.RobotWx, campaign.ID, campaign.Name, info.Action)
+
+	return nil
+}
+
+func (self *FriendCampaignManager) Progress(info *RobotCampaignProgressReq) (*RobotFriendCampaignProgressRsp, error) {
+	if !self.cfg.IfNeedOwnerDB {
+		return nil, fmt.Errorf("friend campaign needs owner db")
+	}
+	campaign := &models.RobotFriendCampaign{ID: info.ID}
+	has, err := models.GetRobotFriendCampaign(campaign)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, fmt.Errorf("cannot found friend campaign[%d]", info.ID)
+	}
+	targets, err := models.GetRobotFriendCampaignTargets(campaign.ID, "")
+	if err != nil {
+		return nil, err
+	}
+	return &RobotFriendCampaignProgressRsp{
+		Campaign: campaign,
+		Pending:  campaign.Total - campaign.Sent - campaign.Failed,
+		Targets:  targets,
+	}, nil
+}
+
+// ReceiveMsg marks the sent target accepted when it becomes a friend.
+func (self *FriendCampaignManager) ReceiveMsg(msg *wxweb.ReceiveMsgInfo) {
+	if !self.cfg.IfNeedOwnerDB || msg.BaseInfo.ReceiveEvent != wxweb.RECEIVE_EVENT_ADD {
+		return
+	}
+	robot := msg.BaseInfo.WechatNick
+	t, err := models.FindRobotFriendCampaignTarget(robot, CAMPAIGN_TARGET_SENT, msg.BaseInfo.FromUserName, "")
+	if err == nil && t == nil {
+		// 重新登录后username变了, 按昵称找, 新好友的备注是"昵称__时间"
+		nick := msg.BaseInfo.FromNickName
+		if i := strings.LastIndex(nick, "__"); i > 0 {
+			nick = nick[:i]
+		}
+		t, err = models.FindRobotFriendCampaignTarget(robot, CAMPAIGN_TARGET_SENT, "", nick)
+	}
+	if err != nil {
+		logrus.Errorf("find robot friend campaign target error: %v", err)
+		return
+	}
+	if t == nil {
+		return
+	}
+	t.Status = FRIEND_CAMPAIGN_TARGET_ACCEPTED
+	t.AcceptedAt = time.Now().Unix()
+	if err = models.UpdateRobotFriendCampaignTarget(t); err != nil {
+		logrus.Errorf("friend campaign[%d] update target[%s] error: %v", t.CampaignID, t.NickName, err)
+		return
+	}
+	if err = models.IncrRobotFriendCampaignAccepted(t.CampaignID); err != nil {
+		logrus.Errorf("friend campaign[%d] incr accepted error: %v", t.CampaignID, err)
+	}
+	logrus.Infof("robot[%s] friend campaign[%d] target[%s] accepted", robot, t.CampaignID, t.NickName)
+}
+
+func (self *FriendCampaignManager) start(campaign *models.RobotFriendCampaign) {
+	self.runners.start(campaign.ID, campaign.Status, func(r *campaignRunner) {
+		self.run(r, campaign)
+	})
+}
+
+func (self *FriendCampaignManager) run(r *campaignRunner, campaign *models.RobotFriendCampaign) {
+	targets, err := models.GetRobotFriendCampaignTargets(campaign.ID, CAMPAIGN_TARGET_PENDING)
+	if err != nil {
+		logrus.Errorf("friend campaign[%d] get pending targets error: %v", campaign.ID, err)
+		return
+	}
+	throttle := &campaignThrottle{
+		name:        fmt.Sprintf("friend campaign[%d]", campaign.ID),
+		robotWx:     campaign.RobotWx,
+		intervalMin: campaign.IntervalMin,
+		intervalMax: campaign.IntervalMax,
+	}
+	ready := func() bool {
+		return self.waitDayLimit(r, campaign)
+	}
+	finished := r.throttle(self.wxm, throttle, len(targets), ready, func(wx *wxweb.WxWeb, i int) {
+		t := &targets[i]
+		if err := self.sendTarget(wx, campaign, t); err != nil {
+			logrus.Errorf("friend campaign[%d] add target[%s][%s] error: %v", campaign.ID, t.GroupName, t.NickName, err)
+			t.Status = CAMPAIGN_TARGET_FAILED
+			t.ErrMsg = err.Error()
+			campaign.Failed++
+		} else {
+			t.Status = CAMPAIGN_TARGET_SENT
+			campaign.Sent++
+		}
+		t.SentAt = time.Now().Unix()
+		if err := models.UpdateRobotFriendCampaignTarget(t); err != nil {
+			logrus.Errorf("friend campaign[%d] update target[%s] error: %v", campaign.ID, t.NickName, err)
+		}
+		if err := models.UpdateRobotFriendCampaignProgress(campaign); err != nil {
+			logrus.Errorf("friend campaign[%d] update progress error: %v", campaign.ID, err)
+		}
+	})
+	if !finished {
+		return
+	}
+
+	campaign.Status = CAMPAIGN_STATUS_FINISHED
+	ok, err := models.UpdateRobotFriendCampaignStatusFrom(campaign, CAMPAIGN_STATUS_RUNNING)
+	if err != nil {
+		logrus.Errorf("friend campaign[%d] update status error: %v", campaign.ID, err)
+		return
+	}
+	if !ok {
+		return
+	}
+	logrus.Infof("robot[%s] friend campaign[%d][%s] finished, sent[%d] failed[%d]",
+		campaign.RobotWx, campaign.ID, campaign.Name, campaign.Sent, campaign.Failed)
+}
+
+// waitDayLimit sleeps to the next day when the robot has sent DayLimit
+// requests today, false if cancelled.
+func (self *FriendCampaignManager) waitDayLimit(r *campaignRunner, campaign *models.RobotFriendCampaign) bool {
+	if campaign.DayLimit <= 0 {
+		return true
+	}
+	for {
+		now := time.Now()
+		dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		num, err := models.CountRobotFriendCampaignSent(campaign.RobotWx, dayStart.Unix())
+		if err != nil {
+			logrus.Errorf("friend campaign[%d] count sent error: %v", campaign.ID, err)
+			return true
+		}
+		if num < campaign.DayLimit {
+			return true
+		}
+		wait := dayStart.AddDate(0, 0, 1).Sub(now)
+		logrus.Infof("friend campaign[%d] robot[%s] sent %d today, wait %v.", campaign.ID, campaign.RobotWx, num, wait)
+		if !r.sleep(wait) {
+			return false
+		}
+	}
+}
+
+// groupMembersByUserName returns the group and nickname of the members of the
+// robot groups by username.
+func groupMembersByUserName(wx *wxweb.WxWeb) map[string]FriendCampaignTarget {
+	members := make(map[string]FriendCampaignTarget)
+	for _, ug := range wx.Contact.GroupsSnapshot() {
+		for _, m := range ug.MembersSnapshot() {
+			if _, ok := members[m.UserName]; !ok {
+				members[m.UserName] = FriendCampaignTarget{UserName: m.UserName, NickName: m.NickName, GroupName: ug.GetNickName()}
+			}
+		}
+	}
+	return members
+}
+
+func (self *FriendCampaignManager) sendTarget(wx *wxweb.WxWeb, campaign *models.RobotFriendCampaign, t *models.RobotFriendCampaignTarget) error {
+	if t.GroupName != "" {
+		ug := wx.Contact.FindGroup("", t.GroupName)
+		if ug == nil {
+			return fmt.Errorf("cannot found group")
+		}
+		m := ug.GetMemberFromNickList(t.NickName)
+		if m == nil {
+			return fmt.Errorf("cannot found member in group")
+		}
+		t.UserName = m.UserName
+	} else if t.LoginUserName != wx.Session.MyUserName {
+		return fmt.Errorf("username is invalid after the robot relogin")
+	}
+	if wx.Contact.GetFriend(t.UserName) != nil {
+		return fmt.Errorf("already friend")
+	}
+	verifyMsg := strings.NewReplacer(WELCOME_NICK, t.NickName, WELCOME_GROUP, t.GroupName).Replace(campaign.VerifyMsg)
+	if !wx.WebwxverifyuserAdd(wxweb.WX_VERIFY_USER_OP_ADD, verifyMsg, t.UserName) {
+		return fmt.Errorf("webwx verify user add error")
+	}
+	return nil
+}
@@ -0,0 +1,471 @@
+package logic
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/reechou/wxrobot/config"
+	"github.com/reechou/wxrobot/models"
+	"github.com/reechou/wxrobot/wxweb"
+)
+
+type FriendPolicy struct {
+	Name         string       `json:"name"`
+	Enable       bool         `json:"enable"`
+	Priority     int64        `json:"priority"`
+	SourceWechat string       `json:"sourceWechat,omitempty"`
+	SourceNick   string       `json:"sourceNick,omitempty"`
+	City         string       `json:"city,omitempty"`
+	Content      string       `json:"content,omitempty"`
+	Sex          int64        `json:"sex,omitempty"`
+	Action       string       `json:"action"` // accept reject defer
+	DayLimit     int64        `json:"dayLimit,omitempty"`
+	DelayMin     int64        `json:"delayMin,omitempty"`
+	DelayMax     int64        `json:"delayMax,omitempty"`
+	Greetings    []WelcomeMsg `json:"greetings,omitempty"`
+}
+
+// FriendPolicyManager decides the friend requests by the policies of the
+// robot in db. Without owner db or any enabled policy every request is
+// accepted at once as before.
+type FriendPolicyManager struct {
+	sync.Mutex
+
+	wxm *WxManager
+	cfg *config.Config
+}
+
+func NewFriendPolicyManager(wxm *WxManager, cfg *config.Config) *FriendPolicyManager {
+	return &FriendPolicyManager{
+		wxm: wxm,
+		cfg: cfg,
+	}
+}
+
+func (self *FriendPolicyManager) Verify(msg *wxweb.ReceiveMsgInfo) {
+	if !self.cfg.IfNeedOwnerDB {
+		self.wxm.VerifyUser(msg)
+		return
+	}
+	policies, err := models.GetRobotFriendPolicies(msg.BaseInfo.WechatNick)
+	if err != nil {
+		logrus.Errorf("get robot friend policies error: %v", err)
+	}
+	var enabled []models.RobotFriendPolicy
+	for _, v := range policies {
+		if v.IfEnable != 0 {
+			enabled = append(enabled, v)
+		}
+	}
+	if len(enabled) == 0 {
+		self.wxm.VerifyUser(msg)
+		return
+	}
+
+	request := &models.RobotFriendRequest{
+		RobotWx:       msg.BaseInfo.WechatNick,
+		UserName:      msg.BaseInfo.FromUserName,
+		UserNick:      msg.AddFriend.UserNick,
+		UserWechat:    msg.AddFriend.UserWechat,
+		SourceWechat:  msg.AddFriend.SourceWechat,
+		SourceNick:    msg.AddFriend.SourceNick,
+		City:          msg.AddFriend.UserCity,
+		Sex:           int64(msg.AddFriend.UserSex),
+		VerifyContent: msg.AddFriend.VerifyContent,
+		Ticket:        msg.AddFriend.Ticket,
+	}
+	policy := matchFriendPolicy(enabled, &msg.AddFriend)
+	if policy == nil {
+		request.Status = FRIEND_REQUEST_STATUS_DEFERRED
+		request.Reason = "no policy matched"
+		self.queue(request)
+		return
+	}
+	request.PolicyName = policy.Name
+
+	switch policy.Action {
+	case FRIEND_POLICY_ACTION_ACCEPT:
+		if !self.tryAccept(request, policy) {
+			return
+		}
+	case FRIEND_POLICY_ACTION_REJECT:
+		request.Status = FRIEND_REQUEST_STATUS_REJECTED
+		request.Reason = "rejected by policy"
+		self.queue(request)
+		return
+	default:
+		request.Status = FRIEND_REQUEST_STATUS_DEFERRED
+		request.Reason = "deferred by policy"
+		self.queue(request)
+		return
+	}
+
+	delay := policy.DelayMin
+	if policy.DelayMax > policy.DelayMin {
+		delay += rand.Int63n(policy.DelayMax - policy.DelayMin + 1)
+	}
+	if delay <= 0 {
+		// 不延时的在后续事件前通过, 后续事件拿到的是备注后的昵称
+		self.accept(msg, request, policy)
+		return
+	}
+	m := *msg
+	time.AfterFunc(time.Duration(delay)*time.Second, func() {
+		self.accept(&m, request, policy)
+	})
+}
+
+// tryAccept records the request as accepting, or defers it when the day
+// limit of the policy is reached.
+func (self *FriendPolicyManager) tryAccept(request *models.RobotFriendRequest, policy *models.RobotFriendPolicy) bool {
+	self.Lock()
+	defer self.Unlock()
+
+	request.Status = FRIEND_REQUEST_STATUS_ACCEPTING
+	if policy.DayLimit > 0 {
+		now := time.Now()
+		dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).Unix()
+		num, err := models.CountRobotFriendRequests(request.RobotWx, policy.Name, dayStart,
+			FRIEND_REQUEST_STATUS_ACCEPTING, FRIEND_REQUEST_STATUS_ACCEPTED)
+		if err != nil {
+			logrus.Errorf("count robot friend requests error: %v", err)
+		} else if num >= policy.DayLimit {
+			request.Status = FRIEND_REQUEST_STATUS_DEFERRED
+			request.Reason = fmt.Sprintf("day limit %d reached", policy.DayLimit)
+		}
+	}
+	// 没记下的请求不再通过, 否则后面会更新一条ID为0的记录
+	if err := self.queue(request); err != nil {
+		return false
+	}
+	return request.Status == FRIEND_REQUEST_STATUS_ACCEPTING
+}
+
+func (self *FriendPolicyManager) queue(request *models.RobotFriendRequest) error {
+	if err := models.CreateRobotFriendRequest(request); err != nil {
+		logrus.Errorf("wx[%s] queue friend request[%s] error: %v", request.RobotWx, request.UserNick, err)
+		return err
+	}
+	logrus.Infof("wx[%s] friend request[%s] from[%s] %s by policy[%s] %s",
+		request.RobotWx, request.UserNick, request.SourceNick, request.Status, request.PolicyName, request.Reason)
+	return nil
+}
+
+func (self *FriendPolicyManager) accept(msg *wxweb.ReceiveMsgInfo, request *models.RobotFriendRequest, policy *models.RobotFriendPolicy) {
+	// 延时期间机器人登出的请求已过期
+	current := &models.RobotFriendRequest{ID: request.ID}
+	has, err := models.GetRobotFriendRequest(current)
+	if err != nil {
+		logrus.Errorf("get robot friend request error: %v", err)
+		return
+	}
+	if !has || current.Status != FRIEND_REQUEST_STATUS_ACCEPTING {
+		logrus.Infof("wx[%s] friend request[%s] is no longer accepting, skip.", request.RobotWx, request.UserNick)
+		return
+	}
+	ok := self.wxm.VerifyUser(msg)
+	request.Status = FRIEND_REQUEST_STATUS_ACCEPTED
+	if !ok {
+		request.Status = FRIEND_REQUEST_STATUS_FAILED
+		request.Reason = "verify user error"
+	}
+	if err = models.UpdateRobotFriendRequestStatus(request); err != nil {
+		logrus.Errorf("update robot friend request error: %v", err)
+	}
+	if ok {
+		// 不延时时在事件处理的协程里, 问候要间隔发送, 不能阻塞事件
+		go self.greet(msg.BaseInfo.WechatNick, msg.BaseInfo.FromUserName, msg.BaseInfo.FromNickName, policy)
+	}
+}
+
+func (self *FriendPolicyManager) greet(wechat, userName, nick string, policy *models.RobotFriendPolicy) {
+	if policy.Greetings == "" {
+		return
+	}
+	var greetings []WelcomeMsg
+	if err := json.Unmarshal([]byte(policy.Greetings), &greetings); err != nil {
+		logrus.Errorf("friend policy[%s] greetings[%s] json decode error: %v", policy.Name, policy.Greetings, err)
+		return
+	}
+	wx := self.wxm.GetWx(wechat)
+	if wx == nil {
+		logrus.Errorf("greet unknown this wechat[%s].", wechat)
+		return
+	}
+	replacer := strings.NewReplacer(WELCOME_NICK, nick)
+	for _, v := range greetings {
+		time.Sleep(FRIEND_GREETING_INTERVAL * time.Second)
+		if !self.wxm.sendTypedMsg(wx, userName, v, replacer) {
+			logrus.Errorf("wx[%s] greet friend[%s] with[%v] error", wechat, nick, v)
+		}
+	}
+}
+
+// Requests lists the friend requests of the robot or reviews one of them.
+func (self *FriendPolicyManager) Requests(info *RobotFriendRequestsReq) (interface{}, error) {
+	if !self.cfg.IfNeedOwnerDB {
+		return nil, fmt.Errorf("friend requests needs owner db")
+	}
+	self.expire(info.WechatNick, time.Now().Unix()-FRIEND_REQUEST_EXPIRE, "ticket expired")
+
+	switch info.Action {
+	case "", FRIEND_POLICY_OP_LIST:
+		limit := info.Limit
+		if limit <= 0 {
+			limit = MSG_ARCHIVE_DEFAULT_LIMIT
+		} else if limit > MSG_ARCHIVE_MAX_LIMIT {
+			limit = MSG_ARCHIVE_MAX_LIMIT
+		}
+		return models.GetRobotFriendRequests(info.WechatNick, info.Status, info.Cursor, limit)
+	case FRIEND_REQUEST_OP_APPROVE:
+		return self.approve(info)
+	case FRIEND_REQUEST_OP_REJECT:
+		return self.reject(info)
+	}
+	return nil, fmt.Errorf("unknown action[%s]", info.Action)
+}
+
+// Logout expires the requests waiting for review or a delayed accept, their
+// tickets and user names are only valid in the login session.
+func (self *FriendPolicyManager) Logout(wechat string) {
+	if !self.cfg.IfNeedOwnerDB {
+		return
+	}
+	self.expire(wechat, time.Now().Unix()+1, "robot logout", FRIEND_REQUEST_STATUS_ACCEPTING)
+}
+
+// ExpireAccepting expires the requests left accepting by the last run, the
+// timers of the delayed accepts are lost on restart.
+func (self *FriendPolicyManager) ExpireAccepting() {
+	if !self.cfg.IfNeedOwnerDB {
+		return
+	}
+	info := &models.RobotFriendRequest{
+		Status: FRIEND_REQUEST_STATUS_EXPIRED,
+		Reason: "restart before accepted",
+	}
+	num, err := models.UpdateRobotFriendRequestsStatus("", time.Now().Unix()+1, info, FRIEND_REQUEST_STATUS_ACCEPTING)
+	if err != nil {
+		logrus.Errorf("expire accepting friend requests error: %v", err)
+		return
+	}
+	if num != 0 {
+		logrus.Infof("%d accepting friend requests expired on restart", num)
+	}
+}
+
+// expire expires the requests waiting for review received before the time,
+// and the requests in the more status.
+func (self *FriendPolicyManager) expire(wechat string, before int64, reason string, more ...string) {
+	info := &models.RobotFriendRequest{
+		Status: FRIEND_REQUEST_STATUS_EXPIRED,
+		Reason: reason,
+	}
+	from := append([]string{FRIEND_REQUEST_STATUS_DEFERRED, FRIEND_REQUEST_STATUS_REJECTED}, more...)
+	num, err := models.UpdateRobotFriendRequestsStatus(wechat, before, info, from...)
+	if err != nil {
+		logrus.Errorf("expire robot friend requests error: %v", err)
+		return
+	}
+	if num != 0 {
+		logrus.Infof("wx[%s] %d friend requests expired: %s", wechat, num, reason)
+	}
+}
+
+// getReviewRequest gets the request waiting for review, must hold the lock.
+func (self *FriendPolicyManager) getReviewRequest(info *RobotFriendRequestsReq) (*models.RobotFriendRequest, error) {
+	request := &models.RobotFriendRequest{ID: info.ID}
+	has, err := models.GetRobotFriendRequest(request)
+	if err != nil {
+		return nil, err
+	}
+	if !has || request.RobotWx != info.WechatNick {
+		return nil, fmt.Errorf("cannot found friend request[%d]", info.ID)
+	}
+	if request.Status != FRIEND_REQUEST_STATUS_DEFERRED && request.Status != FRIEND_REQUEST_STATUS_REJECTED {
+		return nil, fmt.Errorf("friend request[%d] is %s, cannot be reviewed", info.ID, request.Status)
+	}
+	return request, nil
+}
+
+func (self *FriendPolicyManager) approve(info *RobotFriendRequestsReq) (*models.RobotFriendRequest, error) {
+	self.Lock()
+	defer self.Unlock()
+
+	request, err := self.getReviewRequest(info)
+	if err != nil {
+		return nil, err
+	}
+	wx := self.wxm.GetWx(request.RobotWx)
+	if wx == nil {
+		return nil, fmt.Errorf("wechat[%s] is not login", request.RobotWx)
+	}
+	realName, ok := wx.Webwxverifyuser(wxweb.WX_VERIFY_USER_OP_CONFIRM, "", request.Ticket, request.UserName, request.UserNick)
+	if !ok {
+		request.Status = FRIEND_REQUEST_STATUS_FAILED
+		request.Reason = "verify user error, the ticket may be expired"
+		if err = models.UpdateRobotFriendRequestStatus(request); err != nil {
+			logrus.Errorf("update robot friend request error: %v", err)
+		}
+		return nil, fmt.Errorf("friend request[%d] %s", request.ID, request.Reason)
+	}
+	request.Status = FRIEND_REQUEST_STATUS_ACCEPTED
+	request.Reason = info.Reason
+	if err = models.UpdateRobotFriendRequestStatus(request); err != nil {
+		return nil, err
+	}
+	logrus.Infof("wx[%s] friend request[%d][%s] approved, remark name[%s]", request.RobotWx, request.ID, request.UserNick, realName)
+
+	if request.PolicyName != "" {
+		policy := &models.RobotFriendPolicy{RobotWx: request.RobotWx, Name: request.PolicyName}
+		if has, err := models.GetRobotFriendPolicy(policy); err == nil && has {
+			go self.greet(request.RobotWx, request.UserName, realName, policy)
+		}
+	}
+	return request, nil
+}
+
+func (self *FriendPolicyManager) reject(info *RobotFriendRequestsReq) (*models.RobotFriendRequest, error) {
+	self.Lock()
+	defer self.Unlock()
+
+	request, err := self.getReviewRequest(info)
+	if err != nil {
+		return nil, err
+	}
+	// 网页版不能拒绝好友请求, 只记录不再处理
+	request.Status = FRIEND_REQUEST_STATUS_DECLINED
+	request.Reason = info.Reason
+	if err = models.UpdateRobotFriendRequestStatus(request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func (self *FriendPolicyManager) Policies(info *RobotFriendPolicyReq) ([]FriendPolicy, error) {
+	if !self.cfg.IfNeedOwnerDB {
+		return nil, fmt.Errorf("friend policy needs owner db")
+	}
+	switch info.Action {
+	case "", FRIEND_POLICY_OP_LIST:
+	case FRIEND_POLICY_OP_SET:
+		if err := self.set(info.WechatNick, info.Policy); err != nil {
+			return nil, err
+		}
+	case FRIEND_POLICY_OP_DEL:
+		if info.Policy == nil {
+			return nil, fmt.Errorf("policy cannot be nil")
+		}
+		if err := models.DelRobotFriendPolicy(&models.RobotFriendPolicy{RobotWx: info.WechatNick, Name: info.Policy.Name}); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown action[%s]", info.Action)
+	}
+
+	list, err := models.GetRobotFriendPolicies(info.WechatNick)
+	if err != nil {
+		return nil, err
+	}
+	var policies []FriendPolicy
+	for _, v := range list {
+		p := FriendPolicy{
+			Name:         v.Name,
+			Enable:       v.IfEnable != 0,
+			Priority:     v.Priority,
+			SourceWechat: v.SourceWechat,
+			SourceNick:   v.SourceNick,
+			City:         v.City,
+			Content:      v.Content,
+			Sex:          v.Sex,
+			Action:       v.Action,
+			DayLimit:     v.DayLimit,
+			DelayMin:     v.DelayMin,
+			DelayMax:     v.DelayMax,
+		}
+		if v.Greetings != "" {
+			json.Unmarshal([]byte(v.Greetings), &p.Greetings)
+		}
+		policies = append(policies, p)
+	}
+	return policies, nil
+}
+
+func (self *FriendPolicyManager) set(robotWx string, p *FriendPolicy) error {
+	if p == nil || p.Name == "" {
+		return fmt.Errorf("policy name cannot be nil")
+	}
+	switch p.Action {
+	case FRIEND_POLICY_ACTION_ACCEPT, FRIEND_POLICY_ACTION_REJECT, FRIEND_POLICY_ACTION_DEFER:
+	default:
+		return fmt.Errorf("unknown policy action[%s]", p.Action)
+	}
+	if p.DelayMin < 0 || p.DelayMax < 0 {
+		return fmt.Errorf("policy delay cannot be negative")
+	}
+	for _, v := range p.Greetings {
+		if v.MsgType != MSG_TYPE_TEXT && v.MsgType != MSG_TYPE_IMG && v.MsgType != MSG_TYPE_LINK {
+			return fmt.Errorf("unknown greeting msg type[%s]", v.MsgType)
+		}
+	}
+	greetings, err := json.Marshal(p.Greetings)
+	if err != nil {
+		return err
+	}
+	policy := &models.RobotFriendPolicy{
+		RobotWx: robotWx,
+		Name:    p.Name,
+	}
+	has, err := models.GetRobotFriendPolicy(policy)
+	if err != nil {
+		return err
+	}
+	policy.IfEnable = 0
+	if p.Enable {
+		policy.IfEnable = 1
+	}
+	policy.Priority = p.Priority
+	policy.SourceWechat = p.SourceWechat
+	policy.SourceNick = p.SourceNick
+	policy.City = p.City
+	policy.Content = p.Content
+	policy.Sex = p.Sex
+	policy.Action = p.Action
+	policy.DayLimit = p.DayLimit
+	policy.DelayMin = p.DelayMin
+	policy.DelayMax = p.DelayMax
+	policy.Greetings = ""
+	if len(p.Greetings) != 0 {
+		policy.Greetings = string(greetings)
+	}
+	if has {
+		return models.UpdateRobotFriendPolicy(policy)
+	}
+	return models.CreateRobotFriendPolicy(policy)
+}
+
+// matchFriendPolicy returns the first enabled policy matching the request.
+func matchFriendPolicy(policies []models.RobotFriendPolicy, af *wxweb.AddFriend) *models.RobotFriendPolicy {
+	for i := range policies {
+		v := &policies[i]
+		if v.IfEnable == 0 {
+			continue
+		}
+		if v.Sex != 0 && v.Sex != int64(af.UserSex) {
+			continue
+		}
+		if !ExecCheckFunc(v.SourceWechat, af.SourceWechat) || !ExecCheckFunc(v.SourceNick, af.SourceNick) {
+			continue
+		}
+		if !ExecCheckFunc(v.City, af.UserCity) || !ExecCheckFunc(v.Content, af.VerifyContent) {
+			continue
+		}
+		return v
+	}
+	return nil
+}
@@ -1,6 +1,6 @@
 package logic
 
-import "fmt"
+import "github.com/Sirupsen/logrus"
 
 type FunctionEvent struct {
 	wxm      *WxManager
@@ -12,8 +12,12 @@ func (self *FunctionEvent) function() {
 	switch self.Function {
 	case FUNC_EVENT_CHECK_GROUP_CHAT:
 		argv, ok := self.Argv.(*CheckGroupChatInfo)
-		if ok {
-			fmt.Println(argv)
+		if !ok {
+			logrus.Errorf("translate to CheckGroupChatInfo error.")
+			return
+		}
+		if _, err := self.wxm.CheckGroupChat(argv); err != nil {
+			logrus.Errorf("function[%s] error: %v", self.Function, err)
 		}
 	}
 }
@@ -0,0 +1,152 @@
+package logic
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/reechou/wxrobot/models"
+	"github.com/reechou/wxrobot/wxweb"
+)
+
+type GroupModRule struct {
+	GroupNickName string   `json:"groupNickName"` // 为空时为该机器人所有群的默认规则
+	Keywords      []string `json:"keywords,omitempty"`
+	BlockUrl      bool     `json:"blockUrl"`
+	AllowDomains  []string `json:"allowDomains,omitempty"`
+	BlockCard     bool     `json:"blockCard"`
+	BlockMiniApp  bool     `json:"blockMiniApp"`
+	FloodNum      int      `json:"floodNum,omitempty"`      // FloodSeconds秒内超过FloodNum条算刷屏, 0不检查
+	FloodSeconds  int64    `json:"floodSeconds,omitempty"`  // 默认10秒
+	KickStrikes   int      `json:"kickStrikes,omitempty"`   // 违规达到次数踢人, 0只警告
+	StrikeSeconds int64    `json:"strikeSeconds,omitempty"` // 超过该秒数没有违规时违规次数清零, 默认7天
+	WarnMsg       string   `json:"warnMsg,omitempty"`
+	Whitelist     []string `json:"whitelist,omitempty"` // 管理员昵称
+}
+
+// GroupModeration manages the group moderation rules of the robot, and reads
+// the mod logs and the member strikes.
+func (self *WxManager) GroupModeration(info *RobotGroupModerationReq) (interface{}, error) {
+	if !self.cfg.IfNeedOwnerDB {
+		return nil, fmt.Errorf("group moderation needs owner db")
+	}
+	switch info.Action {
+	case "", GROUP_MOD_OP_LIST:
+	case GROUP_MOD_OP_SET:
+		if err := setGroupModRule(info.WechatNick, info.Rule); err != nil {
+			return nil, err
+		}
+		self.reloadGroupModRules(info.WechatNick)
+	case GROUP_MOD_OP_DEL:
+		if info.Rule == nil {
+			return nil, fmt.Errorf("rule cannot be nil")
+		}
+		if err := models.DelRobotGroupModRule(info.WechatNick, info.Rule.GroupNickName); err != nil {
+			return nil, err
+		}
+		self.reloadGroupModRules(info.WechatNick)
+	case GROUP_MOD_OP_LOG:
+		limit := info.Limit
+		if limit <= 0 {
+			limit = MSG_ARCHIVE_DEFAULT_LIMIT
+		} else if limit > MSG_ARCHIVE_MAX_LIMIT {
+			limit = MSG_ARCHIVE_MAX_LIMIT
+		}
+		return models.GetRobotGroupModLogs(info.WechatNick, info.GroupNickName, info.Cursor, limit)
+	case GROUP_MOD_OP_STRIKES:
+		return models.GetRobotGroupStrikes(info.WechatNick, info.GroupNickName)
+	default:
+		return nil, fmt.Errorf("unknown action[%s]", info.Action)
+	}
+
+	list, err := models.GetRobotGroupModRules(info.WechatNick)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]GroupModRule, 0, len(list))
+	for _, v := range list {
+		rules = append(rules, GroupModRule{
+			GroupNickName: v.GroupName,
+			Keywords:      splitGroupModList(v.Keywords),
+			BlockUrl:      v.IfBlockUrl != 0,
+			AllowDomains:  splitGroupModList(v.AllowDomains),
+			BlockCard:     v.IfBlockCard != 0,
+			BlockMiniApp:  v.IfBlockMiniApp != 0,
+			FloodNum:      v.FloodNum,
+			FloodSeconds:  v.FloodSeconds,
+			KickStrikes:   v.KickStrikes,
+			StrikeSeconds: v.StrikeSeconds,
+			WarnMsg:       v.WarnMsg,
+			Whitelist:     splitGroupModList(v.Whitelist),
+		})
+	}
+	return rules, nil
+}
+
+func (self *WxManager) reloadGroupModRules(wechat string) {
+	if wx := self.GetWx(wechat); wx != nil {
+		wx.ReloadGroupModRules()
+	}
+}
+
+func setGroupModRule(robotWx string, r *GroupModRule) error {
+	if r == nil {
+		return fmt.Errorf("rule cannot be nil")
+	}
+	if r.FloodNum < 0 || r.FloodSeconds < 0 || r.KickStrikes < 0 || r.StrikeSeconds < 0 {
+		return fmt.Errorf("rule numbers cannot be negative")
+	}
+	if r.FloodNum > 0 && r.FloodSeconds == 0 {
+		r.FloodSeconds = wxweb.GROUP_MOD_FLOOD_SECONDS
+	}
+	if r.StrikeSeconds == 0 {
+		r.StrikeSeconds = wxweb.GROUP_MOD_STRIKE_SECONDS
+	}
+	for _, v := range [][]string{r.Keywords, r.AllowDomains, r.Whitelist} {
+		for _, s := range v {
+			if strings.Contains(s, ",") {
+				return fmt.Errorf("rule item[%s] cannot contain ','", s)
+			}
+		}
+	}
+
+	rule := &models.RobotGroupModRule{
+		RobotWx:   robotWx,
+		GroupName: r.GroupNickName,
+	}
+	has, err := models.GetRobotGroupModRule(rule)
+	if err != nil {
+		return err
+	}
+	rule.Keywords = strings.Join(r.Keywords, ",")
+	rule.IfBlockUrl = boolInt(r.BlockUrl)
+	rule.AllowDomains = strings.Join(r.AllowDomains, ",")
+	rule.IfBlockCard = boolInt(r.BlockCard)
+	rule.IfBlockMiniApp = boolInt(r.BlockMiniApp)
+	rule.FloodNum = r.FloodNum
+	rule.FloodSeconds = r.FloodSeconds
+	rule.KickStrikes = r.KickStrikes
+	rule.StrikeSeconds = r.StrikeSeconds
+	rule.WarnMsg = r.WarnMsg
+	rule.Whitelist = strings.Join(r.Whitelist, ",")
+	if has {
+		return models.UpdateRobotGroupModRule(rule)
+	}
+	return models.CreateRobotGroupModRule(rule)
+}
+
+func splitGroupModList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
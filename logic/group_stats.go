@@ -0,0 +1,262 @@
+package logic
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/reechou/wxrobot/cache"
+	"github.com/reechou/wxrobot/config"
+	"github.com/reechou/wxrobot/wxweb"
+)
+
+type GroupSpeakerStat struct {
+	NickName      string  `json:"nickname"`
+	MsgNum        int64   `json:"msgNum"`
+	MediaNum      int64   `json:"mediaNum"`
+	LastSpeakTime int64   `json:"lastSpeakTime,omitempty"`
+	HourNum       []int64 `json:"hourNum"` // 0-23点
+}
+
+type GroupDayStat struct {
+	Day    string `json:"day"`
+	MsgNum int64  `json:"msgNum"`
+}
+
+type GroupStatsRsp struct {
+	GroupNickName string             `json:"groupNickName"`
+	Days          int                `json:"days"`
+	MsgNum        int64              `json:"msgNum"`
+	TypeNum       map[string]int64   `json:"typeNum"`
+	HourNum       []int64            `json:"hourNum"` // 0-23点
+	DayNum        []GroupDayStat     `json:"dayNum"`
+	TopSpeakers   []GroupSpeakerStat `json:"topSpeakers"`
+}
+
+// GroupStats counts group messages into the rank redis. Per group and day there
+// is a hash of msg types and hours, a hash of member hours and zsets of member
+// msgs and media shares, per group a hash of member last speak time.
+type GroupStats struct {
+	cfg     *config.Config
+	rc      *cache.RedisCache
+	msgChan chan *wxweb.ReceiveMsgInfo
+}
+
+func NewGroupStats(cfg *config.Config) *GroupStats {
+	gs := &GroupStats{cfg: cfg}
+	if cfg.RankRedis.Conninfo == "" {
+		return gs
+	}
+	rc := cache.NewRedisCache(&cfg.RankRedis)
+	if err := rc.StartAndGC(); err != nil {
+		logrus.Errorf("group stats start rank redis error: %v", err)
+		return gs
+	}
+	gs.rc = rc
+	gs.msgChan = make(chan *wxweb.ReceiveMsgInfo, EVENT_MSG_CHAN_LEN)
+	go gs.run()
+	return gs
+}
+
+func (self *GroupStats) enabled() bool {
+	return self.rc != nil
+}
+
+func (self *GroupStats) ReceiveMsg(msg *wxweb.ReceiveMsgInfo) {
+	if !self.enabled() ||
+		msg.BaseInfo.ReceiveEvent != wxweb.RECEIVE_EVENT_MSG ||
+		msg.BaseInfo.FromType != wxweb.FROM_TYPE_GROUP {
+		return
+	}
+	select {
+	case self.msgChan <- msg:
+	default:
+		logrus.Errorf("group stats msg chan is full, drop msg of group[%s]", msg.BaseInfo.FromGroupName)
+	}
+}
+
+func (self *GroupStats) run() {
+	for msg := range self.msgChan {
+		self.count(msg)
+	}
+}
+
+func groupStatsKey(prefix, wechat, group, day string) string {
+	if day == "" {
+		return fmt.Sprintf("%s:%s:%s", prefix, wechat, group)
+	}
+	return fmt.Sprintf("%s:%s:%s:%s", prefix, wechat, group, day)
+}
+
+func (self *GroupStats) count(msg *wxweb.ReceiveMsgInfo) {
+	now := time.Now()
+	day := now.Format(GROUP_STATS_DAY_FORMAT)
+	wechat := msg.BaseInfo.WechatNick
+	group := msg.BaseInfo.FromGroupName
+	nick := msg.BaseInfo.FromNickName
+	ttl := GROUP_STATS_KEEP_DAYS * 24 * time.Hour
+
+	groupKey := groupStatsKey(GROUP_STATS_KEY_GROUP, wechat, group, day)
+	var err error
+	if err = self.rc.HIncrbyEX(groupKey, msg.MsgType, 1, ttl); err == nil {
+		err = self.rc.HIncrbyEX(groupKey, fmt.Sprintf("h%02d", now.Hour()), 1, ttl)
+	}
+	if err == nil {
+		err = self.rc.ZIncrbyEX(groupStatsKey(GROUP_STATS_KEY_MEMBER, wechat, group, day), 1, nick, ttl)
+	}
+	if err == nil {
+		err = self.rc.HIncrbyEX(groupStatsKey(GROUP_STATS_KEY_HOUR, wechat, group, day), memberHourField(now.Hour(), nick), 1, ttl)
+	}
+	if err == nil && msg.MsgType != wxweb.RECEIVE_MSG_TYPE_TEXT {
+		err = self.rc.ZIncrbyEX(groupStatsKey(GROUP_STATS_KEY_MEDIA, wechat, group, day), 1, nick, ttl)
+	}
+	if err == nil {
+		err = self.rc.HSetEX(groupStatsKey(GROUP_STATS_KEY_LAST, wechat, group, ""), nick, now.Unix(), ttl)
+	}
+	if err != nil {
+		logrus.Errorf("group stats count wx[%s] group[%s] error: %v", wechat, group, err)
+	}
+}
+
+// Stats sums the counters of the last days, today included.
+func (self *GroupStats) Stats(wechat, group string, days, top int) (*GroupStatsRsp, error) {
+	if !self.enabled() {
+		return nil, fmt.Errorf("group stats is not enabled")
+	}
+	if days <= 0 {
+		days = GROUP_STATS_DEFAULT_DAYS
+	} else if days > GROUP_STATS_KEEP_DAYS {
+		days = GROUP_STATS_KEEP_DAYS
+	}
+	if top <= 0 {
+		top = GROUP_STATS_DEFAULT_TOP
+	}
+
+	rsp := &GroupStatsRsp{
+		GroupNickName: group,
+		Days:          days,
+		TypeNum:       make(map[string]int64),
+		HourNum:       make([]int64, 24),
+	}
+	speakers := make(map[string]*GroupSpeakerStat)
+	getSpeaker := func(nick string) *GroupSpeakerStat {
+		s, ok := speakers[nick]
+		if !ok {
+			s = &GroupSpeakerStat{NickName: nick, HourNum: make([]int64, 24)}
+			speakers[nick] = s
+		}
+		return s
+	}
+
+	now := time.Now()
+	for i := days - 1; i >= 0; i-- {
+		day := now.AddDate(0, 0, -i).Format(GROUP_STATS_DAY_FORMAT)
+		counters, err := self.rc.HGetAll(groupStatsKey(GROUP_STATS_KEY_GROUP, wechat, group, day))
+		if err != nil {
+			return nil, err
+		}
+		dayStat := GroupDayStat{Day: day}
+		for k, v := range counters {
+			n, _ := strconv.ParseInt(v, 10, 64)
+			if strings.HasPrefix(k, "h") {
+				if hour, err := strconv.Atoi(k[1:]); err == nil && hour >= 0 && hour < 24 {
+					rsp.HourNum[hour] += n
+				}
+				continue
+			}
+			rsp.TypeNum[k] += n
+			dayStat.MsgNum += n
+		}
+		rsp.MsgNum += dayStat.MsgNum
+		rsp.DayNum = append(rsp.DayNum, dayStat)
+
+		for nick, n := range zsetScores(self.rc.ZRevrange(groupStatsKey(GROUP_STATS_KEY_MEMBER, wechat, group, day), 0, -1)) {
+			getSpeaker(nick).MsgNum += n
+		}
+		for nick, n := range zsetScores(self.rc.ZRevrange(groupStatsKey(GROUP_STATS_KEY_MEDIA, wechat, group, day), 0, -1)) {
+			getSpeaker(nick).MediaNum += n
+		}
+		memberHours, err := self.rc.HGetAll(groupStatsKey(GROUP_STATS_KEY_HOUR, wechat, group, day))
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range memberHours {
+			hour, nick, ok := parseMemberHourField(k)
+			if !ok {
+				continue
+			}
+			n, _ := strconv.ParseInt(v, 10, 64)
+			getSpeaker(nick).HourNum[hour] += n
+		}
+	}
+
+	var list []GroupSpeakerStat
+	for _, v := range speakers {
+		list = append(list, *v)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].MsgNum != list[j].MsgNum {
+			return list[i].MsgNum > list[j].MsgNum
+		}
+		return list[i].NickName < list[j].NickName
+	})
+	if len(list) > top {
+		list = list[:top]
+	}
+	lastSpeak, err := self.rc.HGetAll(groupStatsKey(GROUP_STATS_KEY_LAST, wechat, group, ""))
+	if err != nil {
+		return nil, err
+	}
+	for i := range list {
+		list[i].LastSpeakTime, _ = strconv.ParseInt(lastSpeak[list[i].NickName], 10, 64)
+	}
+	rsp.TopSpeakers = list
+
+	return rsp, nil
+}
+
+// memberHourField is the field of the member hours hash, the hour goes first
+// as the nickname may contain any char.
+func memberHourField(hour int, nick string) string {
+	return fmt.Sprintf("%02d:%s", hour, nick)
+}
+
+func parseMemberHourField(field string) (int, string, bool) {
+	if len(field) < 3 || field[2] != ':' {
+		return 0, "", false
+	}
+	hour, err := strconv.Atoi(field[:2])
+	if err != nil || hour < 0 || hour >= 24 {
+		return 0, "", false
+	}
+	return hour, field[3:], true
+}
+
+// zsetScores parses a zrevrange withscores reply.
+func zsetScores(reply []interface{}) map[string]int64 {
+	scores := make(map[string]int64)
+	for i := 0; i+1 < len(reply); i += 2 {
+		member, _ := reply[i].([]byte)
+		score, _ := reply[i+1].([]byte)
+		n, _ := strconv.ParseInt(string(score), 10, 64)
+		scores[string(member)] += n
+	}
+	return scores
+}
+
+// Leaderboard formats the top speakers for the groupstats() template.
+func (self *GroupStats) Leaderboard(wechat, group string, days int) string {
+	stats, err := self.Stats(wechat, group, days, GROUP_STATS_DEFAULT_TOP)
+	if err != nil {
+		logrus.Errorf("group stats leaderboard wx[%s] group[%s] error: %v", wechat, group, err)
+		return ""
+	}
+	result := fmt.Sprintf("【%s】近%d天共%d条消息, 发言排行:", group, stats.Days, stats.MsgNum)
+	for i, v := range stats.TopSpeakers {
+		result += fmt.Sprintf("\n%d. %s %d条", i+1, v.NickName, v.MsgNum)
+	}
+	return result
+}
@@ -1,7 +1,10 @@
 package logic
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"strings"
 	"time"
@@ -9,6 +12,8 @@ import (
 	"github.com/Sirupsen/logrus"
 	"github.com/mitchellh/mapstructure"
 	"github.com/reechou/wxrobot/config"
+	"github.com/reechou/wxrobot/models"
+	"github.com/reechou/wxrobot/wxweb"
 )
 
 type WxHttpSrv struct {
@@ -45,11 +50,38 @@ func (self *WxHttpSrv) registerHandlers() {
 	self.httpSrv.Route("/remarkfriend", self.httpWrap(self.RobotRemarkFriend))
 	self.httpSrv.Route("/grouptiren", self.httpWrap(self.RobotGroupTiren))
 	self.httpSrv.Route("/group_member_list", self.httpWrap(self.RobotGetGroupMemberList))
+	self.httpSrv.Route("/group_info", self.httpWrap(self.RobotGetGroupInfo))
+	self.httpSrv.Route("/group_list", self.httpWrap(self.RobotGetGroupList))
+	self.httpSrv.Route("/creategroup", self.httpWrap(self.RobotCreateGroup))
+	self.httpSrv.Route("/groupinvite", self.httpWrap(self.RobotGroupInvite))
+	self.httpSrv.Route("/grouprename", self.httpWrap(self.RobotGroupRename))
+	self.httpSrv.Route("/groupnamelock", self.httpWrap(self.RobotGroupNameLock))
+	self.httpSrv.Route("/groupwelcome", self.httpWrap(self.RobotGroupWelcome))
+	self.httpSrv.Route("/groupmoderation", self.httpWrap(self.RobotGroupModeration))
+	self.httpSrv.Route("/messages", self.httpWrap(self.RobotGetMessages))
+	self.httpSrv.Route("/group_msgs", self.httpWrap(self.RobotGetGroupMsgs))
+	self.httpSrv.Route("/groupstats", self.httpWrap(self.RobotGetGroupStats))
+	self.httpSrv.Route("/checkgroupchat", self.httpWrap(self.RobotCheckGroupChat))
+	self.httpSrv.Route("/campaign_create", self.httpWrap(self.RobotCampaignCreate))
+	self.httpSrv.Route("/campaign_control", self.httpWrap(self.RobotCampaignControl))
+	self.httpSrv.Route("/campaign_progress", self.httpWrap(self.RobotCampaignProgress))
+	self.httpSrv.Route("/friendcampaign_create", self.httpWrap(self.RobotFriendCampaignCreate))
+	self.httpSrv.Route("/friendcampaign_control", self.httpWrap(self.RobotFriendCampaignControl))
+	self.httpSrv.Route("/friendcampaign_progress", self.httpWrap(self.RobotFriendCampaignProgress))
+	self.httpSrv.Route("/jobs", self.httpWrap(self.RobotJobs))
+	self.httpSrv.Route("/jobs/addgroupmember", self.httpWrap(self.RobotAddGroupMemberJob))
+	self.httpSrv.Route("/jobs/deadfriendscan", self.httpWrap(self.RobotDeadFriendScan))
+	self.httpSrv.Route("/invitecampaign", self.httpWrap(self.RobotInviteCampaign))
+	self.httpSrv.Route("/invitecampaign_report", self.httpWrap(self.RobotInviteReport))
+	self.httpSrv.Route("/friendpolicy", self.httpWrap(self.RobotFriendPolicy))
+	self.httpSrv.Route("/friendrequests", self.httpWrap(self.RobotFriendRequests))
+	self.httpSrv.Route("/labels", self.httpWrap(self.RobotContactLabels))
 	self.httpSrv.Route("/addfriend", self.httpWrap(self.RobotAddFriend))
 
 	self.httpSrv.Route("/reloadevent", self.httpWrap(self.ReloadEvent))
 	self.httpSrv.Route("/allrobots", self.httpWrap(self.AllRobots))
 	self.httpSrv.Route("/robots_from_type", self.httpWrap(self.LoginRobotsFromType))
+	self.httpSrv.Route("/robotroles", self.httpWrap(self.RobotRoles))
 }
 
 func (self *WxHttpSrv) httpWrap(handler HttpHandler) func(rsp http.ResponseWriter, req *http.Request) {
@@ -59,7 +91,11 @@ func (self *WxHttpSrv) httpWrap(handler HttpHandler) func(rsp http.ResponseWrite
 		defer func() {
 			logrus.Debugf("[WxHttpSrv][httpWrap] http: request url[%s] use_time[%v]", logURL, time.Now().Sub(start))
 		}()
-		obj, err := handler(rsp, req)
+		var obj interface{}
+		err := self.checkRole(req)
+		if err == nil {
+			obj, err = handler(rsp, req)
+		}
 		// check err
 	HAS_ERR:
 		if err != nil {
@@ -91,6 +127,133 @@ func (self *WxHttpSrv) httpWrap(handler HttpHandler) func(rsp http.ResponseWrite
 	return f
 }
 
+// checkRole denies the request when the role of the robot in it does not
+// allow the api path. Only paths denied by some role are checked, the robots
+// are read from the json body, which is put back for the handler, or from
+// the record of the id for the campaign apis. A checked request naming no
+// robot is denied, as its robot may be restricted.
+func (self *WxHttpSrv) checkRole(req *http.Request) error {
+	if robotRoleFreeOps[req.URL.Path] || !robotRoleRestricts(req.URL.Path) {
+		return nil
+	}
+
+	var wechats []string
+	if req.Body != nil && isJsonRequest(req) {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		var robots struct {
+			ID         int64                `json:"id"`
+			WechatNick string               `json:"wechatNick"`
+			Robot      string               `json:"robot"`
+			SendMsgs   []wxweb.SendBaseInfo `json:"sendBaseInfo"`
+		}
+		if json.Unmarshal(body, &robots) == nil {
+			wechats = append(wechats, robots.WechatNick, robots.Robot)
+			for _, v := range robots.SendMsgs {
+				wechats = append(wechats, v.WechatNick)
+			}
+		}
+		if getRobot, ok := robotRoleRecordOps[req.URL.Path]; ok && robots.ID != 0 {
+			// 没有数据库或记录不存在时由接口返回错误
+			if !self.cfg.IfNeedOwnerDB {
+				return nil
+			}
+			robotWx, err := getRobot(robots.ID)
+			if err != nil {
+				return err
+			}
+			if robotWx == "" {
+				return nil
+			}
+			wechats = append(wechats, robotWx)
+		}
+	}
+
+	found := false
+	for _, v := range wechats {
+		if v == "" {
+			continue
+		}
+		found = true
+		wx := self.l.wxMgr.GetWx(v)
+		if wx == nil {
+			continue
+		}
+		role := wxweb.GetRobotRole(wx.RobotType())
+		if role != nil && !role.Allow(req.URL.Path) {
+			return fmt.Errorf("Permission denied: robot[%s] role[%s] cannot call %s", v, role.Name, req.URL.Path)
+		}
+	}
+	if !found {
+		return fmt.Errorf("Permission denied: %s is restricted by robot roles, the json body needs wechatNick", req.URL.Path)
+	}
+	return nil
+}
+
+// robotRoleFreeOps are the api paths not about a running robot.
+var robotRoleFreeOps = map[string]bool{
+	"/startwx2":         true,
+	"/reloadevent":      true,
+	"/allrobots":        true,
+	"/robots_from_type": true,
+	"/robotroles":       true,
+}
+
+// robotRoleRecordOps are the api paths naming a record by id, the robot is
+// read from the record, empty when the record is not found.
+var robotRoleRecordOps = map[string]func(id int64) (string, error){
+	"/campaign_control":        campaignRobotWx,
+	"/campaign_progress":       campaignRobotWx,
+	"/friendcampaign_control":  friendCampaignRobotWx,
+	"/friendcampaign_progress": friendCampaignRobotWx,
+	"/invitecampaign_report":   inviteReportRobotWx,
+}
+
+func campaignRobotWx(id int64) (string, error) {
+	campaign := &models.RobotCampaign{ID: id}
+	if _, err := models.GetRobotCampaign(campaign); err != nil {
+		return "", err
+	}
+	return campaign.RobotWx, nil
+}
+
+func friendCampaignRobotWx(id int64) (string, error) {
+	campaign := &models.RobotFriendCampaign{ID: id}
+	if _, err := models.GetRobotFriendCampaign(campaign); err != nil {
+		return "", err
+	}
+	return campaign.RobotWx, nil
+}
+
+func inviteReportRobotWx(id int64) (string, error) {
+	report := &models.RobotInviteReport{ID: id}
+	if _, err := models.GetRobotInviteReport(report); err != nil {
+		return "", err
+	}
+	return report.RobotWx, nil
+}
+
+// robotRoleRestricts checks whether any role denies the api path.
+func robotRoleRestricts(op string) bool {
+	for _, role := range wxweb.RobotRoles() {
+		if !role.Allow(op) {
+			return true
+		}
+	}
+	return false
+}
+
+// isJsonRequest skips the uploads, requests without content type are json.
+func isJsonRequest(req *http.Request) bool {
+	ct := req.Header.Get("Content-Type")
+	return ct == "" || strings.Contains(ct, "json")
+}
+
 func (self *WxHttpSrv) Index(rsp http.ResponseWriter, req *http.Request) {
 	if req.URL.Path != "/" {
 		rsp.WriteHeader(404)
@@ -0,0 +1,64 @@
+package logic
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/reechou/wxrobot/config"
+	"github.com/reechou/wxrobot/wxweb"
+)
+
+func TestCheckRoleRecordOps(t *testing.T) {
+	if err := wxweb.LoadRobotRoles("../robot_role.json"); err != nil {
+		t.Fatalf("load shipped role file error: %v", err)
+	}
+	defer func() {
+		dir, err := ioutil.TempDir("", "role")
+		if err != nil {
+			return
+		}
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "role.json")
+		if ioutil.WriteFile(file, []byte("[]"), 0644) == nil {
+			wxweb.LoadRobotRoles(file)
+		}
+	}()
+
+	getRobot := robotRoleRecordOps["/campaign_progress"]
+	defer func() { robotRoleRecordOps["/campaign_progress"] = getRobot }()
+	robotRoleRecordOps["/campaign_progress"] = func(id int64) (string, error) {
+		if id == 1 {
+			return "bob", nil
+		}
+		return "", nil
+	}
+
+	cfg := &config.Config{IfNeedOwnerDB: true}
+	srv := &WxHttpSrv{cfg: cfg, l: &WxLogic{wxMgr: &WxManager{wxs: make(map[string]*wxweb.WxWeb)}}}
+	tests := []struct {
+		path string
+		body string
+		err  string
+	}{
+		// groupmanager的allowOps不含活动接口, 只带id的请求按记录的机器人检查
+		{path: "/campaign_progress", body: `{"id": 1}`},
+		{path: "/campaign_progress", body: `{"id": 2}`},
+		{path: "/campaign_progress", body: `{}`, err: "Permission denied"},
+		{path: "/campaign_create", body: `{"name": "x"}`, err: "Permission denied"},
+		{path: "/sendmsgs", body: `{}`},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", "http://127.0.0.1"+tt.path, strings.NewReader(tt.body))
+		err := srv.checkRole(req)
+		if tt.err == "" && err != nil {
+			t.Errorf("%s %s error: %v", tt.path, tt.body, err)
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s %s error %v, want %q", tt.path, tt.body, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package logic
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/reechou/wxrobot/config"
+	"github.com/reechou/wxrobot/models"
+	"github.com/reechou/wxrobot/wxweb"
+)
+
+type InviteGroupReport struct {
+	GroupName string `json:"groupName"`
+	Invited   int    `json:"invited"`
+	Full      bool   `json:"full"`
+}
+
+// InviteCampaignManager pulls the friends matching the audience filter into
+// the target groups batch by batch, moving on to the next group when one is
+// full. Campaigns run as the invite job of the robot, one at a time.
+type InviteCampaignManager struct {
+	wxm *WxManager
+	cfg *config.Config
+}
+
+func NewInviteCampaignManager(wxm *WxManager, cfg *config.Config) *InviteCampaignManager {
+	return &InviteCampaignManager{
+		wxm: wxm,
+		cfg: cfg,
+	}
+}
+
+// Start starts the invite job of the robot with the campaign, the report id
+// is in the job result.
+func (self *InviteCampaignManager) Start(info *RobotInviteCampaignReq) (*wxweb.JobInfo, error) {
+	wx := self.wxm.GetWx(info.WechatNick)
+	if wx == nil {
+		return nil, fmt.Errorf("unknown this wechat[%s]", info.WechatNick)
+	}
+	argv, err := json.Marshal(&info.Conf)
+	if err != nil {
+		return nil, err
+	}
+	return wx.StartJob(wxweb.JOB_INVITE_MEMBERS, argv)
+}
+
+// Run runs the campaign and returns when it ends or the job is cancelled.
+func (self *InviteCampaignManager) Run(wx *wxweb.WxWeb, conf *wxweb.InviteCampaignConf, ctx *wxweb.JobContext) error {
+	report, err := self.begin(wx, conf)
+	if err != nil {
+		return err
+	}
+	ctx.SetResult(map[string]int64{"reportId": report.ID})
+	self.run(wx, conf, report, ctx)
+	ctx.SetResult(report)
+	return nil
+}
+
+func (self *InviteCampaignManager) Report(info *RobotInviteReportReq) (*models.RobotInviteReport, error) {
+	if !self.cfg.IfNeedOwnerDB {
+		return nil, fmt.Errorf("invite report needs owner db")
+	}
+	report := &models.RobotInviteReport{ID: info.ID}
+	has, err := models.GetRobotInviteReport(report)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, fmt.Errorf("cannot found invite report[%d]", info.ID)
+	}
+	return report, nil
+}
+
+func (self *InviteCampaignManager) begin(wx *wxweb.WxWeb, conf *wxweb.InviteCampaignConf) (*models.RobotInviteReport, error) {
+	confJson, err := json.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+	report := &models.RobotInviteReport{
+		RobotWx: wx.RobotWxNick(),
+		Name:    conf.Name,
+		Conf:    string(confJson),
+		Status:  CAMPAIGN_STATUS_RUNNING,
+	}
+	if self.cfg.IfNeedOwnerDB {
+		if err = models.CreateRobotInviteReport(report); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+func (self *InviteCampaignManager) run(wx *wxweb.WxWeb, conf *wxweb.InviteCampaignConf, report *models.RobotInviteReport, ctx *wxweb.JobContext) {
+	groups := self.invite(wx, conf, report, ctx)
+
+	report.Status = CAMPAIGN_STATUS_FINISHED
+	if report.Reason != "" {
+		report.Status = CAMPAIGN_STATUS_ABORTED
+	}
+	report.FinishedAt = time.Now().Unix()
+	if groupsJson, err := json.Marshal(groups); err == nil {
+		report.Groups = string(groupsJson)
+	}
+	if self.cfg.IfNeedOwnerDB {
+		if err := models.UpdateRobotInviteReport(report); err != nil {
+			logrus.Errorf("update robot invite report error: %v", err)
+		}
+	}
+	logrus.Infof("wx[%s] invite campaign[%s] %s, candidates[%d] invited[%d] failed[%d] skipped[%d] %s",
+		report.RobotWx, report.Name, report.Status, report.Candidates, report.Invited, report.Failed, report.Skipped, report.Reason)
+}
+
+func (self *InviteCampaignManager) invite(wx *wxweb.WxWeb, conf *wxweb.InviteCampaignConf, report *models.RobotInviteReport, ctx *wxweb.JobContext) []InviteGroupReport {
+	var groups []*wxweb.UserGroup
+	for _, v := range wx.Contact.GroupsSnapshot() {
+		if ExecCheckGroupFunc(conf.GroupFilter, v.GetNickName(), v.IsOwner()) {
+			groups = append(groups, v)
+		}
+	}
+	if len(groups) == 0 {
+		report.Reason = fmt.Sprintf("no group matches [%s]", conf.GroupFilter)
+		return nil
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].GetNickName() < groups[j].GetNickName()
+	})
+	groupReports := make([]InviteGroupReport, len(groups))
+	for i, v := range groups {
+		groupReports[i].GroupName = v.GetNickName()
+	}
+
+	friends := inviteAudience(wx, conf, groups)
+	report.Candidates = int64(len(friends))
+
+	gi := 0
+	filled := groups[gi].GetGroupMemberLen()
+	pauseNum := 0
+	for len(friends) != 0 {
+		if !ctx.Wait() {
+			report.Reason = "cancelled"
+			report.Skipped += int64(len(friends))
+			break
+		}
+		if !wx.IfLogin() {
+			report.Reason = "robot logout"
+			report.Skipped += int64(len(friends))
+			break
+		}
+		for gi < len(groups) && filled >= conf.MaxGroupMember {
+			groupReports[gi].Full = true
+			gi++
+			if gi < len(groups) {
+				filled = groups[gi].GetGroupMemberLen()
+			}
+		}
+		if gi >= len(groups) {
+			report.Reason = "all groups are full"
+			report.Skipped += int64(len(friends))
+			break
+		}
+
+		ug := groups[gi]
+		n := conf.BatchSize
+		if n > conf.MaxGroupMember-filled {
+			n = conf.MaxGroupMember - filled
+		}
+		if n > len(friends) {
+			n = len(friends)
+		}
+		batch := friends[:n]
+		results, err := wx.Contact.InviteGroupMembers(ug, batch)
+		var retry []*wxweb.UserFriend
+		var invited []*wxweb.UserFriend
+		if results == nil && err != nil {
+			retry = batch
+		}
+		for i, v := range results {
+			switch {
+			case v.Ok && v.Mode == "":
+				report.Skipped++
+			case v.Ok:
+				invited = append(invited, batch[i])
+			case v.Msg == wxweb.GROUP_INVITE_MSG_RATE_LIMITED:
+				retry = append(retry, batch[i])
+			default:
+				report.Failed++
+			}
+		}
+		friends = append(retry, friends[n:]...)
+		report.Invited += int64(len(invited))
+		groupReports[gi].Invited += len(invited)
+		filled += len(invited)
+		pauseNum += len(invited)
+		self.sendInviteMsg(wx, conf, ug.GetNickName(), invited, ctx)
+		ctx.SetProgress(report.Invited+report.Failed+report.Skipped, report.Candidates)
+
+		wait := conf.BatchInterval
+		if err != nil {
+			logrus.Errorf("wx[%s] invite campaign[%s] group[%s] error: %v, wait %d seconds",
+				report.RobotWx, conf.Name, ug.GetNickName(), err, conf.FreqLimitWait)
+			wait = conf.FreqLimitWait
+		} else if pauseNum >= conf.PauseEvery {
+			wait += conf.PauseSeconds
+			pauseNum = 0
+		}
+		ctx.Sleep(time.Duration(wait) * time.Second)
+	}
+
+	return groupReports
+}
+
+func (self *InviteCampaignManager) sendInviteMsg(wx *wxweb.WxWeb, conf *wxweb.InviteCampaignConf, groupName string, friends []*wxweb.UserFriend, ctx *wxweb.JobContext) {
+	if conf.Msg == "" {
+		return
+	}
+	for _, v := range friends {
+		nick := v.RemarkName
+		if nick == "" {
+			nick = v.NickName
+		}
+		msg := strings.NewReplacer(INVITE_MSG_NICK, nick, INVITE_MSG_GROUP, groupName).Replace(conf.Msg)
+		if !wx.Webwxsendmsg(msg, v.UserName) {
+			logrus.Errorf("wx[%s] send invite msg to [%s] error", wx.RobotWxNick(), nick)
+		}
+		if !ctx.Sleep(time.Duration(conf.MsgInterval) * time.Second) {
+			return
+		}
+	}
+}
+
+// inviteAudience returns the friends matching the filters who are in none of
+// the groups, in the order of the campaign.
+func inviteAudience(wx *wxweb.WxWeb, conf *wxweb.InviteCampaignConf, groups []*wxweb.UserGroup) []*wxweb.UserFriend {
+	var list []*wxweb.UserFriend
+	for _, v := range wx.Contact.FriendsSnapshot() {
+		if _, ok := wx.SpecialUsers[v.UserName]; ok {
+			continue
+		}
+		if v.VerifyFlag != wxweb.WX_FRIEND_VERIFY_FLAG_USER {
+			continue
+		}
+		if conf.Sex != 0 && v.Sex != conf.Sex {
+			continue
+		}
+		if !ExecCheckFunc(conf.City, v.City) || !ExecCheckFunc(conf.Remark, v.RemarkName) {
+			continue
+		}
+		inGroup := false
+		for _, ug := range groups {
+			if ug.GetMemberFromList(v.UserName) != nil {
+				inGroup = true
+				break
+			}
+		}
+		if inGroup {
+			continue
+		}
+		uf := v
+		list = append(list, &uf)
+	}
+
+	switch conf.Order {
+	case wxweb.INVITE_ORDER_GIRLFIRST, wxweb.INVITE_ORDER_BOYFIRST:
+		first := wxweb.WX_GIRL
+		if conf.Order == wxweb.INVITE_ORDER_BOYFIRST {
+			first = wxweb.WX_BOY
+		}
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].Sex == first && list[j].Sex != first
+		})
+	case wxweb.INVITE_ORDER_RANDOM:
+		for i := len(list) - 1; i > 0; i-- {
+			j := rand.Intn(i + 1)
+			list[i], list[j] = list[j], list[i]
+		}
+	}
+	return list
+}
@@ -0,0 +1,280 @@
+package logic
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/reechou/wxrobot/config"
+	"github.com/reechou/wxrobot/models"
+	"github.com/reechou/wxrobot/wxweb"
+)
+
+// LabelManager keeps local labels of the robot contacts, so customers can be
+// marked without rewriting remark names. Labels of a robot are cached after
+// the first read and dropped on every change.
+type LabelManager struct {
+	sync.Mutex
+
+	wxm *WxManager
+	cfg *config.Config
+
+	labels map[string][]models.RobotContactLabel
+}
+
+func NewLabelManager(wxm *WxManager, cfg *config.Config) *LabelManager {
+	return &LabelManager{
+		wxm:    wxm,
+		cfg:    cfg,
+		labels: make(map[string][]models.RobotContactLabel),
+	}
+}
+
+// Labels lists, adds or removes the contact labels of the robot.
+func (self *LabelManager) Labels(info *RobotContactLabelReq) (interface{}, error) {
+	if !self.cfg.IfNeedOwnerDB {
+		return nil, fmt.Errorf("contact label needs owner db")
+	}
+
+	switch info.Action {
+	case "", LABEL_OP_LIST:
+		if info.UserName != "" || info.NickName != "" {
+			c, err := self.findContact(info.WechatNick, info.UserName, info.NickName)
+			if err != nil {
+				return nil, err
+			}
+			return self.ContactLabels(info.WechatNick, c), nil
+		}
+		return models.GetRobotContactLabels(info.WechatNick, info.Label)
+	case LABEL_OP_ADD:
+		if info.Label == "" {
+			return nil, fmt.Errorf("label cannot be empty")
+		}
+		c, err := self.findContact(info.WechatNick, info.UserName, info.NickName)
+		if err != nil {
+			return nil, err
+		}
+		return nil, self.AddLabel(info.WechatNick, info.Label, c)
+	case LABEL_OP_DEL:
+		if info.ID != 0 {
+			return nil, self.delLabel(info.WechatNick, info.ID)
+		}
+		c, err := self.findContact(info.WechatNick, info.UserName, info.NickName)
+		if err != nil {
+			return nil, err
+		}
+		return nil, self.DelLabel(info.WechatNick, info.Label, c)
+	default:
+		return nil, fmt.Errorf("unknown label action[%s]", info.Action)
+	}
+}
+
+// findContact finds the friend by username, or by remark name.
+func (self *LabelManager) findContact(wechat, userName, nickName string) (*wxweb.UserFriend, error) {
+	wx := self.wxm.GetWx(wechat)
+	if wx == nil {
+		return nil, fmt.Errorf("unknown this wechat[%s]", wechat)
+	}
+	uf := wx.Contact.FindFriend(userName, nickName)
+	if uf == nil {
+		return nil, fmt.Errorf("cannot found friend[%s][%s]", userName, nickName)
+	}
+	return uf, nil
+}
+
+func (self *LabelManager) AddLabel(wechat, label string, c *wxweb.UserFriend) error {
+	for _, v := range self.ContactLabels(wechat, c) {
+		if v == label {
+			return nil
+		}
+	}
+	err := models.CreateRobotContactLabel(&models.RobotContactLabel{
+		RobotWx:  wechat,
+		Label:    label,
+		UserName: c.UserName,
+		Alias:    c.Alias,
+		NickName: c.NickName,
+	})
+	if err != nil {
+		return err
+	}
+	self.reset(wechat)
+	return nil
+}
+
+func (self *LabelManager) DelLabel(wechat, label string, c *wxweb.UserFriend) error {
+	for _, v := range self.getLabels(wechat) {
+		if (label == "" || v.Label == label) && matchLabelContact(&v, c) {
+			if err := self.delLabel(wechat, v.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (self *LabelManager) delLabel(wechat string, id int64) error {
+	if err := models.DelRobotContactLabel(wechat, id); err != nil {
+		return err
+	}
+	self.reset(wechat)
+	return nil
+}
+
+// ContactLabels returns the labels of the contact.
+func (self *LabelManager) ContactLabels(wechat string, c *wxweb.UserFriend) []string {
+	var labels []string
+	for _, v := range self.getLabels(wechat) {
+		if matchLabelContact(&v, c) {
+			labels = append(labels, v.Label)
+		}
+	}
+	return labels
+}
+
+func (self *LabelManager) HasLabel(wechat string, c *wxweb.UserFriend, labels []string) bool {
+	if !self.cfg.IfNeedOwnerDB {
+		return false
+	}
+	for _, v := range self.getLabels(wechat) {
+		for _, l := range labels {
+			if v.Label == l && matchLabelContact(&v, c) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Friends returns the friends of the robot with the label.
+func (self *LabelManager) Friends(wx *wxweb.WxWeb, label string) []wxweb.UserFriend {
+	var list []wxweb.UserFriend
+	wechat := wx.RobotWxNick()
+	for _, v := range wx.Contact.FriendsSnapshot() {
+		if self.HasLabel(wechat, &v, []string{label}) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// SendMsg sends the message to the friends with the label in the background,
+// one at a time with the campaign interval.
+func (self *LabelManager) SendMsg(msg *SendMsgInfo, label string) bool {
+	wx := self.wxm.GetWx(msg.WeChat)
+	if wx == nil {
+		logrus.Errorf("send label msg unknown this wechat[%s].", msg.WeChat)
+		return false
+	}
+	friends := self.Friends(wx, label)
+	if len(friends) == 0 {
+		return true
+	}
+	throttle := &campaignThrottle{
+		name:        fmt.Sprintf("label[%s] msg", label),
+		robotWx:     msg.WeChat,
+		intervalMin: CAMPAIGN_DEFAULT_INTERVAL_MIN,
+		intervalMax: CAMPAIGN_DEFAULT_INTERVAL_MAX,
+	}
+	r := &campaignRunner{
+		status: CAMPAIGN_STATUS_RUNNING,
+		ctrl:   make(chan struct{}, 1),
+	}
+	go func() {
+		r.throttle(self.wxm, throttle, len(friends), nil, func(wx *wxweb.WxWeb, i int) {
+			v := &friends[i]
+			m := *msg
+			m.ChatType = CHAT_TYPE_PEOPLE
+			m.Name = v.RemarkName
+			m.UserName = v.UserName
+			if !self.wxm.SendMsg(&m, m.Msg) {
+				logrus.Errorf("wx[%s] send label[%s] msg to[%s] error.", msg.WeChat, label, v.NickName)
+			}
+		})
+		logrus.Infof("wx[%s] send label[%s] msg to %d friends over.", msg.WeChat, label, len(friends))
+	}()
+	return true
+}
+
+// CheckFriendFunc checks the friend condition, haslabel()a,b matches the
+// friends with any of the labels, others match the remark name.
+func (self *LabelManager) CheckFriendFunc(wechat, f string, c *wxweb.UserFriend) bool {
+	if strings.HasPrefix(f, HAS_LABEL) {
+		return self.HasLabel(wechat, c, strings.Split(strings.TrimPrefix(f, HAS_LABEL), ","))
+	}
+	return ExecCheckFunc(f, c.RemarkName)
+}
+
+// CheckSender checks the haslabel() condition against the sender of the
+// message, the member for group messages.
+func (self *LabelManager) CheckSender(f string, msg *wxweb.ReceiveMsgInfo) bool {
+	return self.CheckFriendFunc(msg.BaseInfo.WechatNick, f, self.sender(msg))
+}
+
+// LabelSender adds the label to the sender of the message, or removes it.
+func (self *LabelManager) LabelSender(label string, add bool, msg *wxweb.ReceiveMsgInfo) {
+	if !self.cfg.IfNeedOwnerDB {
+		return
+	}
+	c := self.sender(msg)
+	var err error
+	if add {
+		err = self.AddLabel(msg.BaseInfo.WechatNick, label, c)
+	} else {
+		err = self.DelLabel(msg.BaseInfo.WechatNick, label, c)
+	}
+	if err != nil {
+		logrus.Errorf("wx[%s] label[%s] contact[%s] error: %v", msg.BaseInfo.WechatNick, label, c.NickName, err)
+	}
+}
+
+// sender returns the friend who sent the message, or the group member who is
+// not a friend.
+func (self *LabelManager) sender(msg *wxweb.ReceiveMsgInfo) *wxweb.UserFriend {
+	c := &wxweb.UserFriend{UserName: msg.BaseInfo.FromUserName, NickName: msg.BaseInfo.FromNickName}
+	if msg.BaseInfo.FromType == CHAT_TYPE_GROUP {
+		c.UserName = msg.BaseInfo.FromMemberUserName
+	}
+	if wx := self.wxm.GetWx(msg.BaseInfo.WechatNick); wx != nil {
+		if uf := wx.Contact.GetFriend(c.UserName); uf != nil {
+			return uf
+		}
+	}
+	return c
+}
+
+func (self *LabelManager) getLabels(wechat string) []models.RobotContactLabel {
+	self.Lock()
+	defer self.Unlock()
+
+	list, ok := self.labels[wechat]
+	if ok {
+		return list
+	}
+	list, err := models.GetRobotContactLabels(wechat, "")
+	if err != nil {
+		return nil
+	}
+	self.labels[wechat] = list
+	return list
+}
+
+func (self *LabelManager) reset(wechat string) {
+	self.Lock()
+	defer self.Unlock()
+
+	delete(self.labels, wechat)
+}
+
+// matchLabelContact matches by username, then alias, then nickname, as the
+// username changes on every login.
+func matchLabelContact(l *models.RobotContactLabel, c *wxweb.UserFriend) bool {
+	if c.UserName != "" && l.UserName == c.UserName {
+		return true
+	}
+	if l.Alias != "" && c.Alias != "" {
+		return l.Alias == c.Alias
+	}
+	return l.NickName != "" && l.NickName == c.NickName
+}
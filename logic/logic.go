@@ -17,11 +17,17 @@ type WxLogic struct {
 
 	cfg *config.Config
 
-	wxs      map[string]*wxweb.WxWeb
-	wxSrv    *WxHttpSrv
-	wxMgr    *WxManager
-	eventMgr *EventManager
-	raExt    *ext.RobotAccount
+	wxs            map[string]*wxweb.WxWeb
+	wxSrv          *WxHttpSrv
+	wxMgr          *WxManager
+	eventMgr       *EventManager
+	welcome        *WelcomeManager
+	campaign       *CampaignManager
+	friendCampaign *FriendCampaignManager
+	invite         *InviteCampaignManager
+	raExt          *ext.RobotAccount
+
+	lastMsgArchiveClean int64
 
 	stop chan struct{}
 	done chan struct{}
@@ -41,10 +47,15 @@ func NewWxLogic(cfg *config.Config) *WxLogic {
 	l.wxSrv = NewWxHTTPServer(cfg, l)
 	l.wxMgr = NewWxManager(cfg)
 	l.eventMgr = NewEventManager(l.wxMgr, cfg)
+	l.welcome = NewWelcomeManager(l.wxMgr, cfg)
+	l.invite = NewInviteCampaignManager(l.wxMgr, cfg)
 	l.raExt = ext.NewRobotAccount(cfg)
 
 	models.InitDB(cfg)
+	l.wxMgr.friendPolicy.ExpireAccepting()
+	l.friendCampaign = NewFriendCampaignManager(l.wxMgr, cfg)
 	l.Resume()
+	l.campaign = NewCampaignManager(l.wxMgr, cfg)
 
 	//err := l.memberRedis.StartAndGC()
 	//if err != nil {
@@ -131,6 +142,12 @@ func (self *WxLogic) WxSendMsgInfo(msg *wxweb.SendMsgInfo) bool {
 			MsgType:  v.MsgType,
 			Msg:      msgStr,
 		}
+		if v.Label != "" {
+			if !self.wxMgr.label.SendMsg(reqMsg, v.Label) {
+				return false
+			}
+			continue
+		}
 		ok := self.wxMgr.SendMsg(reqMsg, reqMsg.Msg)
 		if !ok {
 			return ok
@@ -153,10 +170,122 @@ func (self *WxLogic) RobotRemarkFriend(info *RobotRemarkFriendReq) bool {
 	return ok
 }
 
-func (self *WxLogic) RobotGroupTiren(info *RobotGroupTirenReq) (*wxweb.GroupUserInfo, bool) {
+func (self *WxLogic) RobotGroupTiren(info *RobotGroupTirenReq) (*wxweb.GroupUserInfo, error) {
 	return self.wxMgr.GroupTiren(info)
 }
 
+func (self *WxLogic) RobotCreateGroup(info *RobotCreateGroupReq) (*wxweb.WxGroup, error) {
+	return self.wxMgr.CreateGroup(info)
+}
+
+func (self *WxLogic) RobotGroupInvite(info *RobotGroupInviteReq) (*RobotGroupInviteRsp, error) {
+	return self.wxMgr.GroupInvite(info)
+}
+
+func (self *WxLogic) RobotGroupRename(info *RobotGroupRenameReq) (*wxweb.WxGroup, error) {
+	return self.wxMgr.GroupRename(info)
+}
+
+func (self *WxLogic) RobotGroupNameLock(info *RobotGroupNameLockReq) error {
+	return self.wxMgr.GroupNameLock(info)
+}
+
+func (self *WxLogic) RobotGroupWelcome(info *RobotGroupWelcomeReq) error {
+	return self.welcome.SetGroupWelcome(info)
+}
+
+func (self *WxLogic) RobotGetGroupStats(info *RobotGetGroupStatsReq) (*GroupStatsRsp, error) {
+	return self.wxMgr.GetGroupStats(info)
+}
+
+func (self *WxLogic) RobotAddGroupMemberJob(info *RobotAddGroupMemberJobReq) (*wxweb.AddGroupMemberStatus, error) {
+	return self.wxMgr.AddGroupMemberJob(info)
+}
+
+func (self *WxLogic) RobotDeadFriendScan(info *RobotDeadFriendScanReq) (interface{}, error) {
+	return self.wxMgr.DeadFriendScan(info)
+}
+
+func (self *WxLogic) RobotFriendPolicy(info *RobotFriendPolicyReq) ([]FriendPolicy, error) {
+	return self.wxMgr.friendPolicy.Policies(info)
+}
+
+func (self *WxLogic) RobotGroupModeration(info *RobotGroupModerationReq) (interface{}, error) {
+	return self.wxMgr.GroupModeration(info)
+}
+
+func (self *WxLogic) RobotFriendRequests(info *RobotFriendRequestsReq) (interface{}, error) {
+	return self.wxMgr.friendPolicy.Requests(info)
+}
+
+func (self *WxLogic) RobotContactLabels(info *RobotContactLabelReq) (interface{}, error) {
+	return self.wxMgr.label.Labels(info)
+}
+
+func (self *WxLogic) RobotJobs(info *RobotJobsReq) (interface{}, error) {
+	return self.wxMgr.Jobs(info)
+}
+
+func (self *WxLogic) RobotInviteCampaign(info *RobotInviteCampaignReq) (*wxweb.JobInfo, error) {
+	return self.invite.Start(info)
+}
+
+func (self *WxLogic) RobotInviteReport(info *RobotInviteReportReq) (*models.RobotInviteReport, error) {
+	return self.invite.Report(info)
+}
+
+func (self *WxLogic) RobotCampaignCreate(info *RobotCampaignCreateReq) (*models.RobotCampaign, error) {
+	return self.campaign.Create(info)
+}
+
+func (self *WxLogic) RobotCampaignControl(info *RobotCampaignControlReq) error {
+	return self.campaign.Control(info)
+}
+
+func (self *WxLogic) RobotCampaignProgress(info *RobotCampaignProgressReq) (*RobotCampaignProgressRsp, error) {
+	return self.campaign.Progress(info)
+}
+
+func (self *WxLogic) RobotFriendCampaignCreate(info *RobotFriendCampaignCreateReq) (*models.RobotFriendCampaign, error) {
+	return self.friendCampaign.Create(info)
+}
+
+func (self *WxLogic) RobotFriendCampaignControl(info *RobotCampaignControlReq) error {
+	return self.friendCampaign.Control(info)
+}
+
+func (self *WxLogic) RobotFriendCampaignProgress(info *RobotCampaignProgressReq) (*RobotFriendCampaignProgressRsp, error) {
+	return self.friendCampaign.Progress(info)
+}
+
+func (self *WxLogic) RobotCheckGroupChat(info *RobotCheckGroupChatReq) (*RobotCheckGroupChatRsp, error) {
+	return self.wxMgr.CheckGroupChat(&CheckGroupChatInfo{
+		WeChat:           info.WechatNick,
+		Group:            info.Group,
+		LastChatInterval: info.LastChatInterval,
+		Action:           info.Action,
+		Arg:              info.Arg,
+		KickLimit:        info.KickLimit,
+		DryRun:           info.DryRun,
+	})
+}
+
+func (self *WxLogic) RobotGetGroupMsgs(info *RobotGetGroupMsgsReq) (*RobotGetGroupMsgsRsp, error) {
+	return self.wxMgr.GetGroupMsgs(info)
+}
+
+func (self *WxLogic) RobotGetMessages(info *RobotGetMessagesReq) (*RobotGetMessagesRsp, error) {
+	return self.wxMgr.GetMessages(info)
+}
+
+func (self *WxLogic) RobotGetGroupInfo(info *RobotGetGroupInfoReq) (*wxweb.WxGroup, bool) {
+	return self.wxMgr.GetGroupInfo(info)
+}
+
+func (self *WxLogic) RobotGetGroupList(info *RobotGetGroupListReq) ([]wxweb.WxGroup, bool) {
+	return self.wxMgr.GetGroupList(info)
+}
+
 func (self *WxLogic) RobotGetGroupMemberList(info *RobotGetGroupMemberListReq) ([]*wxweb.GroupUserInfo, bool) {
 	memberMap, ok := self.wxMgr.GetGroupMemberList(info)
 	if !ok {
@@ -203,6 +332,7 @@ func (self *WxLogic) Logout(uuid string) {
 	wx, ok := self.wxs[uuid]
 	if ok {
 		self.wxMgr.UnregisterWx(wx)
+		self.wxMgr.friendPolicy.Logout(wx.RobotWxNick())
 		wx.Clear()
 		delete(self.wxs, uuid)
 		logrus.Infof("logic wx uuid[%s] logout succsss.", uuid)
@@ -212,7 +342,18 @@ func (self *WxLogic) Logout(uuid string) {
 }
 
 func (self *WxLogic) ReceiveMsg(msg *wxweb.ReceiveMsgInfo) {
+	self.wxMgr.groupStats.ReceiveMsg(msg)
+	// 媒体消息只做统计
+	if msg.IsMedia {
+		return
+	}
+	self.welcome.ReceiveMsg(msg)
 	self.eventMgr.ReceiveMsg(msg)
+	self.friendCampaign.ReceiveMsg(msg)
+}
+
+func (self *WxLogic) RunInviteCampaign(wx *wxweb.WxWeb, conf *wxweb.InviteCampaignConf, ctx *wxweb.JobContext) error {
+	return self.invite.Run(wx, conf, ctx)
 }
 
 func (self *WxLogic) RobotAddFriends(robot string, friends []wxweb.UserFriend) {
@@ -237,6 +378,7 @@ func (self *WxLogic) runCheck() {
 		select {
 		case <-time.After(60 * time.Second):
 			self.check()
+			self.cleanMsgArchive()
 		case <-self.stop:
 			close(self.done)
 			return
@@ -260,6 +402,38 @@ func (self *WxLogic) check() {
 	}
 }
 
+// cleanMsgArchive deletes archived messages out of the retention days.
+func (self *WxLogic) cleanMsgArchive() {
+	archive := self.cfg.MsgArchive
+	if !self.cfg.IfNeedOwnerDB || !archive.IfArchive {
+		return
+	}
+	now := time.Now().Unix()
+	if now-self.lastMsgArchiveClean < MSG_ARCHIVE_CLEAN_INTERVAL {
+		return
+	}
+	self.lastMsgArchiveClean = now
+
+	retentions := map[string]int64{
+		wxweb.FROM_TYPE_GROUP:  archive.GroupRetentionDays,
+		wxweb.FROM_TYPE_PEOPLE: archive.PeopleRetentionDays,
+	}
+	for chatType, days := range retentions {
+		if days == 0 {
+			days = archive.RetentionDays
+		}
+		if days <= 0 {
+			continue
+		}
+		n, err := models.DelRobotMessages(chatType, now-days*86400)
+		if err != nil {
+			logrus.Errorf("clean msg archive chat type[%s] error: %v", chatType, err)
+			continue
+		}
+		logrus.Infof("clean msg archive chat type[%s] before %d days: %d", chatType, days, n)
+	}
+}
+
 func EnableDebug() {
 	logrus.SetLevel(logrus.DebugLevel)
 }
@@ -12,20 +12,28 @@ import (
 
 	"github.com/Sirupsen/logrus"
 	"github.com/reechou/wxrobot/config"
+	"github.com/reechou/wxrobot/models"
 	"github.com/reechou/wxrobot/wxweb"
 )
 
 type WxManager struct {
 	sync.Mutex
-	wxs map[string]*wxweb.WxWeb
-	cfg *config.Config
+	wxs        map[string]*wxweb.WxWeb
+	cfg        *config.Config
+	groupStats *GroupStats
+
+	friendPolicy *FriendPolicyManager
+	label        *LabelManager
 }
 
 func NewWxManager(cfg *config.Config) *WxManager {
 	wm := &WxManager{
-		wxs: make(map[string]*wxweb.WxWeb),
-		cfg: cfg,
+		wxs:        make(map[string]*wxweb.WxWeb),
+		cfg:        cfg,
+		groupStats: NewGroupStats(cfg),
 	}
+	wm.friendPolicy = NewFriendPolicyManager(wm, cfg)
+	wm.label = NewLabelManager(wm, cfg)
 	return wm
 }
 
@@ -56,8 +64,15 @@ func (self *WxManager) UnregisterWx(wx *wxweb.WxWeb) {
 	}
 }
 
+func (self *WxManager) GetWx(wechat string) *wxweb.WxWeb {
+	self.Lock()
+	defer self.Unlock()
+
+	return self.wxs[wechat]
+}
+
 func (self *WxManager) SendMsg(msg *SendMsgInfo, msgStr string) bool {
-	wx := self.wxs[msg.WeChat]
+	wx := self.GetWx(msg.WeChat)
 	if wx == nil {
 		logrus.Errorf("send msg unknown this wechat[%s].", msg.WeChat)
 		return false
@@ -67,9 +82,9 @@ func (self *WxManager) SendMsg(msg *SendMsgInfo, msgStr string) bool {
 		var userName string
 		if msg.UserName != "" {
 			userName = msg.UserName
-			uf := wx.Contact.Friends[userName]
+			uf := wx.Contact.GetFriend(userName)
 			if uf == nil {
-				uf := wx.Contact.NickFriends[msg.Name]
+				uf := wx.Contact.GetNickFriend(msg.Name)
 				if uf == nil {
 					logrus.Errorf("unkown this friend[%s]", msg.Name)
 					return false
@@ -80,7 +95,7 @@ func (self *WxManager) SendMsg(msg *SendMsgInfo, msgStr string) bool {
 				logrus.Debugf("send msg to people find username[%s] from request", userName)
 			}
 		} else {
-			uf := wx.Contact.NickFriends[msg.Name]
+			uf := wx.Contact.GetNickFriend(msg.Name)
 			if uf == nil {
 				logrus.Errorf("unkown this friend[%s]", msg.Name)
 				return false
@@ -200,7 +215,7 @@ func (self *WxManager) sendVideo(userName, videoMsg string, wx *wxweb.WxWeb) boo
 }
 
 func (self *WxManager) SendImgMsg(msg *SendImgInfo) {
-	wx := self.wxs[msg.WeChat]
+	wx := self.GetWx(msg.WeChat)
 	if wx == nil {
 		logrus.Errorf("send img msg unknown this wechat[%s].", msg.WeChat)
 		return
@@ -212,7 +227,7 @@ func (self *WxManager) SendImgMsg(msg *SendImgInfo) {
 }
 
 func (self *WxManager) VerifyUser(msg *wxweb.ReceiveMsgInfo) bool {
-	wx := self.wxs[msg.BaseInfo.WechatNick]
+	wx := self.GetWx(msg.BaseInfo.WechatNick)
 	if wx == nil {
 		logrus.Errorf("unknown this wechat[%s].", msg.BaseInfo.WechatNick)
 		return false
@@ -233,7 +248,7 @@ func (self *WxManager) CheckGroup() {
 }
 
 func (self *WxManager) StateGroupNum(wechat, g string) string {
-	wx := self.wxs[wechat]
+	wx := self.GetWx(wechat)
 	if wx == nil {
 		logrus.Errorf("unknown this wechat[%s].", wechat)
 		return ""
@@ -243,12 +258,12 @@ func (self *WxManager) StateGroupNum(wechat, g string) string {
 	allGroupNum := 0
 	cfNum := 0
 	members := make(map[string]int)
-	for _, v := range wx.Contact.Groups {
-		if !ExecCheckFunc(g, v.NickName) {
+	for _, v := range wx.Contact.GroupsSnapshot() {
+		if !ExecCheckGroupFunc(g, v.GetNickName(), v.IsOwner()) {
 			continue
 		}
 		allGroupNum++
-		for _, v2 := range v.MemberList {
+		for _, v2 := range v.MembersSnapshot() {
 			_, ok := members[v2.UserName]
 			if ok {
 				cfNum++
@@ -261,12 +276,216 @@ func (self *WxManager) StateGroupNum(wechat, g string) string {
 	return fmt.Sprintf(result, g, allGroupNum, len(members), cfNum)
 }
 
-func (self *WxManager) CheckGroupChat(info *CheckGroupChatInfo) {
+// CheckGroupChat finds the groups nobody has spoken in for LastChatInterval
+// seconds and the members who never spoke since the robot saw them that long
+// ago, then notifies a friend, revives the silent groups or kicks the silent
+// members according to Action.
+func (self *WxManager) CheckGroupChat(info *CheckGroupChatInfo) (*RobotCheckGroupChatRsp, error) {
+	wx := self.GetWx(info.WeChat)
+	if wx == nil {
+		return nil, fmt.Errorf("unknown this wechat[%s]", info.WeChat)
+	}
+	if info.LastChatInterval <= 0 {
+		return nil, fmt.Errorf("lastChatInterval[%d] must be positive", info.LastChatInterval)
+	}
+	var notifyFriend *wxweb.UserFriend
+	kickLimit := info.KickLimit
+	switch info.Action {
+	case "":
+	case CHECK_GROUP_CHAT_KICK:
+		if info.LastChatInterval < CHECK_GROUP_CHAT_KICK_MIN_TRACK {
+			return nil, fmt.Errorf("kick needs lastChatInterval[%d] >= %d", info.LastChatInterval, CHECK_GROUP_CHAT_KICK_MIN_TRACK)
+		}
+		if kickLimit <= 0 {
+			kickLimit = CHECK_GROUP_CHAT_KICK_LIMIT
+		}
+	case CHECK_GROUP_CHAT_NOTIFY:
+		notifyFriend = wx.Contact.FindFriend(info.Arg, info.Arg)
+		if notifyFriend == nil {
+			return nil, fmt.Errorf("cannot found notify friend[%s]", info.Arg)
+		}
+	case CHECK_GROUP_CHAT_REVIVE:
+		if info.Arg == "" {
+			return nil, fmt.Errorf("revive msg is empty")
+		}
+	default:
+		return nil, fmt.Errorf("unknown action[%s]", info.Action)
+	}
+
+	now := time.Now().Unix()
+	before := now - int64(info.LastChatInterval)
+	rsp := &RobotCheckGroupChatRsp{}
+	for _, ug := range wx.Contact.GroupsSnapshot() {
+		if !ExecCheckGroupFunc(info.Group, ug.GetNickName(), ug.IsOwner()) {
+			continue
+		}
+		_, lastMsgTime := ug.GetLastMsg()
+		lastActive := lastMsgTime
+		if lastActive == 0 {
+			lastActive = ug.GetCreateTime()
+		}
+		g := CheckGroupChatGroup{
+			GroupUserName: ug.UserName,
+			GroupNickName: ug.GetNickName(),
+			LastMsgTime:   lastMsgTime,
+			Silent:        lastActive <= before,
+		}
+		owner := ug.GetOwner()
+		for _, v := range ug.SilentMembers(before) {
+			if v.UserName == wx.Session.MyUserName || v.UserName == owner {
+				continue
+			}
+			g.SilentMembers = append(g.SilentMembers, v)
+		}
+		if !g.Silent && len(g.SilentMembers) == 0 {
+			continue
+		}
+
+		switch info.Action {
+		case CHECK_GROUP_CHAT_REVIVE:
+			if g.Silent && !wx.Webwxsendmsg(info.Arg, ug.UserName) {
+				logrus.Errorf("check group chat revive group[%s] send msg error.", g.GroupNickName)
+			}
+		case CHECK_GROUP_CHAT_KICK:
+			if !ug.IsOwner() {
+				break
+			}
+			// 重启后所有人都没有发言记录, 跟踪时间不够的群不踢
+			if ug.GetCreateTime() > now-CHECK_GROUP_CHAT_KICK_MIN_TRACK {
+				logrus.Infof("check group chat group[%s] tracked since %d, too short to kick.", g.GroupNickName, ug.GetCreateTime())
+				break
+			}
+			for _, v := range g.SilentMembers {
+				if kickLimit <= 0 {
+					break
+				}
+				kickLimit--
+				g.ToKick = append(g.ToKick, v.NickName)
+			}
+			if len(g.ToKick) == 0 {
+				break
+			}
+			logrus.Infof("check group chat wechat[%s] group[%s] dryRun[%v] kick members: %v",
+				info.WeChat, g.GroupNickName, info.DryRun, g.ToKick)
+			if info.DryRun {
+				break
+			}
+			for _, v := range g.SilentMembers[:len(g.ToKick)] {
+				if !wx.DelMemberWebwxupdatechatroom(ug.UserName, v.UserName) {
+					logrus.Errorf("check group chat kick member[%s] from group[%s] error.", v.NickName, g.GroupNickName)
+					continue
+				}
+				ug.DelMember(v.UserName)
+				g.Kicked = append(g.Kicked, v.NickName)
+				time.Sleep(CHECK_GROUP_CHAT_KICK_INTERVAL * time.Second)
+			}
+		}
+		rsp.Groups = append(rsp.Groups, g)
+	}
+	logrus.Infof("check group chat wechat[%s] group[%s] interval[%d] action[%s] found %d groups.",
+		info.WeChat, info.Group, info.LastChatInterval, info.Action, len(rsp.Groups))
 
+	if notifyFriend != nil && len(rsp.Groups) != 0 {
+		var msg []string
+		for _, v := range rsp.Groups {
+			line := fmt.Sprintf("群[%s] %d人未发言", v.GroupNickName, len(v.SilentMembers))
+			if v.Silent {
+				line += fmt.Sprintf(", 超过%d秒无人发言", info.LastChatInterval)
+			}
+			msg = append(msg, line)
+		}
+		if !wx.Webwxsendmsg(strings.Join(msg, "\n"), notifyFriend.UserName) {
+			logrus.Errorf("check group chat notify friend[%s] error.", info.Arg)
+		}
+	}
+
+	return rsp, nil
+}
+
+func (self *WxManager) AddGroupMemberJob(info *RobotAddGroupMemberJobReq) (*wxweb.AddGroupMemberStatus, error) {
+	wx := self.GetWx(info.WechatNick)
+	if wx == nil {
+		return nil, fmt.Errorf("unknown this wechat[%s]", info.WechatNick)
+	}
+	var err error
+	switch info.Action {
+	case "", JOB_ACTION_STATUS:
+	case JOB_ACTION_PAUSE:
+		_, err = wx.PauseJob(wxweb.JOB_ADD_GROUP_MEMBER)
+	case JOB_ACTION_RESUME:
+		err = wx.EnableAddGroupMember()
+	case JOB_ACTION_CONFIG:
+		if info.Conf == nil {
+			return nil, fmt.Errorf("conf cannot be nil")
+		}
+		err = wx.SetAddGroupMemberConf(info.Conf)
+	default:
+		return nil, fmt.Errorf("unknown action[%s]", info.Action)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return wx.AddGroupMemberStatus(), nil
+}
+
+func (self *WxManager) Jobs(info *RobotJobsReq) (interface{}, error) {
+	wx := self.GetWx(info.WechatNick)
+	if wx == nil {
+		return nil, fmt.Errorf("unknown this wechat[%s]", info.WechatNick)
+	}
+	switch info.Action {
+	case "", JOB_ACTION_LIST:
+		return &RobotJobsListRsp{Names: wxweb.JobNames(), Jobs: wx.ListJobs()}, nil
+	case JOB_ACTION_STATUS:
+		return wx.GetJob(info.Name)
+	case JOB_ACTION_START:
+		return wx.StartJob(info.Name, info.Argv)
+	case JOB_ACTION_PAUSE:
+		return wx.PauseJob(info.Name)
+	case JOB_ACTION_RESUME:
+		return wx.ResumeJob(info.Name)
+	case JOB_ACTION_CANCEL:
+		return wx.CancelJob(info.Name)
+	}
+	return nil, fmt.Errorf("unknown action[%s]", info.Action)
+}
+
+func (self *WxManager) DeadFriendScan(info *RobotDeadFriendScanReq) (interface{}, error) {
+	if info.Action == JOB_ACTION_REPORT {
+		if !self.cfg.IfNeedOwnerDB {
+			return nil, fmt.Errorf("dead friend report needs owner db")
+		}
+		report := &models.RobotDeadFriendReport{ID: info.ID}
+		has, err := models.GetRobotDeadFriendReport(report)
+		if err != nil {
+			return nil, err
+		}
+		if !has {
+			return nil, fmt.Errorf("cannot found dead friend report[%d]", info.ID)
+		}
+		return report, nil
+	}
+
+	wx := self.GetWx(info.WechatNick)
+	if wx == nil {
+		return nil, fmt.Errorf("unknown this wechat[%s]", info.WechatNick)
+	}
+	switch info.Action {
+	case "", JOB_ACTION_STATUS:
+	case JOB_ACTION_START:
+		return wx.StartDeadFriendScan(info.Conf)
+	case JOB_ACTION_STOP:
+		if err := wx.StopDeadFriendScan(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown action[%s]", info.Action)
+	}
+	return wx.DeadFriendReport(), nil
 }
 
 func (self *WxManager) FindFriend(info *RobotFindFriendReq) *wxweb.UserFriend {
-	wx := self.wxs[info.WechatNick]
+	wx := self.GetWx(info.WechatNick)
 	if wx == nil {
 		logrus.Errorf("find friend unknown this wechat[%s].", info.WechatNick)
 		return nil
@@ -275,7 +494,7 @@ func (self *WxManager) FindFriend(info *RobotFindFriendReq) *wxweb.UserFriend {
 }
 
 func (self *WxManager) RemarkFriend(info *RobotRemarkFriendReq) bool {
-	wx := self.wxs[info.WechatNick]
+	wx := self.GetWx(info.WechatNick)
 	if wx == nil {
 		logrus.Errorf("remark friend unknown this wechat[%s].", info.WechatNick)
 		return false
@@ -296,26 +515,231 @@ func (self *WxManager) RemarkFriend(info *RobotRemarkFriendReq) bool {
 	return ok
 }
 
-func (self *WxManager) GroupTiren(info *RobotGroupTirenReq) (*wxweb.GroupUserInfo, bool) {
-	wx := self.wxs[info.WechatNick]
+func (self *WxManager) GroupTiren(info *RobotGroupTirenReq) (*wxweb.GroupUserInfo, error) {
+	wx := self.GetWx(info.WechatNick)
 	if wx == nil {
 		logrus.Errorf("group tiren unknown this wechat[%s].", info.WechatNick)
-		return nil, false
+		return nil, fmt.Errorf("unknown this wechat[%s]", info.WechatNick)
 	}
 	ug, gui := wx.Contact.FindGroupUser(info.GroupUserName, info.GroupNickName, info.MemberUserName, info.MemberNickName)
-	if gui != nil {
-		ok := wx.DelMemberWebwxupdatechatroom(ug.UserName, gui.UserName)
-		if ok {
-			ug.DelMember(gui.UserName)
+	if gui == nil {
+		logrus.Errorf("wx[%s] find group user none: %v", wx.Session.MyNickName, info)
+		return nil, fmt.Errorf("cannot found this group member")
+	}
+	if err := ug.CheckOwner(); err != nil {
+		logrus.Errorf("wx[%s] group tiren error: %v", wx.Session.MyNickName, err)
+		return nil, err
+	}
+	if !wx.DelMemberWebwxupdatechatroom(ug.UserName, gui.UserName) {
+		return gui, fmt.Errorf("webwx del member[%s] from group[%s] error", gui.UserName, ug.UserName)
+	}
+	ug.DelMember(gui.UserName)
+	return gui, nil
+}
+
+func (self *WxManager) GetGroupInfo(info *RobotGetGroupInfoReq) (*wxweb.WxGroup, bool) {
+	wx := self.GetWx(info.WechatNick)
+	if wx == nil {
+		logrus.Errorf("get group info unknown this wechat[%s].", info.WechatNick)
+		return nil, false
+	}
+	ug := wx.Contact.FindGroup(info.GroupUserName, info.GroupNickName)
+	if ug == nil {
+		logrus.Errorf("cannot found this group[%v]", info)
+		return nil, false
+	}
+	group := ug.WxGroup()
+	return &group, true
+}
+
+func (self *WxManager) GetGroupList(info *RobotGetGroupListReq) ([]wxweb.WxGroup, bool) {
+	wx := self.GetWx(info.WechatNick)
+	if wx == nil {
+		logrus.Errorf("get group list unknown this wechat[%s].", info.WechatNick)
+		return nil, false
+	}
+	var list []wxweb.WxGroup
+	for _, v := range wx.Contact.GroupsSnapshot() {
+		if info.OnlyOwner && !v.IsOwner() {
+			continue
+		}
+		list = append(list, v.WxGroup())
+	}
+	return list, true
+}
+
+func (self *WxManager) CreateGroup(info *RobotCreateGroupReq) (*wxweb.WxGroup, error) {
+	wx := self.GetWx(info.WechatNick)
+	if wx == nil {
+		logrus.Errorf("create group unknown this wechat[%s].", info.WechatNick)
+		return nil, fmt.Errorf("unknown this wechat[%s]", info.WechatNick)
+	}
+	var usernameList []string
+	for _, v := range info.Members {
+		uf := wx.Contact.FindFriend(v, v)
+		if uf == nil {
+			return nil, fmt.Errorf("unknown this friend[%s]", v)
+		}
+		usernameList = append(usernameList, uf.UserName)
+	}
+	ug, err := wx.Contact.CreateGroup(usernameList, info.Topic)
+	if err != nil {
+		logrus.Errorf("wx[%s] create group[%s] error: %v", wx.Session.MyNickName, info.Topic, err)
+		return nil, err
+	}
+	group := ug.WxGroup()
+	return &group, nil
+}
+
+func (self *WxManager) GroupInvite(info *RobotGroupInviteReq) (*RobotGroupInviteRsp, error) {
+	wx := self.GetWx(info.WechatNick)
+	if wx == nil {
+		logrus.Errorf("group invite unknown this wechat[%s].", info.WechatNick)
+		return nil, fmt.Errorf("unknown this wechat[%s]", info.WechatNick)
+	}
+	ug := wx.Contact.FindGroup(info.GroupUserName, info.GroupNickName)
+	if ug == nil {
+		logrus.Errorf("wx[%s] group invite cannot found this group[%v]", wx.Session.MyNickName, info)
+		return nil, fmt.Errorf("cannot found this group")
+	}
+	var friends []*wxweb.UserFriend
+	for _, v := range info.Members {
+		uf := wx.Contact.FindFriend(v, v)
+		if uf == nil {
+			return nil, fmt.Errorf("unknown this friend[%s]", v)
+		}
+		friends = append(friends, uf)
+	}
+	results, err := wx.Contact.InviteGroupMembers(ug, friends)
+	if results == nil {
+		return nil, err
+	}
+	return &RobotGroupInviteRsp{Group: ug.WxGroup(), Results: results}, err
+}
+
+func (self *WxManager) GroupRename(info *RobotGroupRenameReq) (*wxweb.WxGroup, error) {
+	wx := self.GetWx(info.WechatNick)
+	if wx == nil {
+		logrus.Errorf("group rename unknown this wechat[%s].", info.WechatNick)
+		return nil, fmt.Errorf("unknown this wechat[%s]", info.WechatNick)
+	}
+	ug := wx.Contact.FindGroup(info.GroupUserName, info.GroupNickName)
+	if ug == nil {
+		logrus.Errorf("wx[%s] group rename cannot found this group[%v]", wx.Session.MyNickName, info)
+		return nil, fmt.Errorf("cannot found this group")
+	}
+	if err := wx.Contact.RenameGroupTopic(ug, info.Topic); err != nil {
+		logrus.Errorf("wx[%s] group rename error: %v", wx.Session.MyNickName, err)
+		return nil, err
+	}
+	group := ug.WxGroup()
+	return &group, nil
+}
+
+func (self *WxManager) GroupNameLock(info *RobotGroupNameLockReq) error {
+	wx := self.GetWx(info.WechatNick)
+	if wx == nil {
+		logrus.Errorf("group name lock unknown this wechat[%s].", info.WechatNick)
+		return fmt.Errorf("unknown this wechat[%s]", info.WechatNick)
+	}
+	ug := wx.Contact.FindGroup(info.GroupUserName, info.GroupNickName)
+	if ug == nil {
+		logrus.Errorf("wx[%s] group name lock cannot found this group[%v]", wx.Session.MyNickName, info)
+		return fmt.Errorf("cannot found this group")
+	}
+	return wx.Contact.SetGroupNameLock(ug, info.Lock)
+}
+
+func (self *WxManager) GetGroupStats(info *RobotGetGroupStatsReq) (*GroupStatsRsp, error) {
+	group := info.GroupNickName
+	if info.GroupUserName != "" {
+		wx := self.GetWx(info.WechatNick)
+		if wx == nil {
+			return nil, fmt.Errorf("unknown this wechat[%s]", info.WechatNick)
+		}
+		ug := wx.Contact.FindGroup(info.GroupUserName, info.GroupNickName)
+		if ug == nil {
+			return nil, fmt.Errorf("cannot found this group")
 		}
-		return gui, ok
+		group = ug.GetNickName()
+	}
+	return self.groupStats.Stats(info.WechatNick, group, info.Days, info.Top)
+}
+
+func (self *WxManager) GetGroupMsgs(info *RobotGetGroupMsgsReq) (*RobotGetGroupMsgsRsp, error) {
+	wx := self.GetWx(info.WechatNick)
+	if wx == nil {
+		logrus.Errorf("get group msgs unknown this wechat[%s].", info.WechatNick)
+		return nil, fmt.Errorf("unknown this wechat[%s]", info.WechatNick)
+	}
+	ug := wx.Contact.FindGroup(info.GroupUserName, info.GroupNickName)
+	if ug == nil {
+		logrus.Errorf("wx[%s] get group msgs cannot found this group[%v]", wx.Session.MyNickName, info)
+		return nil, fmt.Errorf("cannot found this group")
+	}
+	wait := info.Wait
+	if wait > GROUP_MSGS_MAX_WAIT {
+		wait = GROUP_MSGS_MAX_WAIT
+	}
+	lastMsgId := -1
+	if info.LastMsgId != nil {
+		lastMsgId = *info.LastMsgId
+	}
+	var list []wxweb.MsgInfo
+	var lost bool
+	if wait > 0 {
+		list, lost = ug.WaitMsgList(lastMsgId, time.Duration(wait)*time.Second)
+	} else {
+		list, lost = ug.GetMsgList(lastMsgId)
+	}
+	rsp := &RobotGetGroupMsgsRsp{
+		Msgs:      list,
+		Lost:      lost,
+		LastMsgId: lastMsgId,
+	}
+	if len(list) != 0 {
+		rsp.LastMsgId = list[len(list)-1].MsgID
+	} else if lost {
+		// 缓冲为空(如重新登录), 只报告一次丢失, 下次从最早的开始等待
+		rsp.LastMsgId = -1
+	}
+	return rsp, nil
+}
+
+func (self *WxManager) GetMessages(info *RobotGetMessagesReq) (*RobotGetMessagesRsp, error) {
+	if !self.cfg.IfNeedOwnerDB || !self.cfg.MsgArchive.IfArchive {
+		return nil, fmt.Errorf("msg archive is not enabled")
+	}
+	limit := info.Limit
+	if limit <= 0 {
+		limit = MSG_ARCHIVE_DEFAULT_LIMIT
+	} else if limit > MSG_ARCHIVE_MAX_LIMIT {
+		limit = MSG_ARCHIVE_MAX_LIMIT
+	}
+	list, err := models.GetRobotMessages(&models.RobotMessageQuery{
+		RobotWx:   info.WechatNick,
+		ChatType:  info.ChatType,
+		ChatName:  info.ChatName,
+		Sender:    info.Sender,
+		StartTime: info.StartTime,
+		EndTime:   info.EndTime,
+		Keyword:   info.Keyword,
+		Cursor:    info.Cursor,
+		Limit:     limit,
+	})
+	if err != nil {
+		logrus.Errorf("get robot messages error: %v", err)
+		return nil, err
+	}
+	rsp := &RobotGetMessagesRsp{Messages: list}
+	if len(list) == limit {
+		rsp.NextCursor = list[len(list)-1].ID
 	}
-	logrus.Errorf("wx[%s] find group user none: %v", wx.Session.MyNickName, info)
-	return nil, false
+	return rsp, nil
 }
 
 func (self *WxManager) GetGroupMemberList(info *RobotGetGroupMemberListReq) (map[string]*wxweb.GroupUserInfo, bool) {
-	wx := self.wxs[info.WechatNick]
+	wx := self.GetWx(info.WechatNick)
 	if wx == nil {
 		logrus.Errorf("get group member list unknown this wechat[%s].", info.WechatNick)
 		return nil, false
@@ -329,7 +753,7 @@ func (self *WxManager) GetGroupMemberList(info *RobotGetGroupMemberListReq) (map
 }
 
 func (self *WxManager) AddFriend(info *RobotAddFriendReq) bool {
-	wx := self.wxs[info.WechatNick]
+	wx := self.GetWx(info.WechatNick)
 	if wx == nil {
 		logrus.Errorf("add friend unknown this wechat[%s].", info.WechatNick)
 		return false
@@ -0,0 +1,222 @@
+package logic
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/reechou/wxrobot/config"
+	"github.com/reechou/wxrobot/models"
+	"github.com/reechou/wxrobot/wxweb"
+)
+
+type WelcomeMsg struct {
+	MsgType string `json:"msgType"` // text img link
+	Msg     string `json:"msg,omitempty"`
+	Title   string `json:"title,omitempty"`
+	Desc    string `json:"desc,omitempty"`
+	Url     string `json:"url,omitempty"`
+}
+
+type welcomeBatch struct {
+	wechat        string
+	groupUserName string
+	groupName     string
+	memberNum     int
+	nicks         []string
+	msgs          []WelcomeMsg
+}
+
+// WelcomeManager welcomes new group members, joins within BatchSeconds are
+// merged into one welcome and two welcomes of a group are at least
+// CooldownSeconds apart.
+type WelcomeManager struct {
+	sync.Mutex
+
+	wxm *WxManager
+	cfg *config.Config
+
+	batches  map[string]*welcomeBatch
+	lastSent map[string]int64
+}
+
+func NewWelcomeManager(wxm *WxManager, cfg *config.Config) *WelcomeManager {
+	return &WelcomeManager{
+		wxm:      wxm,
+		cfg:      cfg,
+		batches:  make(map[string]*welcomeBatch),
+		lastSent: make(map[string]int64),
+	}
+}
+
+func (self *WelcomeManager) ReceiveMsg(msg *wxweb.ReceiveMsgInfo) {
+	if !self.cfg.IfNeedOwnerDB || msg.BaseInfo.ReceiveEvent != wxweb.RECEIVE_EVENT_MOD_GROUP_ADD_DETAIL {
+		return
+	}
+	if msg.BaseInfo.FromMemberUserName == msg.BaseInfo.UserName {
+		return
+	}
+
+	key := msg.BaseInfo.WechatNick + msg.BaseInfo.FromUserName
+
+	self.Lock()
+	defer self.Unlock()
+
+	if batch, ok := self.batches[key]; ok {
+		batch.nicks = append(batch.nicks, msg.BaseInfo.FromNickName)
+		batch.memberNum = msg.GroupMemberNum
+		return
+	}
+
+	welcome := getGroupWelcome(msg.BaseInfo.WechatNick, msg.BaseInfo.FromGroupName)
+	if welcome == nil {
+		return
+	}
+	var msgs []WelcomeMsg
+	if err := json.Unmarshal([]byte(welcome.Msgs), &msgs); err != nil {
+		logrus.Errorf("wx[%s] group[%s] welcome msgs[%s] json decode error: %v", msg.BaseInfo.WechatNick, msg.BaseInfo.FromGroupName, welcome.Msgs, err)
+		return
+	}
+	if len(msgs) == 0 {
+		return
+	}
+	self.batches[key] = &welcomeBatch{
+		wechat:        msg.BaseInfo.WechatNick,
+		groupUserName: msg.BaseInfo.FromUserName,
+		groupName:     msg.BaseInfo.FromGroupName,
+		memberNum:     msg.GroupMemberNum,
+		nicks:         []string{msg.BaseInfo.FromNickName},
+		msgs:          msgs,
+	}
+	delay := welcome.BatchSeconds
+	if wait := self.lastSent[key] + welcome.CooldownSeconds - time.Now().Unix(); wait > delay {
+		delay = wait
+	}
+	time.AfterFunc(time.Duration(delay)*time.Second, func() {
+		self.send(key)
+	})
+}
+
+// getGroupWelcome returns the enabled welcome of the group or the robot default.
+func getGroupWelcome(wechat, groupName string) *models.RobotGroupWelcome {
+	for _, name := range []string{groupName, ""} {
+		welcome := &models.RobotGroupWelcome{
+			RobotWx:   wechat,
+			GroupName: name,
+		}
+		has, err := models.GetRobotGroupWelcome(welcome)
+		if err != nil {
+			logrus.Errorf("get robot group welcome error: %v", err)
+			return nil
+		}
+		if has {
+			if welcome.IfEnable == 0 {
+				return nil
+			}
+			return welcome
+		}
+	}
+	return nil
+}
+
+func (self *WelcomeManager) send(key string) {
+	self.Lock()
+	batch := self.batches[key]
+	delete(self.batches, key)
+	self.lastSent[key] = time.Now().Unix()
+	self.Unlock()
+	if batch == nil {
+		return
+	}
+
+	wx := self.wxm.GetWx(batch.wechat)
+	if wx == nil {
+		logrus.Errorf("welcome unknown this wechat[%s].", batch.wechat)
+		return
+	}
+	ug := wx.Contact.GetGroup(batch.groupUserName)
+	if ug != nil {
+		batch.groupName = ug.GetNickName()
+		batch.memberNum = ug.GetGroupMemberLen()
+	}
+
+	replacer := strings.NewReplacer(
+		WELCOME_NICK, welcomeNicks(batch.nicks),
+		WELCOME_COUNT, strconv.Itoa(batch.memberNum),
+		WELCOME_GROUP, batch.groupName,
+	)
+	for _, v := range batch.msgs {
+		if !self.wxm.sendTypedMsg(wx, batch.groupUserName, v, replacer) {
+			logrus.Errorf("wx[%s] group[%s] send welcome[%v] error", batch.wechat, batch.groupName, v)
+		}
+		time.Sleep(time.Second)
+	}
+	logrus.Infof("wx[%s] group[%s] welcome %d new members.", batch.wechat, batch.groupName, len(batch.nicks))
+}
+
+// sendTypedMsg sends a text, img or link msg with the placeholders replaced.
+func (self *WxManager) sendTypedMsg(wx *wxweb.WxWeb, userName string, v WelcomeMsg, replacer *strings.Replacer) bool {
+	switch v.MsgType {
+	case MSG_TYPE_TEXT:
+		return wx.Webwxsendmsg(replacer.Replace(v.Msg), userName)
+	case MSG_TYPE_IMG:
+		return self.sendImg(userName, v.Msg, wx)
+	case MSG_TYPE_LINK:
+		return wx.WebwxsendmsgLink(replacer.Replace(v.Title), replacer.Replace(v.Desc), v.Url, userName)
+	}
+	logrus.Errorf("unknown msg type[%s]", v.MsgType)
+	return false
+}
+
+func welcomeNicks(nicks []string) string {
+	var list []string
+	for i, v := range nicks {
+		if i >= WELCOME_MAX_NICK {
+			break
+		}
+		list = append(list, "@"+v)
+	}
+	s := strings.Join(list, " ")
+	if len(nicks) > WELCOME_MAX_NICK {
+		s = fmt.Sprintf("%s 等%d人", s, len(nicks))
+	}
+	return s
+}
+
+func (self *WelcomeManager) SetGroupWelcome(info *RobotGroupWelcomeReq) error {
+	if !self.cfg.IfNeedOwnerDB {
+		return fmt.Errorf("group welcome needs owner db")
+	}
+	for _, v := range info.Msgs {
+		if v.MsgType != MSG_TYPE_TEXT && v.MsgType != MSG_TYPE_IMG && v.MsgType != MSG_TYPE_LINK {
+			return fmt.Errorf("unknown welcome msg type[%s]", v.MsgType)
+		}
+	}
+	msgs, err := json.Marshal(info.Msgs)
+	if err != nil {
+		return err
+	}
+	welcome := &models.RobotGroupWelcome{
+		RobotWx:   info.WechatNick,
+		GroupName: info.GroupNickName,
+	}
+	has, err := models.GetRobotGroupWelcome(welcome)
+	if err != nil {
+		return err
+	}
+	welcome.IfEnable = 0
+	if info.Enable {
+		welcome.IfEnable = 1
+	}
+	welcome.Msgs = string(msgs)
+	welcome.BatchSeconds = info.BatchSeconds
+	welcome.CooldownSeconds = info.CooldownSeconds
+	if has {
+		return models.UpdateRobotGroupWelcome(welcome)
+	}
+	return models.CreateRobotGroupWelcome(welcome)
+}
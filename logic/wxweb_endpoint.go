@@ -98,9 +98,10 @@ func (self *WxHttpSrv) RobotGroupTiren(rsp http.ResponseWriter, req *http.Reques
 
 	response := WxResponse{Code: WX_RESPONSE_OK}
 
-	gui, ok := self.l.RobotGroupTiren(request)
-	if !ok {
+	gui, err := self.l.RobotGroupTiren(request)
+	if err != nil {
 		response.Code = WX_RESPONSE_ERR
+		response.Msg = err.Error()
 	} else {
 		response.Data = gui
 	}
@@ -127,6 +128,514 @@ func (self *WxHttpSrv) RobotGetGroupMemberList(rsp http.ResponseWriter, req *htt
 	return response, nil
 }
 
+func (self *WxHttpSrv) RobotCreateGroup(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	request := &RobotCreateGroupReq{}
+	if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+		logrus.Errorf("RobotCreateGroup json decode error: %v", err)
+		return nil, err
+	}
+
+	response := WxResponse{Code: WX_RESPONSE_OK}
+
+	group, err := self.l.RobotCreateGroup(request)
+	if err != nil {
+		response.Code = WX_RESPONSE_ERR
+		response.Msg = err.Error()
+	} else {
+		response.Data = group
+	}
+
+	return response, nil
+}
+
+func (self *WxHttpSrv) RobotGroupInvite(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	request := &RobotGroupInviteReq{}
+	if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+		logrus.Errorf("RobotGroupInvite json decode error: %v", err)
+		return nil, err
+	}
+
+	response := WxResponse{Code: WX_RESPONSE_OK}
+
+	// 限频时也返回已处理成员的结果
+	result, err := self.l.RobotGroupInvite(request)
+	if err != nil {
+		response.Code = WX_RESPONSE_ERR
+		response.Msg = err.Error()
+	}
+	if result != nil {
+		response.Data = result
+	}
+
+	return response, nil
+}
+
+func (self *WxHttpSrv) RobotGroupRename(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	request := &RobotGroupRenameReq{}
+	if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+		logrus.Errorf("RobotGroupRename json decode error: %v", err)
+		return nil, err
+	}
+
+	response := WxResponse{Code: WX_RESPONSE_OK}
+
+	group, err := self.l.RobotGroupRename(request)
+	if err != nil {
+		response.Code = WX_RESPONSE_ERR
+		response.Msg = err.Error()
+	} else {
+		response.Data = group
+	}
+
+	return response, nil
+}
+
+func (self *WxHttpSrv) RobotGroupNameLock(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	request := &RobotGroupNameLockReq{}
+	if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+		logrus.Errorf("RobotGroupNameLock json decode error: %v", err)
+		return nil, err
+	}
+
+	response := WxResponse{Code: WX_RESPONSE_OK}
+
+	if err := self.l.RobotGroupNameLock(request); err != nil {
+		response.Code = WX_RESPONSE_ERR
+		response.Msg = err.Error()
+	}
+
+	return response, nil
+}
+
+func (self *WxHttpSrv) RobotGroupWelcome(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	request := &RobotGroupWelcomeReq{}
+	if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+		logrus.Errorf("RobotGroupWelcome json decode error: %v", err)
+		return nil, err
+	}
+
+	response := WxResponse{Code: WX_RESPONSE_OK}
+
+	if err := self.l.RobotGroupWelcome(request); err != nil {
+		response.Code = WX_RESPONSE_ERR
+		response.Msg = err.Error()
+	}
+
+	return response, nil
+}
+
+func (self *WxHttpSrv) RobotGetGroupStats(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	request := &RobotGetGroupStatsReq{}
+	if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+		logrus.Errorf("RobotGetGroupStats json decode error: %v", err)
+		return nil, err
+	}
+
+	response := WxResponse{Code: WX_RESPONSE_OK}
+
+	result, err := self.l.RobotGetGroupStats(request)
+	if err != nil {
+		response.Code = WX_RESPONSE_ERR
+		response.Msg = err.Error()
+	} else {
+		response.Data = result
+	}
+
+	return response, nil
+}
+
+func (self *WxHttpSrv) RobotFriendPolicy(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	request := &RobotFriendPolicyReq{}
+	if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+		logrus.Errorf("RobotFriendPolicy json decode error: %v", err)
+		return nil, err
+	}
+
+	response := WxResponse{Code: WX_RESPONSE_OK}
+
+	result, err := self.l.RobotFriendPolicy(request)
+	if err != nil {
+		response.Code = WX_RESPONSE_ERR
+		response.Msg = err.Error()
+	} else {
+		response.Data = result
+	}
+
+	return response, nil
+}
+
+func (self *WxHttpSrv) RobotGroupModeration(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	request := &RobotGroupModerationReq{}
+	if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+		logrus.Errorf("RobotGroupModeration json decode error: %v", err)
+		return nil, err
+	}
+
+	response := WxResponse{Code: WX_RESPONSE_OK}
+
+	result, err := self.l.RobotGroupModeration(request)
+	if err != nil {
+		response.Code = WX_RESPONSE_ERR
+		response.Msg = err.Error()
+	} else {
+		response.Data = result
+	}
+
+	return response, nil
+}
+
+func (self *WxHttpSrv) RobotFriendRequests(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	request := &RobotFriendRequestsReq{}
+	if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+		logrus.Errorf("RobotFriendRequests json decode error: %v", err)
+		return nil, err
+	}
+
+	response := WxResponse{Code: WX_RESPONSE_OK}
+
+	result, err := self.l.RobotFriendRequests(request)
+	if err != nil {
+		response.Code = WX_RESPONSE_ERR
+		response.Msg = err.Error()
+	} else {
+		response.Data = result
+	}
+
+	return response, nil
+}
+
+func (self *WxHttpSrv) RobotContactLabels(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	request := &RobotContactLabelReq{}
+	if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+		logrus.Errorf("RobotContactLabels json decode error: %v", err)
+		return nil, err
+	}
+
+	response := WxResponse{Code: WX_RESPONSE_OK}
+
+	result, err := self.l.RobotContactLabels(request)
+	if err != nil {
+		response.Code = WX_RESPONSE_ERR
+		response.Msg = err.Error()
+	} else {
+		response.Data = result
+	}
+
+	return response, nil
+}
+
+func (self *WxHttpSrv) RobotJobs(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	request := &RobotJobsReq{}
+	if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+		logrus.Errorf("RobotJobs json decode error: %v", err)
+		return nil, err
+	}
+
+	response := WxResponse{Code: WX_RESPONSE_OK}
+
+	result, err := self.l.RobotJobs(request)
+	if err != nil {
+		response.Code = WX_RESPONSE_ERR
+		response.Msg = err.Error()
+	} else {
+		response.Data = result
+	}
+
+	return response, nil
+}
+
+func (self *WxHttpSrv) RobotAddGroupMemberJob(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	request := &RobotAddGroupMemberJobReq{}
+	if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+		logrus.Errorf("RobotAddGroupMemberJob json decode error: %v", err)
+		return nil, err
+	}
+
+	response := WxResponse{Code: WX_RESPONSE_OK}
+
+	result, err := self.l.RobotAddGroupMemberJob(request)
+	if err != nil {
+		response.Code = WX_RESPONSE_ERR
+		response.Msg = err.Error()
+	} else {
+		response.Data = result
+	}
+
+	return response, nil
+}
+
+func (self *WxHttpSrv) RobotDeadFriendScan(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	request := &RobotDeadFriendScanReq{}
+	if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+		logrus.Errorf("RobotDeadFriendScan json decode error: %v", err)
+		return nil, err
+	}
+
+	response := WxResponse{Code: WX_RESPONSE_OK}
+
+	result, err := self.l.RobotDeadFriendScan(request)
+	if err != nil {
+		response.Code = WX_RESPONSE_ERR
+		response.Msg = err.Error()
+	} else {
+		response.Data = result
+	}
+
+	return response, nil
+}
+
+func (self *WxHttpSrv) RobotInviteCampaign(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	request := &RobotInviteCampaignReq{}
+	if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+		logrus.Errorf("RobotInviteCampaign json decode error: %v", err)
+		return nil, err
+	}
+
+	response := WxResponse{Code: WX_RESPONSE_OK}
+
+	result, err := self.l.RobotInviteCampaign(request)
+	if err != nil {
+		response.Code = WX_RESPONSE_ERR
+		response.Msg = err.Error()
+	} else {
+		response.Data = result
+	}
+
+	return response, nil
+}
+
+func (self *WxHttpSrv) RobotInviteReport(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	request := &RobotInviteReportReq{}
+	if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+		logrus.Errorf("RobotInviteReport json decode error: %v", err)
+		return nil, err
+	}
+
+	response := WxResponse{Code: WX_RESPONSE_OK}
+
+	result, err := self.l.RobotInviteReport(request)
+	if err != nil {
+		response.Code = WX_RESPONSE_ERR
+		response.Msg = err.Error()
+	} else {
+		response.Data = result
+	}
+
+	return response, nil
+}
+
+func (self *WxHttpSrv) RobotCampaignCreate(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	request := &RobotCampaignCreateReq{}
+	if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+		logrus.Errorf("RobotCampaignCreate json decode error: %v", err)
+		return nil, err
+	}
+
+	response := WxResponse{Code: WX_RESPONSE_OK}
+
+	result, err := self.l.RobotCampaignCreate(request)
+	if err != nil {
+		response.Code = WX_RESPONSE_ERR
+		response.Msg = err.Error()
+	} else {
+		response.Data = result
+	}
+
+	return response, nil
+}
+
+func (self *WxHttpSrv) RobotCampaignControl(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	request := &RobotCampaignControlReq{}
+	if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+		logrus.Errorf("RobotCampaignControl json decode error: %v", err)
+		return nil, err
+	}
+
+	response := WxResponse{Code: WX_RESPONSE_OK}
+
+	if err := self.l.RobotCampaignControl(request); err != nil {
+		response.Code = WX_RESPONSE_ERR
+		response.Msg = err.Error()
+	}
+
+	return response, nil
+}
+
+func (self *WxHttpSrv) RobotCampaignProgress(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	request := &RobotCampaignProgressReq{}
+	if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+		logrus.Errorf("RobotCampaignProgress json decode error: %v", err)
+		return nil, err
+	}
+
+	response := WxResponse{Code: WX_RESPONSE_OK}
+
+	result, err := self.l.RobotCampaignProgress(request)
+	if err != nil {
+		response.Code = WX_RESPONSE_ERR
+		response.Msg = err.Error()
+	} else {
+		response.Data = result
+	}
+
+	return response, nil
+}
+
+func (self *WxHttpSrv) RobotFriendCampaignCreate(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	request := &RobotFriendCampaignCreateReq{}
+	if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+		logrus.Errorf("RobotFriendCampaignCreate json decode error: %v", err)
+		return nil, err
+	}
+
+	response := WxResponse{Code: WX_RESPONSE_OK}
+
+	result, err := self.l.RobotFriendCampaignCreate(request)
+	if err != nil {
+		response.Code = WX_RESPONSE_ERR
+		response.Msg = err.Error()
+	} else {
+		response.Data = result
+	}
+
+	return response, nil
+}
+
+func (self *WxHttpSrv) RobotFriendCampaignControl(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	request := &RobotCampaignControlReq{}
+	if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+		logrus.Errorf("RobotFriendCampaignControl json decode error: %v", err)
+		return nil, err
+	}
+
+	response := WxResponse{Code: WX_RESPONSE_OK}
+
+	if err := self.l.RobotFriendCampaignControl(request); err != nil {
+		response.Code = WX_RESPONSE_ERR
+		response.Msg = err.Error()
+	}
+
+	return response, nil
+}
+
+func (self *WxHttpSrv) RobotFriendCampaignProgress(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	request := &RobotCampaignProgressReq{}
+	if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+		logrus.Errorf("RobotFriendCampaignProgress json decode error: %v", err)
+		return nil, err
+	}
+
+	response := WxResponse{Code: WX_RESPONSE_OK}
+
+	result, err := self.l.RobotFriendCampaignProgress(request)
+	if err != nil {
+		response.Code = WX_RESPONSE_ERR
+		response.Msg = err.Error()
+	} else {
+		response.Data = result
+	}
+
+	return response, nil
+}
+
+func (self *WxHttpSrv) RobotCheckGroupChat(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	request := &RobotCheckGroupChatReq{}
+	if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+		logrus.Errorf("RobotCheckGroupChat json decode error: %v", err)
+		return nil, err
+	}
+
+	response := WxResponse{Code: WX_RESPONSE_OK}
+
+	result, err := self.l.RobotCheckGroupChat(request)
+	if err != nil {
+		response.Code = WX_RESPONSE_ERR
+		response.Msg = err.Error()
+	} else {
+		response.Data = result
+	}
+
+	return response, nil
+}
+
+func (self *WxHttpSrv) RobotGetGroupMsgs(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	request := &RobotGetGroupMsgsReq{}
+	if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+		logrus.Errorf("RobotGetGroupMsgs json decode error: %v", err)
+		return nil, err
+	}
+
+	response := WxResponse{Code: WX_RESPONSE_OK}
+
+	result, err := self.l.RobotGetGroupMsgs(request)
+	if err != nil {
+		response.Code = WX_RESPONSE_ERR
+		response.Msg = err.Error()
+	} else {
+		response.Data = result
+	}
+
+	return response, nil
+}
+
+func (self *WxHttpSrv) RobotGetMessages(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	request := &RobotGetMessagesReq{}
+	if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+		logrus.Errorf("RobotGetMessages json decode error: %v", err)
+		return nil, err
+	}
+
+	response := WxResponse{Code: WX_RESPONSE_OK}
+
+	result, err := self.l.RobotGetMessages(request)
+	if err != nil {
+		response.Code = WX_RESPONSE_ERR
+		response.Msg = err.Error()
+	} else {
+		response.Data = result
+	}
+
+	return response, nil
+}
+
+func (self *WxHttpSrv) RobotGetGroupInfo(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	request := &RobotGetGroupInfoReq{}
+	if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+		logrus.Errorf("RobotGetGroupInfo json decode error: %v", err)
+		return nil, err
+	}
+
+	response := WxResponse{Code: WX_RESPONSE_OK}
+
+	group, ok := self.l.RobotGetGroupInfo(request)
+	if !ok {
+		response.Code = WX_RESPONSE_ERR
+	} else {
+		response.Data = group
+	}
+
+	return response, nil
+}
+
+func (self *WxHttpSrv) RobotGetGroupList(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	request := &RobotGetGroupListReq{}
+	if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+		logrus.Errorf("RobotGetGroupList json decode error: %v", err)
+		return nil, err
+	}
+
+	response := WxResponse{Code: WX_RESPONSE_OK}
+
+	list, ok := self.l.RobotGetGroupList(request)
+	if !ok {
+		response.Code = WX_RESPONSE_ERR
+	} else {
+		response.Data = list
+	}
+
+	return response, nil
+}
+
 func (self *WxHttpSrv) RobotAddFriend(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
 	request := &RobotAddFriendReq{}
 	if err := json.NewDecoder(req.Body).Decode(request); err != nil {
@@ -147,7 +656,18 @@ func (self *WxHttpSrv) RobotAddFriend(rsp http.ResponseWriter, req *http.Request
 func (self *WxHttpSrv) ReloadEvent(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
 	response := WxResponse{Code: WX_RESPONSE_OK}
 
-	self.l.eventMgr.ReloadFile()
+	if err := self.l.eventMgr.ReloadFile(); err != nil {
+		response.Code = WX_RESPONSE_ERR
+		response.Msg = err.Error()
+	}
+
+	return response, nil
+}
+
+func (self *WxHttpSrv) RobotRoles(rsp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	response := WxResponse{Code: WX_RESPONSE_OK}
+
+	response.Data = wxweb.RobotRoles()
 
 	return response, nil
 }
@@ -1,10 +1,21 @@
 package main
 
 import (
+	"fmt"
+	"os"
+
 	"github.com/reechou/wxrobot/config"
 	"github.com/reechou/wxrobot/logic"
 )
 
 func main() {
-	logic.NewWxLogic(config.NewConfig()).Run()
+	cfg := config.NewConfig()
+	if cfg.ConvertEventFile != "" {
+		if err := logic.ConvertEventFile(cfg.ConvertEventFile, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	logic.NewWxLogic(cfg).Run()
 }
@@ -33,7 +33,24 @@ func InitDB(cfg *config.Config) {
 
 	if cfg.IfNeedOwnerDB {
 		if err = x.Sync2(new(Robot),
-			new(RobotGroupAdd)); err != nil {
+			new(RobotGroupAdd),
+			new(RobotGroupAddMember),
+			new(RobotGroupNameLock),
+			new(RobotGroupModRule),
+			new(RobotGroupStrike),
+			new(RobotGroupModLog),
+			new(RobotGroupWelcome),
+			new(RobotMessage),
+			new(RobotCampaign),
+			new(RobotCampaignTarget),
+			new(RobotInviteReport),
+			new(RobotDeadFriendReport),
+			new(RobotJob),
+			new(RobotFriendPolicy),
+			new(RobotFriendRequest),
+			new(RobotFriendCampaign),
+			new(RobotFriendCampaignTarget),
+			new(RobotContactLabel)); err != nil {
 			logrus.Fatalf("Fail to sync database: %v", err)
 		}
 	}
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// 群发任务
+type RobotCampaign struct {
+	ID          int64  `xorm:"pk autoincr" json:"id"`
+	RobotWx     string `xorm:"not null default '' varchar(128) index" json:"robotWx"`
+	Name        string `xorm:"not null default '' varchar(128)" json:"name"`
+	TargetType  string `xorm:"not null default '' varchar(16)" json:"targetType"` // group people
+	Filter      string `xorm:"not null default '' varchar(1024)" json:"filter"`   // 群名或好友备注的include()/notinclude()条件
+	Msgs        string `xorm:"not null default '' varchar(4096)" json:"msgs"`     // json, 依次发送的消息
+	IntervalMin int64  `xorm:"not null default 0 int" json:"intervalMin"`         // 两个对象之间的随机间隔, 秒
+	IntervalMax int64  `xorm:"not null default 0 int" json:"intervalMax"`
+	Status      string `xorm:"not null default '' varchar(16) index" json:"status"`
+	Total       int64  `xorm:"not null default 0 int" json:"total"`
+	Sent        int64  `xorm:"not null default 0 int" json:"sent"`
+	Failed      int64  `xorm:"not null default 0 int" json:"failed"`
+	CreatedAt   int64  `xorm:"not null default 0 int" json:"createdAt"`
+	UpdatedAt   int64  `xorm:"not null default 0 int" json:"updatedAt"`
+}
+
+// 群发对象, 群和好友的username每次登录都会变, 以群名或好友备注标识
+type RobotCampaignTarget struct {
+	ID         int64  `xorm:"pk autoincr" json:"id"`
+	CampaignID int64  `xorm:"not null default 0 int unique(campaign_target)" json:"campaignId"`
+	TargetName string `xorm:"not null default '' varchar(128) unique(campaign_target)" json:"targetName"`
+	Status     string `xorm:"not null default '' varchar(16)" json:"status"`
+	ErrMsg     string `xorm:"not null default '' varchar(256)" json:"errMsg,omitempty"`
+	SentAt     int64  `xorm:"not null default 0 int" json:"sentAt"`
+	CreatedAt  int64  `xorm:"not null default 0 int" json:"createdAt"`
+}
+
+func CreateRobotCampaign(info *RobotCampaign) error {
+	if info.RobotWx == "" {
+		return fmt.Errorf("wx robot campaign wx[%s] cannot be nil.", info.RobotWx)
+	}
+
+	now := time.Now().Unix()
+	info.CreatedAt = now
+	info.UpdatedAt = now
+
+	_, err := x.Insert(info)
+	if err != nil {
+		logrus.Errorf("create robot campaign error: %v", err)
+		return err
+	}
+	logrus.Infof("create robot[%s] campaign[%d][%s] success.", info.RobotWx, info.ID, info.Name)
+
+	return nil
+}
+
+func GetRobotCampaign(info *RobotCampaign) (bool, error) {
+	has, err := x.Where("id = ?", info.ID).Get(info)
+	if err != nil {
+		return false, err
+	}
+	if !has {
+		return false, nil
+	}
+	return true, nil
+}
+
+func GetRobotCampaignsByStatus(status string) ([]RobotCampaign, error) {
+	var list []RobotCampaign
+	err := x.Where("status = ?", status).Find(&list)
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func UpdateRobotCampaignStatus(info *RobotCampaign) error {
+	info.UpdatedAt = time.Now().Unix()
+	_, err := x.Cols("status", "updated_at").Update(info, &RobotCampaign{ID: info.ID})
+	return err
+}
+
+// UpdateRobotCampaignStatusFrom changes the status only when the campaign is
+// still in the from status, false if it is not.
+func UpdateRobotCampaignStatusFrom(info *RobotCampaign, from string) (bool, error) {
+	info.UpdatedAt = time.Now().Unix()
+	n, err := x.Cols("status", "updated_at").Where("id = ?", info.ID).And("status = ?", from).Update(info)
+	return n > 0, err
+}
+
+func UpdateRobotCampaignProgress(info *RobotCampaign) error {
+	info.UpdatedAt = time.Now().Unix()
+	_, err := x.Cols("sent", "failed", "updated_at").Update(info, &RobotCampaign{ID: info.ID})
+	return err
+}
+
+func CreateRobotCampaignTargets(list []RobotCampaignTarget) error {
+	if len(list) == 0 {
+		return nil
+	}
+	now := time.Now().Unix()
+	for i := range list {
+		list[i].CreatedAt = now
+	}
+
+	_, err := x.Insert(&list)
+	if err != nil {
+		logrus.Errorf("create robot campaign targets error: %v", err)
+		return err
+	}
+	return nil
+}
+
+// GetRobotCampaignTargets returns the targets in insert order, all status when status is empty.
+func GetRobotCampaignTargets(campaignID int64, status string) ([]RobotCampaignTarget, error) {
+	session := x.Where("campaign_id = ?", campaignID)
+	if status != "" {
+		session = session.And("status = ?", status)
+	}
+	var list []RobotCampaignTarget
+	err := session.Asc("id").Find(&list)
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func UpdateRobotCampaignTarget(info *RobotCampaignTarget) error {
+	_, err := x.Cols("status", "err_msg", "sent_at").Update(info, &RobotCampaignTarget{ID: info.ID})
+	return err
+}
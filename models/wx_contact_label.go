@@ -0,0 +1,63 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// 联系人标签, 按UserName匹配, 重新登录后UserName变化时按Alias, 再按昵称匹配
+type RobotContactLabel struct {
+	ID        int64  `xorm:"pk autoincr" json:"id"`
+	RobotWx   string `xorm:"not null default '' varchar(128) index" json:"robotWx"`
+	Label     string `xorm:"not null default '' varchar(64) index" json:"label"`
+	UserName  string `xorm:"not null default '' varchar(128)" json:"userName"` // 最近一次匹配到的username
+	Alias     string `xorm:"not null default '' varchar(128)" json:"alias"`
+	NickName  string `xorm:"not null default '' varchar(128)" json:"nickName"`
+	CreatedAt int64  `xorm:"not null default 0 int" json:"createdAt"`
+	UpdatedAt int64  `xorm:"not null default 0 int" json:"updatedAt"`
+}
+
+func CreateRobotContactLabel(info *RobotContactLabel) error {
+	if info.RobotWx == "" || info.Label == "" {
+		return fmt.Errorf("wx robot contact label wx[%s] label[%s] cannot be nil.", info.RobotWx, info.Label)
+	}
+
+	now := time.Now().Unix()
+	info.CreatedAt = now
+	info.UpdatedAt = now
+
+	_, err := x.Insert(info)
+	if err != nil {
+		logrus.Errorf("create robot contact label error: %v", err)
+		return err
+	}
+	logrus.Infof("create robot[%s] contact[%s] label[%s] success.", info.RobotWx, info.NickName, info.Label)
+
+	return nil
+}
+
+// GetRobotContactLabels returns the labels of the robot, all labels when label is empty.
+func GetRobotContactLabels(robotWx, label string) ([]RobotContactLabel, error) {
+	var list []RobotContactLabel
+	s := x.Where("robot_wx = ?", robotWx)
+	if label != "" {
+		s = s.And("label = ?", label)
+	}
+	err := s.Asc("id").Find(&list)
+	if err != nil {
+		logrus.Errorf("robot[%s] get contact labels error: %v", robotWx, err)
+		return nil, err
+	}
+	return list, nil
+}
+
+func DelRobotContactLabel(robotWx string, id int64) error {
+	_, err := x.Where("robot_wx = ?", robotWx).And("id = ?", id).Delete(&RobotContactLabel{})
+	if err != nil {
+		logrus.Errorf("robot[%s] del contact label[%d] error: %v", robotWx, id, err)
+		return err
+	}
+	return nil
+}
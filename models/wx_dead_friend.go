@@ -0,0 +1,58 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// 僵尸粉检测报告
+type RobotDeadFriendReport struct {
+	ID         int64  `xorm:"pk autoincr" json:"id"`
+	RobotWx    string `xorm:"not null default '' varchar(128) index" json:"robotWx"`
+	Status     string `xorm:"not null default '' varchar(16)" json:"status"`
+	Probed     int64  `xorm:"not null default 0 int" json:"probed"`
+	Skipped    int64  `xorm:"not null default 0 int" json:"skipped"`
+	DeadNum    int64  `xorm:"not null default 0 int" json:"deadNum"`
+	Dead       string `xorm:"mediumtext" json:"dead"` // json, username nickname remarkName detectedAt
+	DoneNames  string `xorm:"mediumtext" json:"-"`    // json, 已探测或跳过的好友备注名, 重新登录后接着检测
+	CreatedAt  int64  `xorm:"not null default 0 int" json:"createdAt"`
+	FinishedAt int64  `xorm:"not null default 0 int" json:"finishedAt"`
+}
+
+func CreateRobotDeadFriendReport(info *RobotDeadFriendReport) error {
+	if info.RobotWx == "" {
+		return fmt.Errorf("wx robot dead friend report wx[%s] cannot be nil.", info.RobotWx)
+	}
+
+	info.CreatedAt = time.Now().Unix()
+
+	_, err := x.Insert(info)
+	if err != nil {
+		logrus.Errorf("create robot dead friend report error: %v", err)
+		return err
+	}
+	return nil
+}
+
+func GetRobotDeadFriendReport(info *RobotDeadFriendReport) (bool, error) {
+	has, err := x.Where("id = ?", info.ID).Get(info)
+	if err != nil {
+		return false, err
+	}
+	if !has {
+		return false, nil
+	}
+	return true, nil
+}
+
+// GetLatestRobotDeadFriendReport gets the latest report of the robot in the status, false if none.
+func GetLatestRobotDeadFriendReport(robotWx, status string, info *RobotDeadFriendReport) (bool, error) {
+	return x.Where("robot_wx = ?", robotWx).And("status = ?", status).Desc("id").Get(info)
+}
+
+func UpdateRobotDeadFriendReport(info *RobotDeadFriendReport) error {
+	_, err := x.Cols("status", "probed", "skipped", "dead_num", "dead", "done_names", "finished_at").Update(info, &RobotDeadFriendReport{ID: info.ID})
+	return err
+}
@@ -0,0 +1,169 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// 批量加好友任务
+type RobotFriendCampaign struct {
+	ID          int64  `xorm:"pk autoincr" json:"id"`
+	RobotWx     string `xorm:"not null default '' varchar(128) index" json:"robotWx"`
+	Name        string `xorm:"not null default '' varchar(128)" json:"name"`
+	VerifyMsg   string `xorm:"not null default '' varchar(256)" json:"verifyMsg"` // 好友验证消息模板
+	DayLimit    int64  `xorm:"not null default 0 int" json:"dayLimit"`            // 机器人每天最多发送的好友请求, 0不限制
+	IntervalMin int64  `xorm:"not null default 0 int" json:"intervalMin"`         // 两个对象之间的随机间隔, 秒
+	IntervalMax int64  `xorm:"not null default 0 int" json:"intervalMax"`
+	Status      string `xorm:"not null default '' varchar(16) index" json:"status"`
+	Total       int64  `xorm:"not null default 0 int" json:"total"`
+	Sent        int64  `xorm:"not null default 0 int" json:"sent"`
+	Failed      int64  `xorm:"not null default 0 int" json:"failed"`
+	Accepted    int64  `xorm:"not null default 0 int" json:"accepted"`
+	CreatedAt   int64  `xorm:"not null default 0 int" json:"createdAt"`
+	UpdatedAt   int64  `xorm:"not null default 0 int" json:"updatedAt"`
+}
+
+// 加好友对象, username只在登录期间有效, 群成员发送前按群名和昵称重新查找,
+// 其他username对象记下创建时机器人的username, 机器人重新登录后失败
+type RobotFriendCampaignTarget struct {
+	ID            int64  `xorm:"pk autoincr" json:"id"`
+	CampaignID    int64  `xorm:"not null default 0 int index" json:"campaignId"`
+	RobotWx       string `xorm:"not null default '' varchar(128) index" json:"robotWx"`
+	UserName      string `xorm:"not null default '' varchar(128)" json:"userName"`
+	NickName      string `xorm:"not null default '' varchar(128)" json:"nickName"`
+	GroupName     string `xorm:"not null default '' varchar(128)" json:"groupName"`
+	LoginUserName string `xorm:"not null default '' varchar(128)" json:"loginUserName,omitempty"` // 创建时机器人的username, 每次登录都会变
+	Status        string `xorm:"not null default '' varchar(16) index" json:"status"`
+	ErrMsg        string `xorm:"not null default '' varchar(256)" json:"errMsg,omitempty"`
+	SentAt        int64  `xorm:"not null default 0 int index" json:"sentAt"`
+	AcceptedAt    int64  `xorm:"not null default 0 int" json:"acceptedAt"`
+	CreatedAt     int64  `xorm:"not null default 0 int" json:"createdAt"`
+}
+
+func CreateRobotFriendCampaign(info *RobotFriendCampaign) error {
+	if info.RobotWx == "" {
+		return fmt.Errorf("wx robot friend campaign wx[%s] cannot be nil.", info.RobotWx)
+	}
+
+	now := time.Now().Unix()
+	info.CreatedAt = now
+	info.UpdatedAt = now
+
+	_, err := x.Insert(info)
+	if err != nil {
+		logrus.Errorf("create robot friend campaign error: %v", err)
+		return err
+	}
+	logrus.Infof("create robot[%s] friend campaign[%d][%s] success.", info.RobotWx, info.ID, info.Name)
+
+	return nil
+}
+
+func GetRobotFriendCampaign(info *RobotFriendCampaign) (bool, error) {
+	has, err := x.Where("id = ?", info.ID).Get(info)
+	if err != nil {
+		return false, err
+	}
+	if !has {
+		return false, nil
+	}
+	return true, nil
+}
+
+func GetRobotFriendCampaignsByStatus(status string) ([]RobotFriendCampaign, error) {
+	var list []RobotFriendCampaign
+	err := x.Where("status = ?", status).Find(&list)
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func UpdateRobotFriendCampaignStatus(info *RobotFriendCampaign) error {
+	info.UpdatedAt = time.Now().Unix()
+	_, err := x.Cols("status", "updated_at").Update(info, &RobotFriendCampaign{ID: info.ID})
+	return err
+}
+
+// UpdateRobotFriendCampaignStatusFrom changes the status only when the
+// campaign is still in the from status, false if it is not.
+func UpdateRobotFriendCampaignStatusFrom(info *RobotFriendCampaign, from string) (bool, error) {
+	info.UpdatedAt = time.Now().Unix()
+	n, err := x.Cols("status", "updated_at").Where("id = ?", info.ID).And("status = ?", from).Update(info)
+	return n > 0, err
+}
+
+func UpdateRobotFriendCampaignProgress(info *RobotFriendCampaign) error {
+	info.UpdatedAt = time.Now().Unix()
+	_, err := x.Cols("sent", "failed", "updated_at").Update(info, &RobotFriendCampaign{ID: info.ID})
+	return err
+}
+
+// IncrRobotFriendCampaignAccepted adds one accepted, the campaign may be running in another goroutine.
+func IncrRobotFriendCampaignAccepted(id int64) error {
+	_, err := x.Where("id = ?", id).Incr("accepted").Update(&RobotFriendCampaign{})
+	return err
+}
+
+func CreateRobotFriendCampaignTargets(list []RobotFriendCampaignTarget) error {
+	if len(list) == 0 {
+		return nil
+	}
+	now := time.Now().Unix()
+	for i := range list {
+		list[i].CreatedAt = now
+	}
+
+	_, err := x.Insert(&list)
+	if err != nil {
+		logrus.Errorf("create robot friend campaign targets error: %v", err)
+		return err
+	}
+	return nil
+}
+
+// GetRobotFriendCampaignTargets returns the targets in insert order, all status when status is empty.
+func GetRobotFriendCampaignTargets(campaignID int64, status string) ([]RobotFriendCampaignTarget, error) {
+	session := x.Where("campaign_id = ?", campaignID)
+	if status != "" {
+		session = session.And("status = ?", status)
+	}
+	var list []RobotFriendCampaignTarget
+	err := session.Asc("id").Find(&list)
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// FindRobotFriendCampaignTarget finds the latest target of the robot in the
+// status by username, or by nickname when username is empty.
+func FindRobotFriendCampaignTarget(robotWx, status, userName, nickName string) (*RobotFriendCampaignTarget, error) {
+	session := x.Where("robot_wx = ?", robotWx).And("status = ?", status)
+	if userName != "" {
+		session = session.And("user_name = ?", userName)
+	} else {
+		session = session.And("nick_name = ?", nickName)
+	}
+	info := &RobotFriendCampaignTarget{}
+	has, err := session.Desc("id").Get(info)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, nil
+	}
+	return info, nil
+}
+
+func UpdateRobotFriendCampaignTarget(info *RobotFriendCampaignTarget) error {
+	_, err := x.Cols("user_name", "status", "err_msg", "sent_at", "accepted_at").Update(info, &RobotFriendCampaignTarget{ID: info.ID})
+	return err
+}
+
+// CountRobotFriendCampaignSent counts the friend requests sent by the robot since the time.
+func CountRobotFriendCampaignSent(robotWx string, since int64) (int64, error) {
+	return x.Where("robot_wx = ?", robotWx).And("sent_at >= ?", since).Count(&RobotFriendCampaignTarget{})
+}
@@ -0,0 +1,169 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// 好友请求通过策略, 按Priority从小到大匹配, 第一个匹配的策略决定处理方式
+type RobotFriendPolicy struct {
+	ID           int64  `xorm:"pk autoincr" json:"id"`
+	RobotWx      string `xorm:"not null default '' varchar(128) unique(robot_policy)" json:"robotWx"`
+	Name         string `xorm:"not null default '' varchar(128) unique(robot_policy)" json:"name"`
+	IfEnable     int64  `xorm:"not null default 0 int" json:"ifEnable"`
+	Priority     int64  `xorm:"not null default 0 int" json:"priority"`
+	SourceWechat string `xorm:"not null default '' varchar(256)" json:"sourceWechat"` // include()/notinclude()条件, 为空不限制
+	SourceNick   string `xorm:"not null default '' varchar(256)" json:"sourceNick"`
+	City         string `xorm:"not null default '' varchar(256)" json:"city"`
+	Content      string `xorm:"not null default '' varchar(256)" json:"content"` // 验证消息
+	Sex          int64  `xorm:"not null default 0 int" json:"sex"`               // 0不限制
+	Action       string `xorm:"not null default '' varchar(16)" json:"action"`   // accept reject defer
+	DayLimit     int64  `xorm:"not null default 0 int" json:"dayLimit"`          // 每天最多通过数, 超过后延后处理, 0不限制
+	DelayMin     int64  `xorm:"not null default 0 int" json:"delayMin"`          // 通过前的随机延时, 秒
+	DelayMax     int64  `xorm:"not null default 0 int" json:"delayMax"`
+	Greetings    string `xorm:"not null default '' varchar(4096)" json:"greetings"` // json, 通过后依次发送的消息
+	CreatedAt    int64  `xorm:"not null default 0 int" json:"createdAt"`
+	UpdatedAt    int64  `xorm:"not null default 0 int" json:"updatedAt"`
+}
+
+// 好友请求记录, 延后和拒绝的请求等待人工审核
+type RobotFriendRequest struct {
+	ID            int64  `xorm:"pk autoincr" json:"id"`
+	RobotWx       string `xorm:"not null default '' varchar(128) index" json:"robotWx"`
+	UserName      string `xorm:"not null default '' varchar(128)" json:"userName"` // 登录期间有效
+	UserNick      string `xorm:"not null default '' varchar(128)" json:"userNick"`
+	UserWechat    string `xorm:"not null default '' varchar(128)" json:"userWechat"`
+	SourceWechat  string `xorm:"not null default '' varchar(128)" json:"sourceWechat"`
+	SourceNick    string `xorm:"not null default '' varchar(128)" json:"sourceNick"`
+	City          string `xorm:"not null default '' varchar(64)" json:"city"`
+	Sex           int64  `xorm:"not null default 0 int" json:"sex"`
+	VerifyContent string `xorm:"not null default '' varchar(256)" json:"verifyContent"`
+	Ticket        string `xorm:"not null default '' varchar(256)" json:"-"`
+	PolicyName    string `xorm:"not null default '' varchar(128)" json:"policyName"`
+	Status        string `xorm:"not null default '' varchar(16) index" json:"status"`
+	Reason        string `xorm:"not null default '' varchar(256)" json:"reason"`
+	CreatedAt     int64  `xorm:"not null default 0 int index" json:"createdAt"`
+	UpdatedAt     int64  `xorm:"not null default 0 int" json:"updatedAt"`
+}
+
+func CreateRobotFriendPolicy(info *RobotFriendPolicy) error {
+	if info.RobotWx == "" || info.Name == "" {
+		return fmt.Errorf("wx robot friend policy wx[%s] name[%s] cannot be nil.", info.RobotWx, info.Name)
+	}
+
+	now := time.Now().Unix()
+	info.CreatedAt = now
+	info.UpdatedAt = now
+
+	_, err := x.Insert(info)
+	if err != nil {
+		logrus.Errorf("create robot friend policy error: %v", err)
+		return err
+	}
+	logrus.Infof("create robot[%s] friend policy[%s] success.", info.RobotWx, info.Name)
+
+	return nil
+}
+
+func GetRobotFriendPolicy(info *RobotFriendPolicy) (bool, error) {
+	has, err := x.Where("robot_wx = ?", info.RobotWx).And("name = ?", info.Name).Get(info)
+	if err != nil {
+		return false, err
+	}
+	if !has {
+		return false, nil
+	}
+	return true, nil
+}
+
+// GetRobotFriendPolicies returns the policies of the robot by priority.
+func GetRobotFriendPolicies(robotWx string) ([]RobotFriendPolicy, error) {
+	var list []RobotFriendPolicy
+	err := x.Where("robot_wx = ?", robotWx).Asc("priority").Asc("id").Find(&list)
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func UpdateRobotFriendPolicy(info *RobotFriendPolicy) error {
+	info.UpdatedAt = time.Now().Unix()
+	_, err := x.Cols("if_enable", "priority", "source_wechat", "source_nick", "city", "content", "sex",
+		"action", "day_limit", "delay_min", "delay_max", "greetings", "updated_at").Update(info, &RobotFriendPolicy{ID: info.ID})
+	return err
+}
+
+func DelRobotFriendPolicy(info *RobotFriendPolicy) error {
+	_, err := x.Where("robot_wx = ?", info.RobotWx).And("name = ?", info.Name).Delete(&RobotFriendPolicy{})
+	return err
+}
+
+func CreateRobotFriendRequest(info *RobotFriendRequest) error {
+	if info.RobotWx == "" {
+		return fmt.Errorf("wx robot friend request wx[%s] cannot be nil.", info.RobotWx)
+	}
+
+	now := time.Now().Unix()
+	info.CreatedAt = now
+	info.UpdatedAt = now
+
+	_, err := x.Insert(info)
+	if err != nil {
+		logrus.Errorf("create robot friend request error: %v", err)
+		return err
+	}
+	return nil
+}
+
+func UpdateRobotFriendRequestStatus(info *RobotFriendRequest) error {
+	info.UpdatedAt = time.Now().Unix()
+	_, err := x.Cols("status", "reason", "updated_at").Update(info, &RobotFriendRequest{ID: info.ID})
+	return err
+}
+
+// CountRobotFriendRequests counts the requests of the policy in the status since the time.
+func CountRobotFriendRequests(robotWx, policyName string, since int64, status ...string) (int64, error) {
+	return x.Where("robot_wx = ?", robotWx).And("policy_name = ?", policyName).And("created_at >= ?", since).In("status", status).Count(&RobotFriendRequest{})
+}
+
+func GetRobotFriendRequest(info *RobotFriendRequest) (bool, error) {
+	has, err := x.Where("id = ?", info.ID).Get(info)
+	if err != nil {
+		return false, err
+	}
+	if !has {
+		return false, nil
+	}
+	return true, nil
+}
+
+// GetRobotFriendRequests returns the requests newest first, all status when status is empty.
+func GetRobotFriendRequests(robotWx, status string, cursor int64, limit int) ([]RobotFriendRequest, error) {
+	session := x.Where("robot_wx = ?", robotWx)
+	if status != "" {
+		session = session.And("status = ?", status)
+	}
+	if cursor != 0 {
+		session = session.And("id < ?", cursor)
+	}
+	var list []RobotFriendRequest
+	err := session.Desc("id").Limit(limit).Find(&list)
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// UpdateRobotFriendRequestsStatus sets the status and reason of info to the
+// requests received before the time which are in one of the from status, of
+// all robots when robotWx is empty.
+func UpdateRobotFriendRequestsStatus(robotWx string, before int64, info *RobotFriendRequest, from ...string) (int64, error) {
+	info.UpdatedAt = time.Now().Unix()
+	session := x.Where("created_at < ?", before)
+	if robotWx != "" {
+		session = session.And("robot_wx = ?", robotWx)
+	}
+	return session.In("status", from).Cols("status", "reason", "updated_at").Update(info)
+}
@@ -7,11 +7,26 @@ import (
 	"github.com/Sirupsen/logrus"
 )
 
+// 从群里加好友的进度, 群username每次登录都会变, 以群名标识
 type RobotGroupAdd struct {
 	ID        int64  `xorm:"pk autoincr"`
-	RobotWx   string `xorm:"not null default '' varchar(128)"`
-	GroupName string `xorm:"not null default '' varchar(128)"`
+	RobotWx   string `xorm:"not null default '' varchar(128) index(robot_group)"`
+	GroupName string `xorm:"not null default '' varchar(128) index(robot_group)"`
+	Status    string `xorm:"not null default '' varchar(16)"` // adding done
+	AddedNum  int64  `xorm:"not null default 0 int"`
 	CreatedAt int64  `xorm:"not null default 0 int"`
+	UpdatedAt int64  `xorm:"not null default 0 int"`
+}
+
+// 已发过好友请求的群成员, 昵称只在群内区分, 以群名和昵称标识
+type RobotGroupAddMember struct {
+	ID         int64  `xorm:"pk autoincr"`
+	RobotWx    string `xorm:"not null default '' varchar(128) unique(robot_group_member)"`
+	GroupName  string `xorm:"not null default '' varchar(128) unique(robot_group_member)"`
+	MemberNick string `xorm:"not null default '' varchar(128) unique(robot_group_member)"`
+	Result     string `xorm:"not null default '' varchar(16)"` // ok fail
+	FailNum    int64  `xorm:"not null default 0 int"`
+	CreatedAt  int64  `xorm:"not null default 0 int"` // 最近一次发送的时间
 }
 
 func CreateRobotGroupAdd(info *RobotGroupAdd) error {
@@ -21,6 +36,7 @@ func CreateRobotGroupAdd(info *RobotGroupAdd) error {
 
 	now := time.Now().Unix()
 	info.CreatedAt = now
+	info.UpdatedAt = now
 
 	_, err := x.Insert(info)
 	if err != nil {
@@ -43,3 +59,54 @@ func GetRobotGroupAdd(info *RobotGroupAdd) (bool, error) {
 	}
 	return true, nil
 }
+
+func UpdateRobotGroupAdd(info *RobotGroupAdd) error {
+	info.UpdatedAt = time.Now().Unix()
+	_, err := x.Cols("status", "added_num", "updated_at").Update(info, &RobotGroupAdd{ID: info.ID})
+	return err
+}
+
+// SaveRobotGroupAddMember creates the member record or overwrites the one
+// of the same group and nick when it is sent again, failNum counts the fails.
+func SaveRobotGroupAddMember(info *RobotGroupAddMember, failed bool) error {
+	if info.RobotWx == "" {
+		return fmt.Errorf("wx robot group add member wx[%s] cannot be nil.", info.RobotWx)
+	}
+
+	info.CreatedAt = time.Now().Unix()
+
+	old := &RobotGroupAddMember{RobotWx: info.RobotWx, GroupName: info.GroupName, MemberNick: info.MemberNick}
+	has, err := GetRobotGroupAddMember(old)
+	if err != nil {
+		return err
+	}
+	info.FailNum = old.FailNum
+	if failed {
+		info.FailNum++
+	}
+	if has {
+		info.ID = old.ID
+		_, err = x.Cols("result", "fail_num", "created_at").Update(info, &RobotGroupAddMember{ID: info.ID})
+		return err
+	}
+	_, err = x.Insert(info)
+	if err != nil {
+		logrus.Errorf("create robot group add member error: %v", err)
+		return err
+	}
+	return nil
+}
+
+func CountRobotGroupAddMembers(robotWx string, since int64) (int64, error) {
+	return x.Where("robot_wx = ?", robotWx).And("created_at >= ?", since).Count(&RobotGroupAddMember{})
+}
+
+func GetRobotGroupAddMember(info *RobotGroupAddMember) (bool, error) {
+	return x.Where("robot_wx = ?", info.RobotWx).And("group_name = ?", info.GroupName).And("member_nick = ?", info.MemberNick).Get(info)
+}
+
+// CountRobotGroupAddMembersToRetry counts the members of the group in the
+// result which failed less than maxFail times.
+func CountRobotGroupAddMembersToRetry(robotWx, groupName, result string, maxFail int64) (int64, error) {
+	return x.Where("robot_wx = ?", robotWx).And("group_name = ?", groupName).And("result = ?", result).And("fail_num < ?", maxFail).Count(&RobotGroupAddMember{})
+}
@@ -0,0 +1,181 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// 群管规则, GroupName为空时作为该机器人所有群的默认规则
+type RobotGroupModRule struct {
+	ID             int64  `xorm:"pk autoincr" json:"id"`
+	RobotWx        string `xorm:"not null default '' varchar(128) unique(robot_group)" json:"robotWx"`
+	GroupName      string `xorm:"not null default '' varchar(128) unique(robot_group)" json:"groupName"`
+	Keywords       string `xorm:"not null default '' varchar(2048)" json:"keywords"` // 逗号分隔
+	IfBlockUrl     int64  `xorm:"not null default 0 int" json:"ifBlockUrl"`
+	AllowDomains   string `xorm:"not null default '' varchar(1024)" json:"allowDomains"` // 逗号分隔, 允许的链接域名
+	IfBlockCard    int64  `xorm:"not null default 0 int" json:"ifBlockCard"`
+	IfBlockMiniApp int64  `xorm:"not null default 0 int" json:"ifBlockMiniApp"`
+	FloodNum       int    `xorm:"not null default 0 int" json:"floodNum"` // FloodSeconds秒内超过FloodNum条算刷屏, 0不检查
+	FloodSeconds   int64  `xorm:"not null default 0 int" json:"floodSeconds"`
+	KickStrikes    int    `xorm:"not null default 0 int" json:"kickStrikes"`   // 违规达到次数踢人, 0只警告
+	StrikeSeconds  int64  `xorm:"not null default 0 int" json:"strikeSeconds"` // 超过该秒数没有违规时违规次数清零
+	WarnMsg        string `xorm:"not null default '' varchar(512)" json:"warnMsg"`
+	Whitelist      string `xorm:"not null default '' varchar(2048)" json:"whitelist"` // 逗号分隔, 管理员昵称
+	CreatedAt      int64  `xorm:"not null default 0 int" json:"createdAt"`
+	UpdatedAt      int64  `xorm:"not null default 0 int" json:"updatedAt"`
+}
+
+// 群成员违规次数, 群成员username每次登录都会变, 以昵称标识
+type RobotGroupStrike struct {
+	ID         int64  `xorm:"pk autoincr" json:"id"`
+	RobotWx    string `xorm:"not null default '' varchar(128) unique(robot_group_member)" json:"robotWx"`
+	GroupName  string `xorm:"not null default '' varchar(128) unique(robot_group_member)" json:"groupName"`
+	MemberNick string `xorm:"not null default '' varchar(128) unique(robot_group_member)" json:"memberNick"`
+	Strikes    int    `xorm:"not null default 0 int" json:"strikes"`
+	LastReason string `xorm:"not null default '' varchar(64)" json:"lastReason"`
+	CreatedAt  int64  `xorm:"not null default 0 int" json:"createdAt"`
+	UpdatedAt  int64  `xorm:"not null default 0 int" json:"updatedAt"`
+}
+
+// 群管处理记录
+type RobotGroupModLog struct {
+	ID             int64  `xorm:"pk autoincr" json:"id"`
+	RobotWx        string `xorm:"not null default '' varchar(128) index" json:"robotWx"`
+	GroupName      string `xorm:"not null default '' varchar(128)" json:"groupName"`
+	MemberNick     string `xorm:"not null default '' varchar(128)" json:"memberNick"`
+	MemberUserName string `xorm:"not null default '' varchar(128)" json:"memberUserName"`
+	Reason         string `xorm:"not null default '' varchar(64)" json:"reason"`
+	Action         string `xorm:"not null default '' varchar(32)" json:"action"`
+	Strikes        int    `xorm:"not null default 0 int" json:"strikes"`
+	Content        string `xorm:"not null default '' varchar(512)" json:"content"`
+	CreatedAt      int64  `xorm:"not null default 0 int index" json:"createdAt"`
+}
+
+func CreateRobotGroupModRule(info *RobotGroupModRule) error {
+	if info.RobotWx == "" {
+		return fmt.Errorf("wx robot group mod rule wx[%s] cannot be nil.", info.RobotWx)
+	}
+
+	now := time.Now().Unix()
+	info.CreatedAt = now
+	info.UpdatedAt = now
+
+	_, err := x.Insert(info)
+	if err != nil {
+		logrus.Errorf("create robot group mod rule error: %v", err)
+		return err
+	}
+	logrus.Infof("create robot[%s] group[%s] mod rule success.", info.RobotWx, info.GroupName)
+
+	return nil
+}
+
+func GetRobotGroupModRule(info *RobotGroupModRule) (bool, error) {
+	has, err := x.Where("robot_wx = ?", info.RobotWx).And("group_name = ?", info.GroupName).Get(info)
+	if err != nil {
+		return false, err
+	}
+	if !has {
+		return false, nil
+	}
+	return true, nil
+}
+
+func GetRobotGroupModRules(robotWx string) ([]RobotGroupModRule, error) {
+	var list []RobotGroupModRule
+	err := x.Where("robot_wx = ?", robotWx).Find(&list)
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func UpdateRobotGroupModRule(info *RobotGroupModRule) error {
+	info.UpdatedAt = time.Now().Unix()
+	_, err := x.Cols("keywords", "if_block_url", "allow_domains", "if_block_card", "if_block_mini_app", "flood_num",
+		"flood_seconds", "kick_strikes", "strike_seconds", "warn_msg", "whitelist", "updated_at").Update(info, &RobotGroupModRule{ID: info.ID})
+	return err
+}
+
+func DelRobotGroupModRule(robotWx, groupName string) error {
+	_, err := x.Where("robot_wx = ?", robotWx).And("group_name = ?", groupName).Delete(&RobotGroupModRule{})
+	return err
+}
+
+func GetRobotGroupStrike(info *RobotGroupStrike) (bool, error) {
+	has, err := x.Where("robot_wx = ?", info.RobotWx).And("group_name = ?", info.GroupName).And("member_nick = ?", info.MemberNick).Get(info)
+	if err != nil {
+		return false, err
+	}
+	if !has {
+		return false, nil
+	}
+	return true, nil
+}
+
+func CreateRobotGroupStrike(info *RobotGroupStrike) error {
+	if info.RobotWx == "" {
+		return fmt.Errorf("wx robot group strike wx[%s] cannot be nil.", info.RobotWx)
+	}
+
+	now := time.Now().Unix()
+	info.CreatedAt = now
+	info.UpdatedAt = now
+
+	_, err := x.Insert(info)
+	if err != nil {
+		logrus.Errorf("create robot group strike error: %v", err)
+		return err
+	}
+	return nil
+}
+
+func UpdateRobotGroupStrike(info *RobotGroupStrike) error {
+	info.UpdatedAt = time.Now().Unix()
+	_, err := x.Cols("strikes", "last_reason", "updated_at").Update(info, &RobotGroupStrike{ID: info.ID})
+	return err
+}
+
+func CreateRobotGroupModLog(info *RobotGroupModLog) error {
+	info.CreatedAt = time.Now().Unix()
+
+	_, err := x.Insert(info)
+	if err != nil {
+		logrus.Errorf("create robot group mod log error: %v", err)
+		return err
+	}
+	return nil
+}
+
+// GetRobotGroupStrikes returns the members with strikes, all groups when groupName is empty.
+func GetRobotGroupStrikes(robotWx, groupName string) ([]RobotGroupStrike, error) {
+	session := x.Where("robot_wx = ?", robotWx).And("strikes > 0")
+	if groupName != "" {
+		session = session.And("group_name = ?", groupName)
+	}
+	var list []RobotGroupStrike
+	err := session.Desc("updated_at").Find(&list)
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// GetRobotGroupModLogs returns the mod logs by id desc, cursor is the last id of the previous page.
+func GetRobotGroupModLogs(robotWx, groupName string, cursor int64, limit int) ([]RobotGroupModLog, error) {
+	session := x.Where("robot_wx = ?", robotWx)
+	if groupName != "" {
+		session = session.And("group_name = ?", groupName)
+	}
+	if cursor != 0 {
+		session = session.And("id < ?", cursor)
+	}
+	var list []RobotGroupModLog
+	err := session.Desc("id").Limit(limit).Find(&list)
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// 群名锁定策略, 以群名标识群(群username每次登录都会变)
+type RobotGroupNameLock struct {
+	ID        int64  `xorm:"pk autoincr"`
+	RobotWx   string `xorm:"not null default '' varchar(128) unique(robot_group)"`
+	GroupName string `xorm:"not null default '' varchar(128) unique(robot_group)"`
+	IfLock    int64  `xorm:"not null default 0 int"` // 0: 允许改群名 1: 群名锁定
+	CreatedAt int64  `xorm:"not null default 0 int"`
+	UpdatedAt int64  `xorm:"not null default 0 int"`
+}
+
+func CreateRobotGroupNameLock(info *RobotGroupNameLock) error {
+	if info.RobotWx == "" || info.GroupName == "" {
+		return fmt.Errorf("wx robot group name lock wx[%s] group[%s] cannot be nil.", info.RobotWx, info.GroupName)
+	}
+
+	now := time.Now().Unix()
+	info.CreatedAt = now
+	info.UpdatedAt = now
+
+	_, err := x.Insert(info)
+	if err != nil {
+		logrus.Errorf("create robot group name lock error: %v", err)
+		return err
+	}
+	logrus.Infof("create robot[%s] group[%s] name lock[%d] success.", info.RobotWx, info.GroupName, info.IfLock)
+
+	return nil
+}
+
+func GetRobotGroupNameLock(info *RobotGroupNameLock) (bool, error) {
+	has, err := x.Where("robot_wx = ?", info.RobotWx).And("group_name = ?", info.GroupName).Get(info)
+	if err != nil {
+		return false, err
+	}
+	if !has {
+		logrus.Debugf("cannot find robot group name lock from robot_wx[%s %s]", info.RobotWx, info.GroupName)
+		return false, nil
+	}
+	return true, nil
+}
+
+func DelRobotGroupNameLock(robotWx, groupName string) error {
+	_, err := x.Where("robot_wx = ?", robotWx).And("group_name = ?", groupName).Delete(&RobotGroupNameLock{})
+	if err != nil {
+		logrus.Errorf("robot[%s] del group[%s] name lock error: %v", robotWx, groupName, err)
+		return err
+	}
+	return nil
+}
+
+func UpdateRobotGroupNameLock(info *RobotGroupNameLock) error {
+	info.UpdatedAt = time.Now().Unix()
+	_, err := x.Cols("group_name", "if_lock", "updated_at").Update(info, &RobotGroupNameLock{ID: info.ID})
+	return err
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// 新人入群欢迎语配置, GroupName为空时作为该机器人所有群的默认配置
+type RobotGroupWelcome struct {
+	ID              int64  `xorm:"pk autoincr"`
+	RobotWx         string `xorm:"not null default '' varchar(128) unique(robot_group)"`
+	GroupName       string `xorm:"not null default '' varchar(128) unique(robot_group)"`
+	IfEnable        int64  `xorm:"not null default 0 int"`
+	Msgs            string `xorm:"not null default '' varchar(4096)"` // json, 依次发送的欢迎消息
+	BatchSeconds    int64  `xorm:"not null default 0 int"`            // 多少秒内入群的合并成一条欢迎
+	CooldownSeconds int64  `xorm:"not null default 0 int"`            // 两次欢迎的最小间隔
+	CreatedAt       int64  `xorm:"not null default 0 int"`
+	UpdatedAt       int64  `xorm:"not null default 0 int"`
+}
+
+func CreateRobotGroupWelcome(info *RobotGroupWelcome) error {
+	if info.RobotWx == "" {
+		return fmt.Errorf("wx robot group welcome wx[%s] cannot be nil.", info.RobotWx)
+	}
+
+	now := time.Now().Unix()
+	info.CreatedAt = now
+	info.UpdatedAt = now
+
+	_, err := x.Insert(info)
+	if err != nil {
+		logrus.Errorf("create robot group welcome error: %v", err)
+		return err
+	}
+	logrus.Infof("create robot[%s] group[%s] welcome success.", info.RobotWx, info.GroupName)
+
+	return nil
+}
+
+func GetRobotGroupWelcome(info *RobotGroupWelcome) (bool, error) {
+	has, err := x.Where("robot_wx = ?", info.RobotWx).And("group_name = ?", info.GroupName).Get(info)
+	if err != nil {
+		return false, err
+	}
+	if !has {
+		return false, nil
+	}
+	return true, nil
+}
+
+func UpdateRobotGroupWelcome(info *RobotGroupWelcome) error {
+	info.UpdatedAt = time.Now().Unix()
+	_, err := x.Cols("if_enable", "msgs", "batch_seconds", "cooldown_seconds", "updated_at").Update(info, &RobotGroupWelcome{ID: info.ID})
+	return err
+}
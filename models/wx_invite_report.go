@@ -0,0 +1,56 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// 好友拉群活动报告
+type RobotInviteReport struct {
+	ID         int64  `xorm:"pk autoincr" json:"id"`
+	RobotWx    string `xorm:"not null default '' varchar(128) index" json:"robotWx"`
+	Name       string `xorm:"not null default '' varchar(128)" json:"name"`
+	Conf       string `xorm:"not null default '' varchar(2048)" json:"conf"`   // json
+	Groups     string `xorm:"not null default '' varchar(4096)" json:"groups"` // json, 每个群的邀请人数
+	Status     string `xorm:"not null default '' varchar(16)" json:"status"`
+	Reason     string `xorm:"not null default '' varchar(256)" json:"reason,omitempty"`
+	Candidates int64  `xorm:"not null default 0 int" json:"candidates"`
+	Invited    int64  `xorm:"not null default 0 int" json:"invited"`
+	Failed     int64  `xorm:"not null default 0 int" json:"failed"`
+	Skipped    int64  `xorm:"not null default 0 int" json:"skipped"`
+	StartedAt  int64  `xorm:"not null default 0 int" json:"startedAt"`
+	FinishedAt int64  `xorm:"not null default 0 int" json:"finishedAt"`
+}
+
+func CreateRobotInviteReport(info *RobotInviteReport) error {
+	if info.RobotWx == "" {
+		return fmt.Errorf("wx robot invite report wx[%s] cannot be nil.", info.RobotWx)
+	}
+
+	info.StartedAt = time.Now().Unix()
+
+	_, err := x.Insert(info)
+	if err != nil {
+		logrus.Errorf("create robot invite report error: %v", err)
+		return err
+	}
+	return nil
+}
+
+func GetRobotInviteReport(info *RobotInviteReport) (bool, error) {
+	has, err := x.Where("id = ?", info.ID).Get(info)
+	if err != nil {
+		return false, err
+	}
+	if !has {
+		return false, nil
+	}
+	return true, nil
+}
+
+func UpdateRobotInviteReport(info *RobotInviteReport) error {
+	_, err := x.Cols("groups", "status", "reason", "candidates", "invited", "failed", "skipped", "finished_at").Update(info, &RobotInviteReport{ID: info.ID})
+	return err
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// 机器人后台任务, 每个机器人每种任务一条, 保存最近一次的状态
+type RobotJob struct {
+	ID         int64  `xorm:"pk autoincr" json:"id"`
+	RobotWx    string `xorm:"not null default '' varchar(128) unique(robot_job)" json:"robotWx"`
+	Name       string `xorm:"not null default '' varchar(64) unique(robot_job)" json:"name"`
+	Status     string `xorm:"not null default '' varchar(16) index" json:"status"`
+	Argv       string `xorm:"not null default '' varchar(4096)" json:"argv"` // json, 任务参数, 重启后按此恢复
+	Done       int64  `xorm:"not null default 0 int" json:"done"`
+	Total      int64  `xorm:"not null default 0 int" json:"total"`
+	Result     string `xorm:"text" json:"result"` // json
+	ErrMsg     string `xorm:"not null default '' varchar(256)" json:"errMsg"`
+	StartedAt  int64  `xorm:"not null default 0 int" json:"startedAt"`
+	FinishedAt int64  `xorm:"not null default 0 int" json:"finishedAt"`
+	UpdatedAt  int64  `xorm:"not null default 0 int" json:"updatedAt"`
+}
+
+// SaveRobotJob creates the job record or overwrites the one of the same robot and name.
+func SaveRobotJob(info *RobotJob) error {
+	if info.RobotWx == "" || info.Name == "" {
+		return fmt.Errorf("wx robot job wx[%s] name[%s] cannot be nil.", info.RobotWx, info.Name)
+	}
+
+	info.UpdatedAt = time.Now().Unix()
+
+	old := &RobotJob{}
+	has, err := x.Where("robot_wx = ?", info.RobotWx).And("name = ?", info.Name).Get(old)
+	if err != nil {
+		return err
+	}
+	if has {
+		info.ID = old.ID
+		_, err = x.Cols("status", "argv", "done", "total", "result", "err_msg", "started_at", "finished_at", "updated_at").Update(info, &RobotJob{ID: info.ID})
+		return err
+	}
+	_, err = x.Insert(info)
+	if err != nil {
+		logrus.Errorf("create robot job error: %v", err)
+		return err
+	}
+	return nil
+}
+
+func GetRobotJobsByStatus(robotWx string, status ...string) ([]RobotJob, error) {
+	var list []RobotJob
+	err := x.Where("robot_wx = ?", robotWx).In("status", status).Find(&list)
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
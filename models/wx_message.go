@@ -0,0 +1,99 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// 消息存档
+type RobotMessage struct {
+	ID             int64  `xorm:"pk autoincr" json:"id"`
+	RobotWx        string `xorm:"not null default '' varchar(128) index(robot_chat)" json:"robotWx"`
+	ChatType       string `xorm:"not null default '' varchar(16)" json:"chatType"` // people group
+	ChatUserName   string `xorm:"not null default '' varchar(128)" json:"chatUserName"`
+	ChatName       string `xorm:"not null default '' varchar(128) index(robot_chat)" json:"chatName"` // 群名或好友备注
+	Direction      string `xorm:"not null default '' varchar(8)" json:"direction"`                    // in out
+	SenderUserName string `xorm:"not null default '' varchar(128)" json:"senderUserName"`
+	SenderNick     string `xorm:"not null default '' varchar(128)" json:"senderNick"`
+	MsgType        string `xorm:"not null default '' varchar(16)" json:"msgType"`
+	Content        string `xorm:"text" json:"content"`
+	MediaRef       string `xorm:"not null default '' varchar(1024)" json:"mediaRef,omitempty"`
+	WxMsgId        string `xorm:"not null default '' varchar(64) index" json:"wxMsgId,omitempty"`
+	CreatedAt      int64  `xorm:"not null default 0 int index" json:"createdAt"`
+}
+
+type RobotMessageQuery struct {
+	RobotWx   string
+	ChatName  string
+	ChatType  string
+	Sender    string
+	StartTime int64
+	EndTime   int64
+	Keyword   string
+	Cursor    int64 // 上一页最后一条的ID, 0为第一页
+	Limit     int
+}
+
+func CreateRobotMessage(info *RobotMessage) error {
+	if info.CreatedAt == 0 {
+		info.CreatedAt = time.Now().Unix()
+	}
+
+	_, err := x.Insert(info)
+	if err != nil {
+		logrus.Errorf("create robot message error: %v", err)
+		return err
+	}
+	return nil
+}
+
+func HasRobotMessage(robotWx, wxMsgId string) (bool, error) {
+	return x.Where("robot_wx = ?", robotWx).And("wx_msg_id = ?", wxMsgId).Get(&RobotMessage{})
+}
+
+// likeEscaper escapes the like wildcards so the keyword matches literally.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// GetRobotMessages returns messages newest first.
+func GetRobotMessages(q *RobotMessageQuery) ([]RobotMessage, error) {
+	session := x.Where("robot_wx = ?", q.RobotWx)
+	if q.ChatName != "" {
+		session = session.And("chat_name = ?", q.ChatName)
+	}
+	if q.ChatType != "" {
+		session = session.And("chat_type = ?", q.ChatType)
+	}
+	if q.Sender != "" {
+		session = session.And("sender_nick = ?", q.Sender)
+	}
+	if q.StartTime != 0 {
+		session = session.And("created_at >= ?", q.StartTime)
+	}
+	if q.EndTime != 0 {
+		session = session.And("created_at < ?", q.EndTime)
+	}
+	if q.Keyword != "" {
+		session = session.And("content like ? escape '!'", "%"+likeEscaper.Replace(q.Keyword)+"%")
+	}
+	if q.Cursor != 0 {
+		session = session.And("id < ?", q.Cursor)
+	}
+	var list []RobotMessage
+	err := session.Desc("id").Limit(q.Limit).Find(&list)
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// DelRobotMessages deletes messages created before the time, all chat types
+// when chatType is empty.
+func DelRobotMessages(chatType string, before int64) (int64, error) {
+	session := x.Where("created_at < ?", before)
+	if chatType != "" {
+		session = session.And("chat_type = ?", chatType)
+	}
+	return session.Delete(&RobotMessage{})
+}
@@ -1,16 +1,70 @@
 package wxweb
 
 import (
+	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/reechou/wxrobot/models"
 )
 
+// 群加人配置, 不配置时用默认值
+type AddGroupMemberConf struct {
+	// 好友验证消息, {group}替换为群名
+	VerifyMsg string `json:"verifyMsg,omitempty"`
+	// 跳过每个群的前N个成员, 一般是群主和管理员
+	SkipFirst int `json:"skipFirst"`
+	// 昵称包含这些关键字的成员不加
+	SkipNicks []string `json:"skipNicks,omitempty"`
+	// 成员数不超过该值的群不加
+	MinGroupMember int `json:"minGroupMember"`
+	// 每天/每小时最多发送的好友请求, 0不限制
+	DayLimit  int64 `json:"dayLimit,omitempty"`
+	HourLimit int64 `json:"hourLimit,omitempty"`
+	// 群名包含的关键字, 按顺序优先处理
+	GroupPriority []string `json:"groupPriority,omitempty"`
+	// 工作时间[start, end)点, 都为0时不限制
+	WorkStartHour int `json:"workStartHour,omitempty"`
+	WorkEndHour   int `json:"workEndHour,omitempty"`
+	// 两次加人间隔, 秒
+	Interval int64 `json:"interval,omitempty"`
+}
+
+func defaultAddGroupMemberConf() *AddGroupMemberConf {
+	return &AddGroupMemberConf{
+		VerifyMsg:      ADD_GROUP_MEMBER_VERIFY_MSG,
+		SkipFirst:      ADD_GROUP_MEMBER_SKIP_FIRST,
+		MinGroupMember: ADD_GROUP_MEMBER_SKIP_FIRST,
+		Interval:       ADD_GROUP_MEMBER_INTERVAL,
+	}
+}
+
+type AddGroupMemberStatus struct {
+	Enable        bool               `json:"enable"`
+	Running       bool               `json:"running"`
+	Paused        bool               `json:"paused"`
+	Conf          AddGroupMemberConf `json:"conf"`
+	NowGroup      string             `json:"nowGroup"`
+	PendingGroups int                `json:"pendingGroups"`
+	DoneGroups    int                `json:"doneGroups"`
+	HourNum       int64              `json:"hourNum"`
+	DayNum        int64              `json:"dayNum"`
+	OkNum         int64              `json:"okNum"`
+	FailNum       int64              `json:"failNum"`
+	LastMember    string             `json:"lastMember,omitempty"`
+	LastAddTime   int64              `json:"lastAddTime,omitempty"`
+}
+
 type AddGroupMember struct {
 	sync.Mutex
 
+	// 配置可被http接口替换, 读写argv中的配置都要加锁
+	confMutex sync.Mutex
+
 	wx          *WxWeb
 	uc          *UserContact
 	hasAddedMap map[string]int
@@ -20,9 +74,16 @@ type AddGroupMember struct {
 	addMemberNum   int64
 	nowActiveGroup string
 	nowActiveIdx   int
+	nowGroupAdd    *models.RobotGroupAdd
 
-	stop chan struct{}
-	done chan struct{}
+	hourStart   int64
+	hourNum     int64
+	dayStart    int64
+	dayNum      int64
+	okNum       int64
+	failNum     int64
+	lastMember  string
+	lastAddTime int64
 }
 
 func NewAddGroupMember(uc *UserContact, wx *WxWeb) *AddGroupMember {
@@ -32,118 +93,338 @@ func NewAddGroupMember(uc *UserContact, wx *WxWeb) *AddGroupMember {
 		hasAddedMap:  make(map[string]int),
 		groupMap:     make(map[string]int),
 		addStartTime: time.Now().Unix(),
-		stop:         make(chan struct{}),
-		done:         make(chan struct{}),
-	}
-	if adm.wx.argv.IfSaveGroupMember {
-		go adm.run()
 	}
 
 	return adm
 }
 
-func (self *AddGroupMember) Stop() {
-	close(self.stop)
-	<-self.done
+func newAddGroupMemberJob(wx *WxWeb, argv json.RawMessage) (JobFunc, error) {
+	return wx.agml.run, nil
 }
 
-func (self *AddGroupMember) run() {
+func (self *AddGroupMember) conf() *AddGroupMemberConf {
+	self.confMutex.Lock()
+	conf := self.wx.argv.AddGroupMemberConf
+	if conf == nil {
+		self.confMutex.Unlock()
+		return defaultAddGroupMemberConf()
+	}
+	c := *conf
+	self.confMutex.Unlock()
+	if c.VerifyMsg == "" {
+		c.VerifyMsg = ADD_GROUP_MEMBER_VERIFY_MSG
+	}
+	if c.Interval <= 0 {
+		c.Interval = ADD_GROUP_MEMBER_INTERVAL
+	}
+	return &c
+}
+
+func (self *AddGroupMember) run(ctx *JobContext) error {
 	logrus.Debugf("add group member has start run.")
-	for {
-		select {
-		case <-time.After(time.Minute):
-			self.check()
-		case <-self.stop:
-			close(self.done)
-			return
-		}
+	self.Lock()
+	self.loadCount()
+	self.Unlock()
+	for ctx.Sleep(time.Duration(self.conf().Interval) * time.Second) {
+		self.check()
+		self.Lock()
+		done := self.okNum + self.failNum
+		self.Unlock()
+		ctx.SetProgress(done, 0)
 	}
+	return nil
 }
 
-func (self *AddGroupMember) check() {
-	if self.nowActiveGroup == "" {
-		self.changeGroup()
+// loadCount restores the hour and day counts after restart.
+func (self *AddGroupMember) loadCount() {
+	now := time.Now()
+	self.hourStart = now.Truncate(time.Hour).Unix()
+	self.dayStart = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).Unix()
+	if !self.ifSave() {
+		return
+	}
+	var err error
+	if self.hourNum, err = models.CountRobotGroupAddMembers(self.wx.Session.MyNickName, self.hourStart); err != nil {
+		logrus.Errorf("count robot group add members error: %v", err)
+	}
+	if self.dayNum, err = models.CountRobotGroupAddMembers(self.wx.Session.MyNickName, self.dayStart); err != nil {
+		logrus.Errorf("count robot group add members error: %v", err)
+	}
+}
+
+// ifLimited checks the working hours and the caps, resetting
+// the counts when a new hour or day begins.
+func (self *AddGroupMember) ifLimited(conf *AddGroupMemberConf) bool {
+	now := time.Now()
+	if conf.WorkStartHour != 0 || conf.WorkEndHour != 0 {
+		h := now.Hour()
+		if conf.WorkStartHour <= conf.WorkEndHour {
+			if h < conf.WorkStartHour || h >= conf.WorkEndHour {
+				return true
+			}
+		} else if h < conf.WorkStartHour && h >= conf.WorkEndHour {
+			// 跨天, 如22点到6点
+			return true
+		}
+	}
+
+	if hourStart := now.Truncate(time.Hour).Unix(); hourStart != self.hourStart {
+		self.hourStart = hourStart
+		self.hourNum = 0
+	}
+	if dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).Unix(); dayStart != self.dayStart {
+		self.dayStart = dayStart
+		self.dayNum = 0
+	}
+	if conf.HourLimit > 0 && self.hourNum >= conf.HourLimit {
+		return true
+	}
+	if conf.DayLimit > 0 && self.dayNum >= conf.DayLimit {
+		return true
 	}
 
-	now := time.Now().Unix()
-	if now-self.addStartTime < self.wx.argv.AddGroupMemberCycleOfTime {
+	if now.Unix()-self.addStartTime < self.wx.argv.AddGroupMemberCycleOfTime {
 		if self.addMemberNum >= self.wx.argv.AddGroupMemberCycleOfNum {
-			return
+			return true
 		}
 	} else {
-		self.addStartTime = now
+		self.addStartTime = now.Unix()
 		self.addMemberNum = 0
 	}
+	return false
+}
+
+func (self *AddGroupMember) check() {
+	conf := self.conf()
+
+	self.Lock()
+	if self.ifLimited(conf) {
+		self.Unlock()
+		return
+	}
+	if self.nowActiveGroup == "" {
+		self.changeGroup(conf)
+	}
 
 	var memberList []*GroupUserInfo
 	var ug *UserGroup
 	for {
 		if self.nowActiveGroup == "" {
+			self.Unlock()
 			logrus.Debugf("now has none active group can add memeber.")
 			return
 		}
 		ug = self.uc.FindGroup(self.nowActiveGroup, "")
 		if ug == nil {
 			logrus.Errorf("cannot found this group[%s]", self.nowActiveGroup)
-			self.changeGroup()
+			self.changeGroup(conf)
 			continue
 		}
 		memberList = ug.GetOriginalMemberList()
-		if len(memberList) <= 10 {
+		if len(memberList) <= conf.MinGroupMember {
 			logrus.Debugf("len group: %d is too little.", len(memberList))
-			self.changeGroup()
+			self.changeGroup(conf)
 			continue
 		}
 
+		for self.nowActiveIdx < len(memberList) && self.hasAdded(conf, ug.GetNickName(), memberList[self.nowActiveIdx]) {
+			self.nowActiveIdx++
+		}
 		if self.nowActiveIdx >= len(memberList) {
-			self.changeGroup()
+			self.finishGroup()
+			self.changeGroup(conf)
 			continue
 		}
 
 		break
 	}
+	member := memberList[self.nowActiveIdx]
+	self.nowActiveIdx++
+	self.Unlock()
 
-	verifyContent := fmt.Sprintf("我是[%s]的管理员", ug.NickName)
-	logrus.Debugf("[group member add] start to add member: %s in group: %s", memberList[self.nowActiveIdx].NickName, ug.NickName)
-	ok := self.wx.WebwxverifyuserAdd(WX_VERIFY_USER_OP_ADD, verifyContent, memberList[self.nowActiveIdx].UserName)
+	groupName := ug.GetNickName()
+	verifyContent := strings.Replace(conf.VerifyMsg, ADD_GROUP_MEMBER_GROUP, groupName, -1)
+	logrus.Debugf("[group member add] start to add member: %s in group: %s", member.NickName, groupName)
+	ok := self.wx.WebwxverifyuserAdd(WX_VERIFY_USER_OP_ADD, verifyContent, member.UserName)
 	if !ok {
 		logrus.Errorf("webwx verify user add is not ok.")
 	}
-	self.addMemberNum++
-	self.nowActiveIdx++
-
-	//for i := 10; i < len(memberList); i++ {
-	//	logrus.Debugf("start to add member: %s in group: %s", memberList[i].NickName, ug.NickName)
-	//	ok := self.wx.WebwxverifyuserAdd(WX_VERIFY_USER_OP_ADD, "", memberList[i].UserName)
-	//	if !ok {
-	//		logrus.Errorf("webwx verify user add is not ok.")
-	//	}
-	//	time.Sleep(time.Minute)
-	//}
-}
 
-func (self *AddGroupMember) changeGroup() {
 	self.Lock()
 	defer self.Unlock()
 
-	if len(self.groupMap) == 0 {
-		self.nowActiveGroup = ""
-		return
+	self.saveMember(groupName, member, ok)
+	self.addMemberNum++
+	self.hourNum++
+	self.dayNum++
+	if ok {
+		self.okNum++
+	} else {
+		self.failNum++
 	}
+	self.lastMember = member.NickName
+	self.lastAddTime = time.Now().Unix()
+}
 
-	for k, _ := range self.groupMap {
+// changeGroup picks the next group by the priority list, must hold the lock.
+func (self *AddGroupMember) changeGroup(conf *AddGroupMemberConf) {
+	self.nowActiveGroup = ""
+	self.nowGroupAdd = nil
+
+	type candidate struct {
+		userName string
+		nickName string
+		priority int
+	}
+	var list []candidate
+	for k := range self.groupMap {
 		if k == "" {
+			delete(self.groupMap, k)
+			continue
+		}
+		c := candidate{userName: k, priority: len(conf.GroupPriority)}
+		if ug := self.uc.FindGroup(k, ""); ug != nil {
+			c.nickName = ug.GetNickName()
+		}
+		for i, v := range conf.GroupPriority {
+			if v != "" && strings.Contains(c.nickName, v) {
+				c.priority = i
+				break
+			}
+		}
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].priority != list[j].priority {
+			return list[i].priority < list[j].priority
+		}
+		return list[i].nickName < list[j].nickName
+	})
+
+	for _, v := range list {
+		delete(self.groupMap, v.userName)
+		self.hasAddedMap[v.userName] = 1
+		groupAdd, done := self.loadGroupAdd(v.userName)
+		if done {
+			logrus.Debugf("[group member add] group: %s has added before, skip it.", groupAdd.GroupName)
 			continue
 		}
-		self.nowActiveGroup = k
+		self.nowActiveGroup = v.userName
+		self.nowGroupAdd = groupAdd
 		break
 	}
-	self.hasAddedMap[self.nowActiveGroup] = 1
-	delete(self.groupMap, self.nowActiveGroup)
-	self.nowActiveIdx = 10
+	self.nowActiveIdx = conf.SkipFirst
 	logrus.Debugf("[group member add] add group member change group to: %s", self.nowActiveGroup)
 }
 
+func (self *AddGroupMember) ifSave() bool {
+	return self.wx.cfg != nil && self.wx.cfg.IfNeedOwnerDB
+}
+
+// loadGroupAdd gets or creates the progress of the group, done reports that
+// all its members were processed before.
+func (self *AddGroupMember) loadGroupAdd(groupUserName string) (*models.RobotGroupAdd, bool) {
+	if !self.ifSave() {
+		return nil, false
+	}
+	ug := self.uc.FindGroup(groupUserName, "")
+	if ug == nil {
+		return nil, false
+	}
+	groupAdd := &models.RobotGroupAdd{
+		RobotWx:   self.wx.Session.MyNickName,
+		GroupName: ug.GetNickName(),
+	}
+	has, err := models.GetRobotGroupAdd(groupAdd)
+	if err != nil {
+		logrus.Errorf("get robot group add error: %v", err)
+		return nil, false
+	}
+	if has {
+		return groupAdd, groupAdd.Status == GROUP_ADD_STATUS_DONE
+	}
+	groupAdd.Status = GROUP_ADD_STATUS_ADDING
+	if err = models.CreateRobotGroupAdd(groupAdd); err != nil {
+		return nil, false
+	}
+	return groupAdd, false
+}
+
+func (self *AddGroupMember) finishGroup() {
+	if self.nowGroupAdd == nil {
+		return
+	}
+	// 有失败待重试的成员时不标记完成, 下次登录再处理该群
+	retry, err := models.CountRobotGroupAddMembersToRetry(self.nowGroupAdd.RobotWx, self.nowGroupAdd.GroupName, GROUP_ADD_RESULT_FAIL, GROUP_ADD_FAIL_MAX)
+	if err != nil {
+		logrus.Errorf("count robot group add members to retry error: %v", err)
+	} else if retry > 0 {
+		logrus.Infof("[group member add] group: %s has %d failed members to retry", self.nowGroupAdd.GroupName, retry)
+		return
+	}
+	self.nowGroupAdd.Status = GROUP_ADD_STATUS_DONE
+	if err := models.UpdateRobotGroupAdd(self.nowGroupAdd); err != nil {
+		logrus.Errorf("update robot group add error: %v", err)
+	}
+	logrus.Infof("[group member add] group: %s all members added, num: %d", self.nowGroupAdd.GroupName, self.nowGroupAdd.AddedNum)
+}
+
+// hasAdded skips the robot, its friends, the members matching the skip rules
+// and the members of the group sent before, failed ones are sent again after
+// GROUP_ADD_FAIL_RETRY seconds up to GROUP_ADD_FAIL_MAX times.
+func (self *AddGroupMember) hasAdded(conf *AddGroupMemberConf, groupName string, member *GroupUserInfo) bool {
+	if member.UserName == self.wx.Session.MyUserName {
+		return true
+	}
+	if self.uc.GetFriend(member.UserName) != nil {
+		return true
+	}
+	for _, v := range conf.SkipNicks {
+		if v != "" && strings.Contains(member.NickName, v) {
+			return true
+		}
+	}
+	if !self.ifSave() {
+		return false
+	}
+	info := &models.RobotGroupAddMember{
+		RobotWx:    self.wx.Session.MyNickName,
+		GroupName:  groupName,
+		MemberNick: member.NickName,
+	}
+	has, err := models.GetRobotGroupAddMember(info)
+	if err != nil {
+		logrus.Errorf("get robot group add member error: %v", err)
+		return false
+	}
+	if has && info.Result == GROUP_ADD_RESULT_FAIL && info.FailNum < GROUP_ADD_FAIL_MAX {
+		return time.Now().Unix()-info.CreatedAt < GROUP_ADD_FAIL_RETRY
+	}
+	return has
+}
+
+func (self *AddGroupMember) saveMember(groupName string, member *GroupUserInfo, ok bool) {
+	if !self.ifSave() {
+		return
+	}
+	result := GROUP_ADD_RESULT_OK
+	if !ok {
+		result = GROUP_ADD_RESULT_FAIL
+	}
+	models.SaveRobotGroupAddMember(&models.RobotGroupAddMember{
+		RobotWx:    self.wx.Session.MyNickName,
+		MemberNick: member.NickName,
+		GroupName:  groupName,
+		Result:     result,
+	}, !ok)
+	if self.nowGroupAdd != nil {
+		self.nowGroupAdd.AddedNum++
+		if err := models.UpdateRobotGroupAdd(self.nowGroupAdd); err != nil {
+			logrus.Errorf("update robot group add error: %v", err)
+		}
+	}
+}
+
 func (self *AddGroupMember) AddGroup(group string) {
 	self.Lock()
 	defer self.Unlock()
@@ -159,3 +440,70 @@ func (self *AddGroupMember) AddGroup(group string) {
 	self.groupMap[group] = 1
 	logrus.Debugf("[group member add] add new group: %s", group)
 }
+
+func (self *AddGroupMember) Status() *AddGroupMemberStatus {
+	conf := self.conf()
+	job, _ := self.wx.jobs.Get(JOB_ADD_GROUP_MEMBER)
+
+	self.Lock()
+	defer self.Unlock()
+
+	status := &AddGroupMemberStatus{
+		Enable:        self.wx.argv.IfSaveGroupMember,
+		Running:       job != nil && job.Status == JOB_STATUS_RUNNING,
+		Paused:        job != nil && job.Status == JOB_STATUS_PAUSED,
+		Conf:          *conf,
+		PendingGroups: len(self.groupMap),
+		DoneGroups:    len(self.hasAddedMap),
+		HourNum:       self.hourNum,
+		DayNum:        self.dayNum,
+		OkNum:         self.okNum,
+		FailNum:       self.failNum,
+		LastMember:    self.lastMember,
+		LastAddTime:   self.lastAddTime,
+	}
+	if ug := self.uc.FindGroup(self.nowActiveGroup, ""); ug != nil {
+		status.NowGroup = ug.GetNickName()
+	}
+	return status
+}
+
+func (self *WxWeb) AddGroupMemberStatus() *AddGroupMemberStatus {
+	return self.agml.Status()
+}
+
+// SetAddGroupMemberConf replaces the job config and saves it in the robot argv.
+func (self *WxWeb) SetAddGroupMemberConf(conf *AddGroupMemberConf) error {
+	if conf.WorkStartHour < 0 || conf.WorkStartHour > 24 || conf.WorkEndHour < 0 || conf.WorkEndHour > 24 {
+		return fmt.Errorf("work hour[%d-%d] must be in [0, 24]", conf.WorkStartHour, conf.WorkEndHour)
+	}
+	c := *conf
+	self.agml.confMutex.Lock()
+	self.argv.AddGroupMemberConf = &c
+	self.agml.confMutex.Unlock()
+	self.refreshRobotArgv()
+	logrus.Infof("[%s] set add group member conf: %+v", self.Session.MyNickName, *conf)
+	return nil
+}
+
+// EnableAddGroupMember turns the job on for the robot and starts or resumes it.
+func (self *WxWeb) EnableAddGroupMember() error {
+	if !self.argv.IfSaveGroupMember {
+		self.argv.IfSaveGroupMember = true
+		self.refreshRobotArgv()
+	}
+	if !self.IfLogin() {
+		return nil
+	}
+	if job, err := self.jobs.Get(JOB_ADD_GROUP_MEMBER); err == nil {
+		switch job.Status {
+		case JOB_STATUS_RUNNING:
+			return nil
+		case JOB_STATUS_PAUSED:
+			_, err = self.jobs.Resume(JOB_ADD_GROUP_MEMBER)
+			return err
+		}
+	}
+	_, err := self.jobs.Start(JOB_ADD_GROUP_MEMBER, nil)
+	return err
+}
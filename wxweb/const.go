@@ -13,10 +13,22 @@ const (
 	MSG_TYPE_SHARE_URL   = 49
 )
 
+// AppMsgType of MSG_TYPE_SHARE_URL
+const (
+	APP_MSG_TYPE_MINI_APP       = 33
+	APP_MSG_TYPE_MINI_APP_SHARE = 36
+)
+
 const (
 	WX_RET_SUCCESS = iota
 )
 
+// 操作频率限制的返回码
+const (
+	WX_RET_FREQ_LIMIT  = -34
+	WX_RET_OP_TOO_FREQ = 1205
+)
+
 const (
 	WEBWX_SYNC_INTERVAL            = 2
 	WEBWX_HANDLE_MSG_SYNC_INTERVAL = 1
@@ -24,6 +36,72 @@ const (
 
 const (
 	GROUP_PREFIX = "@@"
+	// 建群至少需要的好友数(不含自己)
+	CREATE_GROUP_MIN_MEMBER = 2
+	// 群人数达到该值后只能发邀请, 不能直接拉人
+	GROUP_INVITE_MEMBER_THRESHOLD = 40
+	GROUP_INVITE_BATCH_NUM        = 10
+	GROUP_INVITE_BATCH_INTERVAL   = 5
+	// 被限频后暂停拉人的时间(秒)
+	GROUP_INVITE_FREQ_LIMIT_COOLDOWN = 17 * 60
+)
+
+const (
+	GROUP_INVITE_MODE_ADD    = "add"
+	GROUP_INVITE_MODE_INVITE = "invite"
+
+	GROUP_INVITE_MSG_RATE_LIMITED = "rate limited"
+)
+
+// 好友拉群活动
+const (
+	INVITE_ORDER_DEFAULT   = "default"
+	INVITE_ORDER_GIRLFIRST = "girlfirst"
+	INVITE_ORDER_BOYFIRST  = "boyfirst"
+	INVITE_ORDER_RANDOM    = "random"
+
+	INVITE_CAMPAIGN_BATCH_SIZE       = 9
+	INVITE_CAMPAIGN_BATCH_INTERVAL   = 8
+	INVITE_CAMPAIGN_PAUSE_EVERY      = 200
+	INVITE_CAMPAIGN_PAUSE_SECONDS    = 120
+	INVITE_CAMPAIGN_MAX_GROUP_MEMBER = 500
+	INVITE_CAMPAIGN_MSG_INTERVAL     = 7
+	// 旧版默认拉进的群
+	INVITE_CAMPAIGN_DEFAULT_GROUP = "include()网购特卖"
+)
+
+// 从群里加好友
+const (
+	GROUP_ADD_STATUS_ADDING = "adding"
+	GROUP_ADD_STATUS_DONE   = "done"
+	GROUP_ADD_RESULT_OK     = "ok"
+	GROUP_ADD_RESULT_FAIL   = "fail"
+	GROUP_ADD_FAIL_RETRY    = 24 * 3600
+	GROUP_ADD_FAIL_MAX      = 3
+
+	ADD_GROUP_MEMBER_GROUP      = "{group}"
+	ADD_GROUP_MEMBER_VERIFY_MSG = "我是[{group}]的管理员"
+	ADD_GROUP_MEMBER_SKIP_FIRST = 10
+	ADD_GROUP_MEMBER_INTERVAL   = 60
+)
+
+// 群管
+const (
+	GROUP_MOD_RULE_RELOAD_INTERVAL = 60
+	GROUP_MOD_LOG_CONTENT_LEN      = 500
+	GROUP_MOD_WARN_MSG_DEFAULT     = "请勿在群内发广告, 多次违规将被移出群聊"
+	GROUP_MOD_FLOOD_SECONDS        = 10
+	GROUP_MOD_STRIKE_SECONDS       = 7 * 24 * 3600
+
+	GROUP_MOD_REASON_KEYWORD  = "keyword"
+	GROUP_MOD_REASON_URL      = "url"
+	GROUP_MOD_REASON_CARD     = "card"
+	GROUP_MOD_REASON_MINI_APP = "miniapp"
+	GROUP_MOD_REASON_FLOOD    = "flood"
+
+	GROUP_MOD_ACTION_WARN      = "warn"
+	GROUP_MOD_ACTION_KICK      = "kick"
+	GROUP_MOD_ACTION_KICK_FAIL = "kickfail"
 )
 
 const (
@@ -40,7 +118,23 @@ const (
 	RECEIVE_EVENT_MOD_GROUP_ADD_DETAIL = "modgroupadddetail"
 	RECEIVE_EVENT_ADD_FRIEND           = "addfriend"
 	RECEIVE_EVENT_ADD                  = "receiveadd"
+	RECEIVE_EVENT_FRIEND_UPDATE        = "friendupdate"
 	RECEIVE_EVENT_ADD_GROUP            = "addgroup"
+	RECEIVE_EVENT_GROUP_NAME_REVERT    = "groupnamerevert"
+)
+
+const (
+	FRIEND_FIELD_NICKNAME  = "nickName"
+	FRIEND_FIELD_REMARK    = "remarkName"
+	FRIEND_FIELD_ALIAS     = "alias"
+	FRIEND_FIELD_CITY      = "city"
+	FRIEND_FIELD_SEX       = "sex"
+	FRIEND_FIELD_SIGNATURE = "signature"
+)
+
+const (
+	MSG_DIRECTION_IN  = "in"
+	MSG_DIRECTION_OUT = "out"
 )
 
 const (
@@ -50,6 +144,7 @@ const (
 	RECEIVE_MSG_TYPE_VIDEO = "video"
 	RECEIVE_MSG_TYPE_CARD  = "card"
 	RECEIVE_MSG_TYPE_SHARE = "shareurl"
+	RECEIVE_MSG_TYPE_LINK  = "link"
 )
 
 type msgUrlHandle func(string) string
@@ -90,3 +185,40 @@ const (
 	WX_FRIEND_VERIFY_FLAG_DINGYUEHAO = 8
 	WX_FRIEND_VERIFY_FLAG_FUWUHAO    = 24
 )
+
+// 僵尸粉检测
+const (
+	DEAD_FRIEND_PROBE_MSG     = "[握手]"
+	DEAD_FRIEND_SCAN_INTERVAL = 5
+	DEAD_FRIEND_SKIP_ACTIVE   = 3 * 24 * 3600
+	DEAD_FRIEND_SCAN_WAIT     = 60
+	DEAD_FRIEND_SAVE_NUM      = 10
+	CLEAR_WX_INTERVAL         = 3
+
+	DEAD_FRIEND_ACTION_TAG        = "tag"
+	DEAD_FRIEND_ACTION_DEL_REMARK = "delremark"
+
+	DEAD_FRIEND_STATUS_RUNNING  = "running"
+	DEAD_FRIEND_STATUS_FINISHED = "finished"
+	DEAD_FRIEND_STATUS_STOPPED  = "stopped"
+)
+
+// 后台任务
+const (
+	JOB_INVITE_MEMBERS     = "invitemembers"
+	JOB_DEAD_FRIEND_SCAN   = "deadfriendscan"
+	JOB_SAVE_ROBOT_FRIENDS = "saverobotfriends"
+	JOB_SAVE_ROBOT_GROUPS  = "saverobotgroups"
+	JOB_CREATE_GROUPS      = "creategroups"
+	JOB_ADD_GROUP_MEMBER   = "addgroupmember"
+
+	JOB_STATUS_RUNNING   = "running"
+	JOB_STATUS_PAUSED    = "paused"
+	JOB_STATUS_CANCELLED = "cancelled"
+	JOB_STATUS_FINISHED  = "finished"
+	JOB_STATUS_FAILED    = "failed"
+	JOB_STATUS_STOPPED   = "stopped"
+
+	JOB_SAVE_INTERVAL = 10
+	JOB_SAVE_BATCH    = 20
+)
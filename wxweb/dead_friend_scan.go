@@ -0,0 +1,407 @@
+package wxweb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/reechou/wxrobot/models"
+)
+
+// 僵尸粉检测配置
+type DeadFriendScanConf struct {
+	// 探测消息, 为空用ClearWxMsg, 再为空用默认
+	ProbeMsg string `json:"probeMsg,omitempty"`
+	// 两次探测的间隔秒数
+	Interval int64 `json:"interval,omitempty"`
+	// 最近这么多秒内给机器人发过消息的好友不探测
+	SkipActiveSeconds int64 `json:"skipActiveSeconds,omitempty"`
+	// 发完后继续等待系统提示的秒数
+	WaitSeconds int64 `json:"waitSeconds,omitempty"`
+	// 检测到后的处理: tag备注加前缀 delremark删除备注, 为空不处理
+	Action       string `json:"action,omitempty"`
+	RemarkPrefix string `json:"remarkPrefix,omitempty"`
+	// 结束后POST报告的地址
+	Callback string `json:"callback,omitempty"`
+}
+
+type DeadFriend struct {
+	UserName   string `json:"username"`
+	NickName   string `json:"nickname"`
+	RemarkName string `json:"remarkName"`
+	DetectedAt int64  `json:"detectedAt"`
+}
+
+type DeadFriendReport struct {
+	ID         int64        `json:"id"`
+	RobotWx    string       `json:"robotWx"`
+	Status     string       `json:"status"`
+	Probed     int          `json:"probed"`
+	Skipped    int          `json:"skipped"`
+	Dead       []DeadFriend `json:"dead"`
+	StartedAt  int64        `json:"startedAt"`
+	FinishedAt int64        `json:"finishedAt,omitempty"`
+}
+
+// DeadFriendScan sends a probe message to every friend and collects the ones
+// answered by the system tip of friend verification, which means the friend
+// has deleted the robot.
+type DeadFriendScan struct {
+	sync.Mutex
+
+	wx *WxWeb
+
+	conf       *DeadFriendScanConf
+	report     *DeadFriendReport
+	dead       map[string]bool
+	done       map[string]bool // 已探测或跳过的好友, 以备注名标识
+	lastActive map[string]int64
+}
+
+func NewDeadFriendScan(wx *WxWeb) *DeadFriendScan {
+	return &DeadFriendScan{
+		wx:         wx,
+		lastActive: make(map[string]int64),
+	}
+}
+
+func (self DeadFriendScanConf) withDefault(wx *WxWeb) *DeadFriendScanConf {
+	if self.ProbeMsg == "" {
+		self.ProbeMsg = wx.argv.ClearWxMsg
+	}
+	if self.ProbeMsg == "" {
+		self.ProbeMsg = DEAD_FRIEND_PROBE_MSG
+	}
+	if self.Interval <= 0 {
+		self.Interval = DEAD_FRIEND_SCAN_INTERVAL
+	}
+	if self.SkipActiveSeconds <= 0 {
+		self.SkipActiveSeconds = DEAD_FRIEND_SKIP_ACTIVE
+	}
+	if self.WaitSeconds <= 0 {
+		self.WaitSeconds = DEAD_FRIEND_SCAN_WAIT
+	}
+	if self.Action == DEAD_FRIEND_ACTION_TAG && self.RemarkPrefix == "" {
+		self.RemarkPrefix = wx.argv.ClearWxPrefix
+		if self.RemarkPrefix == "" {
+			self.RemarkPrefix = CLEAR_WX_PREFIX_DEFAULT
+		}
+	}
+	return &self
+}
+
+func (self *DeadFriendScan) ifSave() bool {
+	return self.wx.cfg != nil && self.wx.cfg.IfNeedOwnerDB
+}
+
+func newDeadFriendScanJob(wx *WxWeb, argv json.RawMessage) (JobFunc, error) {
+	conf := &DeadFriendScanConf{}
+	if len(argv) == 0 {
+		// 旧的IfClearWx逻辑
+		conf.Action = DEAD_FRIEND_ACTION_TAG
+		conf.Interval = CLEAR_WX_INTERVAL
+	} else if err := decodeJobArgv(argv, conf); err != nil {
+		return nil, err
+	}
+	if conf.Action != "" && conf.Action != DEAD_FRIEND_ACTION_TAG && conf.Action != DEAD_FRIEND_ACTION_DEL_REMARK {
+		return nil, fmt.Errorf("unknown dead friend action[%s]", conf.Action)
+	}
+	conf = conf.withDefault(wx)
+	if err := wx.dfs.begin(conf); err != nil {
+		return nil, err
+	}
+	return func(ctx *JobContext) error {
+		wx.dfs.run(ctx, conf)
+		return nil
+	}, nil
+}
+
+// begin resets the report for a new scan, or resumes the scan left running
+// by the last login, the job manager runs one scan at a time.
+func (self *DeadFriendScan) begin(conf *DeadFriendScanConf) error {
+	self.Lock()
+	defer self.Unlock()
+
+	report := &DeadFriendReport{
+		RobotWx:   self.wx.Session.MyNickName,
+		Status:    DEAD_FRIEND_STATUS_RUNNING,
+		StartedAt: time.Now().Unix(),
+	}
+	done := make(map[string]bool)
+	if self.ifSave() {
+		info := &models.RobotDeadFriendReport{}
+		has, err := models.GetLatestRobotDeadFriendReport(report.RobotWx, DEAD_FRIEND_STATUS_RUNNING, info)
+		if err != nil {
+			return err
+		}
+		if has {
+			// 已探测过的好友不再发探测消息
+			report.ID = info.ID
+			report.Probed = int(info.Probed)
+			report.Skipped = int(info.Skipped)
+			report.StartedAt = info.CreatedAt
+			if info.Dead != "" {
+				json.Unmarshal([]byte(info.Dead), &report.Dead)
+			}
+			var names []string
+			if info.DoneNames != "" {
+				json.Unmarshal([]byte(info.DoneNames), &names)
+			}
+			for _, v := range names {
+				done[v] = true
+			}
+			logrus.Infof("wx[%s] resume dead friend scan[%d], %d friends done.", report.RobotWx, report.ID, len(done))
+		} else {
+			info = &models.RobotDeadFriendReport{
+				RobotWx: report.RobotWx,
+				Status:  report.Status,
+			}
+			if err = models.CreateRobotDeadFriendReport(info); err != nil {
+				return err
+			}
+			report.ID = info.ID
+		}
+	}
+	self.conf = conf
+	self.report = report
+	self.dead = make(map[string]bool)
+	self.done = done
+	return nil
+}
+
+// Report returns a copy of the latest report, nil if never scanned.
+func (self *DeadFriendScan) Report() *DeadFriendReport {
+	if self.report == nil {
+		return nil
+	}
+	report := *self.report
+	report.Dead = append([]DeadFriend(nil), self.report.Dead...)
+	return &report
+}
+
+func (self *DeadFriendScan) GetReport() *DeadFriendReport {
+	self.Lock()
+	defer self.Unlock()
+
+	return self.Report()
+}
+
+func (self *DeadFriendScan) markActive(username string) {
+	self.Lock()
+	defer self.Unlock()
+
+	self.lastActive[username] = time.Now().Unix()
+}
+
+func (self *DeadFriendScan) run(ctx *JobContext, conf *DeadFriendScanConf) {
+	logrus.Infof("wx[%s] dead friend scan start.", self.wx.Session.MyNickName)
+	var friends []UserFriend
+	for _, v := range self.wx.Contact.FriendsSnapshot() {
+		if _, ok := self.wx.SpecialUsers[v.UserName]; ok {
+			continue
+		}
+		if v.VerifyFlag != WX_FRIEND_VERIFY_FLAG_USER {
+			continue
+		}
+		friends = append(friends, v)
+	}
+	now := time.Now().Unix()
+	for i, v := range friends {
+		if !ctx.Wait() {
+			if !self.interrupted(ctx) {
+				self.finish(DEAD_FRIEND_STATUS_STOPPED)
+			}
+			return
+		}
+		name := v.RemarkName
+		if name == "" {
+			name = v.NickName
+		}
+		self.Lock()
+		done := self.done[name]
+		active := !done && now-self.lastActive[v.UserName] < conf.SkipActiveSeconds
+		if active {
+			self.report.Skipped++
+			self.done[name] = true
+		}
+		self.Unlock()
+		if done || active {
+			continue
+		}
+
+		self.wx.Webwxsendmsg(conf.ProbeMsg, v.UserName)
+		self.Lock()
+		self.report.Probed++
+		self.done[name] = true
+		probed := self.report.Probed
+		self.Unlock()
+		ctx.SetProgress(int64(i+1), int64(len(friends)))
+		if probed%DEAD_FRIEND_SAVE_NUM == 0 {
+			self.save()
+		}
+		if !ctx.Sleep(time.Duration(conf.Interval) * time.Second) {
+			if !self.interrupted(ctx) {
+				self.finish(DEAD_FRIEND_STATUS_STOPPED)
+			}
+			return
+		}
+	}
+	ctx.SetProgress(int64(len(friends)), int64(len(friends)))
+	status := DEAD_FRIEND_STATUS_FINISHED
+	if !ctx.Sleep(time.Duration(conf.WaitSeconds) * time.Second) {
+		if self.interrupted(ctx) {
+			return
+		}
+		status = DEAD_FRIEND_STATUS_STOPPED
+	}
+	self.finish(status)
+	ctx.SetResult(self.GetReport())
+}
+
+// interrupted saves the progress when the scan is stopped by logout, the
+// report is kept running so the next login goes on with the friends left.
+func (self *DeadFriendScan) interrupted(ctx *JobContext) bool {
+	if !ctx.Stopped() {
+		return false
+	}
+	self.save()
+	logrus.Infof("wx[%s] dead friend scan stopped by logout.", self.wx.Session.MyNickName)
+	return true
+}
+
+// markDead records a friend answered by the friend verification tip and
+// applies the action. Tips outside a scan only run the legacy IfClearWx rename.
+func (self *DeadFriendScan) markDead(username string) {
+	self.Lock()
+	scanning := self.report != nil && self.report.Status == DEAD_FRIEND_STATUS_RUNNING
+	if scanning && self.dead[username] {
+		self.Unlock()
+		return
+	}
+	var conf *DeadFriendScanConf
+	if scanning {
+		conf = self.conf
+		self.dead[username] = true
+	} else if self.wx.argv.IfClearWx {
+		conf = (&DeadFriendScanConf{Action: DEAD_FRIEND_ACTION_TAG}).withDefault(self.wx)
+	}
+	self.Unlock()
+	if conf == nil {
+		return
+	}
+
+	dead := DeadFriend{UserName: username, DetectedAt: time.Now().Unix()}
+	if uf := self.wx.Contact.GetFriend(username); uf != nil {
+		dead.NickName = uf.NickName
+		dead.RemarkName = uf.RemarkName
+	}
+	logrus.Infof("wx[%s] found dead friend[%s][%s]", self.wx.Session.MyNickName, dead.NickName, dead.RemarkName)
+
+	var remark string
+	switch conf.Action {
+	case DEAD_FRIEND_ACTION_TAG:
+		remark = fmt.Sprintf("%s %s", conf.RemarkPrefix, dead.NickName)
+	case DEAD_FRIEND_ACTION_DEL_REMARK:
+		remark = ""
+	}
+	if conf.Action != "" && self.wx.WebwxOplog(username, remark) {
+		self.wx.Contact.ChangeFriend(username, remark)
+	}
+
+	if scanning {
+		self.Lock()
+		self.report.Dead = append(self.report.Dead, dead)
+		// 改了备注后接着检测时按新备注也不再探测
+		self.done[remark] = true
+		self.done[dead.NickName] = true
+		self.Unlock()
+	}
+}
+
+func (self *DeadFriendScan) finish(status string) {
+	self.Lock()
+	self.report.Status = status
+	self.report.FinishedAt = time.Now().Unix()
+	report := self.Report()
+	conf := self.conf
+	self.Unlock()
+
+	logrus.Infof("wx[%s] dead friend scan %s, probed[%d] skipped[%d] dead[%d]",
+		report.RobotWx, report.Status, report.Probed, report.Skipped, len(report.Dead))
+	self.save()
+	if conf.Callback != "" {
+		self.callback(conf.Callback, report)
+	}
+}
+
+// save writes the report and the friends done to db.
+func (self *DeadFriendScan) save() {
+	if !self.ifSave() {
+		return
+	}
+	self.Lock()
+	report := self.Report()
+	names := make([]string, 0, len(self.done))
+	for k := range self.done {
+		names = append(names, k)
+	}
+	self.Unlock()
+
+	dead, _ := json.Marshal(report.Dead)
+	doneNames, _ := json.Marshal(names)
+	info := &models.RobotDeadFriendReport{
+		ID:         report.ID,
+		Status:     report.Status,
+		Probed:     int64(report.Probed),
+		Skipped:    int64(report.Skipped),
+		DeadNum:    int64(len(report.Dead)),
+		Dead:       string(dead),
+		DoneNames:  string(doneNames),
+		FinishedAt: report.FinishedAt,
+	}
+	if err := models.UpdateRobotDeadFriendReport(info); err != nil {
+		logrus.Errorf("update robot dead friend report error: %v", err)
+	}
+}
+
+func (self *DeadFriendScan) callback(url string, report *DeadFriendReport) {
+	reqBytes, err := json.Marshal(report)
+	if err != nil {
+		logrus.Errorf("dead friend report json encode error: %v", err)
+		return
+	}
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Post(url, "application/json", bytes.NewBuffer(reqBytes))
+	if err != nil {
+		logrus.Errorf("dead friend report callback[%s] error: %v", url, err)
+		return
+	}
+	resp.Body.Close()
+}
+
+func (self *WxWeb) StartDeadFriendScan(conf *DeadFriendScanConf) (*DeadFriendReport, error) {
+	if conf == nil {
+		conf = &DeadFriendScanConf{}
+	}
+	argv, err := json.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = self.jobs.Start(JOB_DEAD_FRIEND_SCAN, argv); err != nil {
+		return nil, err
+	}
+	return self.dfs.GetReport(), nil
+}
+
+func (self *WxWeb) StopDeadFriendScan() error {
+	_, err := self.jobs.Cancel(JOB_DEAD_FRIEND_SCAN)
+	return err
+}
+
+func (self *WxWeb) DeadFriendReport() *DeadFriendReport {
+	return self.dfs.GetReport()
+}
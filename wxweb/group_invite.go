@@ -0,0 +1,196 @@
+package wxweb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+type GroupInviteResult struct {
+	UserName string `json:"username"`
+	NickName string `json:"nickname"`
+	Mode     string `json:"mode,omitempty"`
+	Ok       bool   `json:"ok"`
+	Msg      string `json:"msg,omitempty"`
+}
+
+// InviteGroupMembers adds friends into the group. Small groups get the members
+// directly, groups over GROUP_INVITE_MEMBER_THRESHOLD get invitation cards.
+// A rate limit response stops the remaining batches and pauses inviting for
+// GROUP_INVITE_FREQ_LIMIT_COOLDOWN seconds.
+func (self *UserContact) InviteGroupMembers(ug *UserGroup, friends []*UserFriend) ([]GroupInviteResult, error) {
+	self.inviteMutex.Lock()
+	defer self.inviteMutex.Unlock()
+
+	now := time.Now().Unix()
+	if now < self.inviteLimitUntil {
+		return nil, fmt.Errorf("invite member is rate limited, retry after %d seconds", self.inviteLimitUntil-now)
+	}
+
+	mode := GROUP_INVITE_MODE_ADD
+	if ug.GetGroupMemberLen() >= GROUP_INVITE_MEMBER_THRESHOLD {
+		mode = GROUP_INVITE_MODE_INVITE
+	}
+
+	results := make([]GroupInviteResult, len(friends))
+	var pending []int
+	for i, v := range friends {
+		results[i] = GroupInviteResult{UserName: v.UserName, NickName: v.NickName, Mode: mode}
+		if ug.GetMemberFromList(v.UserName) != nil {
+			results[i].Mode = ""
+			results[i].Ok = true
+			results[i].Msg = "already in group"
+			continue
+		}
+		pending = append(pending, i)
+	}
+
+	var limitErr error
+	for start := 0; start < len(pending); start += GROUP_INVITE_BATCH_NUM {
+		end := start + GROUP_INVITE_BATCH_NUM
+		if end > len(pending) {
+			end = len(pending)
+		}
+		batch := pending[start:end]
+		if limitErr != nil {
+			for _, idx := range batch {
+				results[idx].Msg = GROUP_INVITE_MSG_RATE_LIMITED
+			}
+			continue
+		}
+		if start > 0 {
+			time.Sleep(GROUP_INVITE_BATCH_INTERVAL * time.Second)
+		}
+		if self.inviteBatch(ug, mode, batch, results) {
+			limitErr = fmt.Errorf("invite member is rate limited")
+			self.inviteLimitUntil = time.Now().Unix() + GROUP_INVITE_FREQ_LIMIT_COOLDOWN
+			logrus.Errorf("wx[%s] invite member into group[%s] rate limited, pause %d seconds",
+				self.wx.Session.MyNickName, ug.GetNickName(), GROUP_INVITE_FREQ_LIMIT_COOLDOWN)
+		}
+	}
+
+	if len(pending) != 0 && !self.wx.RefreshGroup(ug.UserName) {
+		logrus.Errorf("wx[%s] refresh group[%s] after invite error", self.wx.Session.MyNickName, ug.UserName)
+	}
+
+	return results, limitErr
+}
+
+// inviteBatch fills in the results of one batch, returns true on rate limit.
+func (self *UserContact) inviteBatch(ug *UserGroup, mode string, batch []int, results []GroupInviteResult) bool {
+	var userNames []string
+	for _, idx := range batch {
+		userNames = append(userNames, results[idx].UserName)
+	}
+	setBatch := func(ok bool, msg string) {
+		for _, idx := range batch {
+			results[idx].Ok = ok
+			results[idx].Msg = msg
+		}
+	}
+
+	var res string
+	var ok bool
+	if mode == GROUP_INVITE_MODE_ADD {
+		res, ok = self.wx.WebwxupdatechatroomAddmember(ug.UserName, userNames)
+	} else {
+		res, ok = self.wx.WebwxupdatechatroomInvitemember(ug.UserName, userNames)
+	}
+	if !ok {
+		setBatch(false, "request error")
+		return false
+	}
+	data, ok := CheckWebwxResData(res)
+	if !ok {
+		setBatch(false, "response error")
+		return false
+	}
+	baseResponse, _ := data["BaseResponse"].(map[string]interface{})
+	retCode, _ := baseResponse["Ret"].(int)
+	switch retCode {
+	case WX_RET_SUCCESS:
+	case WX_RET_FREQ_LIMIT, WX_RET_OP_TOO_FREQ:
+		setBatch(false, GROUP_INVITE_MSG_RATE_LIMITED)
+		return true
+	default:
+		setBatch(false, fmt.Sprintf("webwx ret[%d]", retCode))
+		return false
+	}
+	setBatch(true, "")
+
+	// addmember 会返回每个成员的状态, 非0表示没拉进来(拉黑/已删除好友等)
+	memberList, _ := data["MemberList"].([]interface{})
+	for _, v := range memberList {
+		member, _ := v.(map[string]interface{})
+		if member == nil {
+			continue
+		}
+		userName, _ := member["UserName"].(string)
+		status, _ := member["MemberStatus"].(int)
+		if status == 0 {
+			continue
+		}
+		for _, idx := range batch {
+			if results[idx].UserName == userName {
+				results[idx].Ok = false
+				results[idx].Msg = fmt.Sprintf("member status[%d]", status)
+			}
+		}
+	}
+	return false
+}
+
+// 好友拉群活动配置, 条件格式同事件的include()/notinclude()/equal()
+type InviteCampaignConf struct {
+	Name string `json:"name,omitempty"`
+	// 目标群, 可加isowner(), 一个群满了换下一个
+	GroupFilter string `json:"groupFilter"`
+	// 好友筛选, Sex为0不限
+	Sex    int    `json:"sex,omitempty"`
+	City   string `json:"city,omitempty"`
+	Remark string `json:"remark,omitempty"`
+	// 排序: default girlfirst boyfirst random
+	Order string `json:"order,omitempty"`
+	// 每批人数和间隔秒数, 每邀请PauseEvery人休息PauseSeconds秒
+	BatchSize     int   `json:"batchSize,omitempty"`
+	BatchInterval int64 `json:"batchInterval,omitempty"`
+	PauseEvery    int   `json:"pauseEvery,omitempty"`
+	PauseSeconds  int64 `json:"pauseSeconds,omitempty"`
+	// 被限频(-34)后等待秒数
+	FreqLimitWait int64 `json:"freqLimitWait,omitempty"`
+	// 群人数达到后换下一个群
+	MaxGroupMember int `json:"maxGroupMember,omitempty"`
+	// 拉群后私聊的消息, {nick}替换为好友昵称, {group}替换为群名, 为空不发
+	Msg         string `json:"msg,omitempty"`
+	MsgInterval int64  `json:"msgInterval,omitempty"`
+}
+
+// WithDefault returns a copy with the unset pacing filled in.
+func (self InviteCampaignConf) WithDefault() *InviteCampaignConf {
+	if self.Order == "" {
+		self.Order = INVITE_ORDER_DEFAULT
+	}
+	if self.BatchSize <= 0 {
+		self.BatchSize = INVITE_CAMPAIGN_BATCH_SIZE
+	}
+	if self.BatchInterval <= 0 {
+		self.BatchInterval = INVITE_CAMPAIGN_BATCH_INTERVAL
+	}
+	if self.PauseEvery <= 0 {
+		self.PauseEvery = INVITE_CAMPAIGN_PAUSE_EVERY
+	}
+	if self.PauseSeconds <= 0 {
+		self.PauseSeconds = INVITE_CAMPAIGN_PAUSE_SECONDS
+	}
+	if self.FreqLimitWait <= 0 {
+		self.FreqLimitWait = GROUP_INVITE_FREQ_LIMIT_COOLDOWN
+	}
+	if self.MaxGroupMember <= 0 {
+		self.MaxGroupMember = INVITE_CAMPAIGN_MAX_GROUP_MEMBER
+	}
+	if self.MsgInterval <= 0 {
+		self.MsgInterval = INVITE_CAMPAIGN_MSG_INTERVAL
+	}
+	return &self
+}
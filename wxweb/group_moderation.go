@@ -0,0 +1,294 @@
+package wxweb
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/reechou/wxrobot/models"
+)
+
+var groupModUrlRegexp = regexp.MustCompile(`(?i)(https?://|www\.)[^\s<>"'，。！]+`)
+
+type GroupModMsg struct {
+	MsgType    int
+	AppMsgType int
+	Content    string
+	Url        string
+}
+
+type groupModRule struct {
+	*models.RobotGroupModRule
+	keywords     []string
+	allowDomains []string
+	whitelist    map[string]bool
+}
+
+func newGroupModRule(r *models.RobotGroupModRule) *groupModRule {
+	rule := &groupModRule{
+		RobotGroupModRule: r,
+		keywords:          splitGroupModList(r.Keywords),
+		allowDomains:      splitGroupModList(r.AllowDomains),
+		whitelist:         make(map[string]bool),
+	}
+	for _, v := range splitGroupModList(r.Whitelist) {
+		rule.whitelist[v] = true
+	}
+	// 手写的规则可能没有设置时间窗口
+	if r.FloodSeconds <= 0 {
+		r.FloodSeconds = GROUP_MOD_FLOOD_SECONDS
+	}
+	if r.StrikeSeconds <= 0 {
+		r.StrikeSeconds = GROUP_MOD_STRIKE_SECONDS
+	}
+	return rule
+}
+
+func splitGroupModList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// GroupModerator checks group messages against the per-group rules in db,
+// warns the offender and kicks after KickStrikes strikes.
+type GroupModerator struct {
+	sync.Mutex
+
+	wx       *WxWeb
+	rules    map[string]*groupModRule
+	loadTime int64
+	floods   map[string][]int64
+}
+
+func NewGroupModerator(wx *WxWeb) *GroupModerator {
+	return &GroupModerator{
+		wx:     wx,
+		rules:  make(map[string]*groupModRule),
+		floods: make(map[string][]int64),
+	}
+}
+
+func (self *GroupModerator) enabled() bool {
+	return self.wx.argv.IfGroupModeration && self.wx.cfg.IfNeedOwnerDB
+}
+
+// getRule returns the rule of the group or the robot default, rules are
+// reloaded from db every GROUP_MOD_RULE_RELOAD_INTERVAL seconds.
+func (self *GroupModerator) getRule(groupName string) *groupModRule {
+	self.Lock()
+	defer self.Unlock()
+
+	now := time.Now().Unix()
+	if now-self.loadTime >= GROUP_MOD_RULE_RELOAD_INTERVAL {
+		self.loadTime = now
+		list, err := models.GetRobotGroupModRules(self.wx.Session.MyNickName)
+		if err != nil {
+			logrus.Errorf("wx[%s] get robot group mod rules error: %v", self.wx.Session.MyNickName, err)
+		} else {
+			rules := make(map[string]*groupModRule)
+			for i := range list {
+				rules[list[i].GroupName] = newGroupModRule(&list[i])
+			}
+			self.rules = rules
+		}
+	}
+	if rule, ok := self.rules[groupName]; ok {
+		return rule
+	}
+	return self.rules[""]
+}
+
+// ReloadRules makes the next message reload the rules from db.
+func (self *GroupModerator) ReloadRules() {
+	self.Lock()
+	self.loadTime = 0
+	self.Unlock()
+}
+
+// Check returns true when the member has been kicked out.
+func (self *GroupModerator) Check(ug *UserGroup, member *GroupUserInfo, msg *GroupModMsg) bool {
+	if !self.enabled() {
+		return false
+	}
+	groupName := ug.GetNickName()
+	rule := self.getRule(groupName)
+	if rule == nil {
+		return false
+	}
+	if member.UserName == self.wx.Session.MyUserName ||
+		member.UserName == ug.GetOwner() ||
+		rule.whitelist[member.NickName] {
+		return false
+	}
+	reason := self.violation(rule, ug, member, msg)
+	if reason == "" {
+		return false
+	}
+	return self.punish(rule, ug, member, reason, msg.Content)
+}
+
+func (self *GroupModerator) violation(rule *groupModRule, ug *UserGroup, member *GroupUserInfo, msg *GroupModMsg) string {
+	switch msg.MsgType {
+	case MSG_TYPE_CARD:
+		if rule.IfBlockCard != 0 {
+			return GROUP_MOD_REASON_CARD
+		}
+	case MSG_TYPE_SHARE_URL:
+		if msg.AppMsgType == APP_MSG_TYPE_MINI_APP || msg.AppMsgType == APP_MSG_TYPE_MINI_APP_SHARE {
+			if rule.IfBlockMiniApp != 0 {
+				return GROUP_MOD_REASON_MINI_APP
+			}
+		} else if rule.IfBlockUrl != 0 && msg.Url != "" && !rule.allowUrl(msg.Url) {
+			return GROUP_MOD_REASON_URL
+		}
+	case MSG_TYPE_TEXT:
+		for _, v := range rule.keywords {
+			if strings.Contains(msg.Content, v) {
+				return GROUP_MOD_REASON_KEYWORD
+			}
+		}
+		if rule.IfBlockUrl != 0 {
+			for _, v := range groupModUrlRegexp.FindAllString(msg.Content, -1) {
+				if !rule.allowUrl(v) {
+					return GROUP_MOD_REASON_URL
+				}
+			}
+		}
+	}
+	if rule.FloodNum > 0 && self.flood(rule, ug.UserName+member.UserName) {
+		return GROUP_MOD_REASON_FLOOD
+	}
+	return ""
+}
+
+func (self *groupModRule) allowUrl(rawurl string) bool {
+	if !strings.Contains(rawurl, "://") {
+		rawurl = "http://" + rawurl
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Host)
+	if i := strings.Index(host, ":"); i >= 0 {
+		host = host[:i]
+	}
+	for _, v := range self.allowDomains {
+		v = strings.ToLower(v)
+		if host == v || strings.HasSuffix(host, "."+v) {
+			return true
+		}
+	}
+	return false
+}
+
+// flood records the message and reports whether the member sent more than
+// FloodNum messages in FloodSeconds.
+func (self *GroupModerator) flood(rule *groupModRule, key string) bool {
+	self.Lock()
+	defer self.Unlock()
+
+	now := time.Now().Unix()
+	list := self.floods[key]
+	i := 0
+	for ; i < len(list); i++ {
+		if now-list[i] < rule.FloodSeconds {
+			break
+		}
+	}
+	list = append(list[i:], now)
+	if len(list) > rule.FloodNum {
+		delete(self.floods, key)
+		return true
+	}
+	self.floods[key] = list
+	return false
+}
+
+func (self *GroupModerator) punish(rule *groupModRule, ug *UserGroup, member *GroupUserInfo, reason, content string) bool {
+	groupName := ug.GetNickName()
+	strike := &models.RobotGroupStrike{
+		RobotWx:    self.wx.Session.MyNickName,
+		GroupName:  groupName,
+		MemberNick: member.NickName,
+	}
+	has, err := models.GetRobotGroupStrike(strike)
+	if err != nil {
+		logrus.Errorf("get robot group strike error: %v", err)
+		return false
+	}
+	// 超过StrikeSeconds没有违规的重新计数
+	if has && time.Now().Unix()-strike.UpdatedAt >= rule.StrikeSeconds {
+		strike.Strikes = 0
+	}
+	strike.Strikes++
+	strike.LastReason = reason
+
+	kicked := false
+	action := GROUP_MOD_ACTION_WARN
+	if rule.KickStrikes > 0 && strike.Strikes >= rule.KickStrikes {
+		action = GROUP_MOD_ACTION_KICK_FAIL
+		if err := ug.CheckOwner(); err != nil {
+			logrus.Errorf("wx[%s] group mod kick error: %v", self.wx.Session.MyNickName, err)
+		} else if self.wx.DelMemberWebwxupdatechatroom(ug.UserName, member.UserName) {
+			ug.DelMember(member.UserName)
+			action = GROUP_MOD_ACTION_KICK
+			kicked = true
+		}
+	}
+	if action != GROUP_MOD_ACTION_KICK {
+		warnMsg := rule.WarnMsg
+		if warnMsg == "" {
+			warnMsg = GROUP_MOD_WARN_MSG_DEFAULT
+		}
+		self.wx.Webwxsendmsg(fmt.Sprintf("@%s %s", member.NickName, warnMsg), ug.UserName)
+	}
+
+	if kicked {
+		strike.Strikes = 0
+	}
+	if has {
+		err = models.UpdateRobotGroupStrike(strike)
+	} else {
+		err = models.CreateRobotGroupStrike(strike)
+	}
+	if err != nil {
+		logrus.Errorf("save robot group strike error: %v", err)
+	}
+
+	// 按字符截断, 避免截断半个中文
+	if r := []rune(content); len(r) > GROUP_MOD_LOG_CONTENT_LEN {
+		content = string(r[:GROUP_MOD_LOG_CONTENT_LEN])
+	}
+	modLog := &models.RobotGroupModLog{
+		RobotWx:        self.wx.Session.MyNickName,
+		GroupName:      groupName,
+		MemberNick:     member.NickName,
+		MemberUserName: member.UserName,
+		Reason:         reason,
+		Action:         action,
+		Strikes:        strike.Strikes,
+		Content:        content,
+	}
+	models.CreateRobotGroupModLog(modLog)
+	logrus.Infof("wx[%s] group[%s] member[%s] %s by [%s], strikes: %d", self.wx.Session.MyNickName, groupName, member.NickName, action, reason, strike.Strikes)
+
+	return kicked
+}
+
+// ReloadGroupModRules applies the changed rules at the next group message.
+func (self *WxWeb) ReloadGroupModRules() {
+	if self.gm != nil {
+		self.gm.ReloadRules()
+	}
+}
@@ -0,0 +1,71 @@
+package wxweb
+
+import (
+	"testing"
+
+	"github.com/reechou/wxrobot/models"
+)
+
+func TestGroupModViolation(t *testing.T) {
+	wx := newTestWxWeb()
+	gm := NewGroupModerator(wx)
+	ug := NewUserGroup(0, "group", "@@group", wx)
+	member := &GroupUserInfo{UserName: "@m", NickName: "m"}
+	rule := newGroupModRule(&models.RobotGroupModRule{
+		Keywords:       "加微信, 代购",
+		IfBlockUrl:     1,
+		AllowDomains:   "qq.com",
+		IfBlockCard:    1,
+		IfBlockMiniApp: 1,
+		FloodNum:       3,
+		FloodSeconds:   60,
+	})
+
+	cases := []struct {
+		msg    GroupModMsg
+		reason string
+	}{
+		{GroupModMsg{MsgType: MSG_TYPE_TEXT, Content: "hello"}, ""},
+		{GroupModMsg{MsgType: MSG_TYPE_TEXT, Content: "有需要代购的吗"}, GROUP_MOD_REASON_KEYWORD},
+		{GroupModMsg{MsgType: MSG_TYPE_TEXT, Content: "see https://mp.weixin.qq.com/s/xx"}, ""},
+		{GroupModMsg{MsgType: MSG_TYPE_TEXT, Content: "see www.ads.com/a"}, GROUP_MOD_REASON_URL},
+		{GroupModMsg{MsgType: MSG_TYPE_CARD}, GROUP_MOD_REASON_CARD},
+		{GroupModMsg{MsgType: MSG_TYPE_SHARE_URL, AppMsgType: APP_MSG_TYPE_MINI_APP}, GROUP_MOD_REASON_MINI_APP},
+		{GroupModMsg{MsgType: MSG_TYPE_SHARE_URL, Url: "http://qq.com.ads.cn/"}, GROUP_MOD_REASON_URL},
+	}
+	for _, v := range cases {
+		// flood is counted separately below
+		rule.FloodNum = 0
+		if reason := gm.violation(rule, ug, member, &v.msg); reason != v.reason {
+			t.Errorf("msg[%v] reason: %q, want %q", v.msg, reason, v.reason)
+		}
+	}
+
+	rule.FloodNum = 3
+	msg := &GroupModMsg{MsgType: MSG_TYPE_TEXT, Content: "hi"}
+	for i := 0; i < 3; i++ {
+		if reason := gm.violation(rule, ug, member, msg); reason != "" {
+			t.Fatalf("msg %d reason: %q, want none", i, reason)
+		}
+	}
+	if reason := gm.violation(rule, ug, member, msg); reason != GROUP_MOD_REASON_FLOOD {
+		t.Errorf("reason: %q, want flood", reason)
+	}
+}
+
+func TestGroupModRuleDefault(t *testing.T) {
+	wx := newTestWxWeb()
+	gm := NewGroupModerator(wx)
+	ug := NewUserGroup(0, "group", "@@group", wx)
+	member := &GroupUserInfo{UserName: "@m", NickName: "m"}
+	// 没有设置FloodSeconds时用默认窗口, 不能让刷屏检查失效
+	rule := newGroupModRule(&models.RobotGroupModRule{FloodNum: 1})
+	if rule.FloodSeconds != GROUP_MOD_FLOOD_SECONDS || rule.StrikeSeconds != GROUP_MOD_STRIKE_SECONDS {
+		t.Errorf("rule windows %d %d, want defaults", rule.FloodSeconds, rule.StrikeSeconds)
+	}
+	msg := &GroupModMsg{MsgType: MSG_TYPE_TEXT, Content: "hi"}
+	gm.violation(rule, ug, member, msg)
+	if reason := gm.violation(rule, ug, member, msg); reason != GROUP_MOD_REASON_FLOOD {
+		t.Errorf("reason: %q, want flood", reason)
+	}
+}
@@ -0,0 +1,119 @@
+package wxweb
+
+import (
+	"fmt"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/reechou/wxrobot/models"
+)
+
+// checkGroupRename handles a group renamed by someone: locked groups are
+// reverted and reported, the rest are renamed locally.
+func (self *WxWeb) checkGroupRename(group *UserGroup, newNickName string) {
+	oldNickName := group.GetNickName()
+	if group.TakeExpectTopic(newNickName) || !self.isGroupNameLocked(oldNickName) {
+		self.Contact.RenameGroup(group, newNickName)
+		return
+	}
+
+	// 不准修改群名
+	logrus.Infof("wx[%s] group[%s] renamed to [%s], revert it.", self.Session.MyNickName, oldNickName, newNickName)
+	if !self.WebwxupdatechatroomModTopic(group.UserName, oldNickName) {
+		return
+	}
+
+	receiveMsg := &ReceiveMsgInfo{}
+	receiveMsg.BaseInfo.Uin = self.Session.Uin
+	receiveMsg.BaseInfo.UserName = self.Session.MyUserName
+	receiveMsg.BaseInfo.WechatNick = self.Session.MyNickName
+	receiveMsg.BaseInfo.FromGroupName = oldNickName
+	receiveMsg.BaseInfo.FromUserName = group.UserName
+	receiveMsg.BaseInfo.ReceiveEvent = RECEIVE_EVENT_GROUP_NAME_REVERT
+	receiveMsg.BaseInfo.FromType = FROM_TYPE_GROUP
+	receiveMsg.Msg = newNickName
+	receiveMsg.GroupMemberNum = group.GetGroupMemberLen()
+	receiveMsg.IsGroupOwner = group.IsOwner()
+	self.wxh.ReceiveMsg(receiveMsg)
+}
+
+// isGroupNameLocked looks up the per-group policy first, groups without one
+// follow the robot-wide IfNotChangeGroupName.
+func (self *WxWeb) isGroupNameLocked(groupNickName string) bool {
+	if self.cfg != nil && self.cfg.IfNeedOwnerDB {
+		lock := &models.RobotGroupNameLock{
+			RobotWx:   self.Session.MyNickName,
+			GroupName: groupNickName,
+		}
+		has, err := models.GetRobotGroupNameLock(lock)
+		if err != nil {
+			logrus.Errorf("get robot group name lock error: %v", err)
+		} else if has {
+			return lock.IfLock != 0
+		}
+	}
+	return self.argv.IfNotChangeGroupName
+}
+
+// RenameGroupTopic renames the group as the robot, the lock policy of the group
+// follows the new name.
+func (self *UserContact) RenameGroupTopic(ug *UserGroup, topic string) error {
+	if topic == "" {
+		return fmt.Errorf("group topic cannot be empty")
+	}
+	oldNickName := ug.GetNickName()
+	if oldNickName == topic {
+		return nil
+	}
+	// 改名的同步消息可能先于返回到达, 先记下避免被当成别人改名回滚
+	ug.SetExpectTopic(topic)
+	if !self.wx.WebwxupdatechatroomModTopic(ug.UserName, topic) {
+		ug.SetExpectTopic("")
+		return fmt.Errorf("webwx mod group[%s] topic[%s] error", oldNickName, topic)
+	}
+	self.RenameGroup(ug, topic)
+	ug.SetExpectTopic("")
+
+	if self.wx.cfg.IfNeedOwnerDB {
+		lock := &models.RobotGroupNameLock{
+			RobotWx:   self.wx.Session.MyNickName,
+			GroupName: oldNickName,
+		}
+		has, err := models.GetRobotGroupNameLock(lock)
+		if err != nil {
+			logrus.Errorf("get robot group name lock error: %v", err)
+		} else if has {
+			// 新群名可能还留着别的群的策略, 先删掉, 否则唯一索引冲突
+			if err = models.DelRobotGroupNameLock(lock.RobotWx, topic); err != nil {
+				return fmt.Errorf("group renamed, but del the old lock of [%s] error: %v", topic, err)
+			}
+			lock.GroupName = topic
+			if err = models.UpdateRobotGroupNameLock(lock); err != nil {
+				return fmt.Errorf("group renamed, but move the name lock to [%s] error: %v", topic, err)
+			}
+		}
+	}
+	return nil
+}
+
+// SetGroupNameLock stores the lock policy of the group.
+func (self *UserContact) SetGroupNameLock(ug *UserGroup, ifLock bool) error {
+	if !self.wx.cfg.IfNeedOwnerDB {
+		return fmt.Errorf("group name lock needs owner db")
+	}
+	lock := &models.RobotGroupNameLock{
+		RobotWx:   self.wx.Session.MyNickName,
+		GroupName: ug.GetNickName(),
+	}
+	has, err := models.GetRobotGroupNameLock(lock)
+	if err != nil {
+		return err
+	}
+	lock.IfLock = 0
+	if ifLock {
+		lock.IfLock = 1
+	}
+	if has {
+		return models.UpdateRobotGroupNameLock(lock)
+	}
+	return models.CreateRobotGroupNameLock(lock)
+}
@@ -0,0 +1,443 @@
+package wxweb
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/reechou/wxrobot/models"
+)
+
+// JobFunc runs a job until it ends or the context is cancelled.
+type JobFunc func(ctx *JobContext) error
+
+// JobFactory checks the argv of a job and builds its run function, an empty
+// argv means the values of StartWxArgv.
+type JobFactory func(wx *WxWeb, argv json.RawMessage) (JobFunc, error)
+
+var jobFactories = map[string]JobFactory{
+	JOB_INVITE_MEMBERS:     newInviteMembersJob,
+	JOB_DEAD_FRIEND_SCAN:   newDeadFriendScanJob,
+	JOB_SAVE_ROBOT_FRIENDS: newSaveRobotFriendsJob,
+	JOB_SAVE_ROBOT_GROUPS:  newSaveRobotGroupsJob,
+	JOB_CREATE_GROUPS:      newCreateGroupsJob,
+	JOB_ADD_GROUP_MEMBER:   newAddGroupMemberJob,
+}
+
+// JobNames returns the names of all jobs can be started.
+func JobNames() []string {
+	var list []string
+	for k := range jobFactories {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return list
+}
+
+type JobInfo struct {
+	Name       string          `json:"name"`
+	Status     string          `json:"status"`
+	Argv       json.RawMessage `json:"argv,omitempty"`
+	Done       int64           `json:"done"`
+	Total      int64           `json:"total"`
+	Result     interface{}     `json:"result,omitempty"`
+	Err        string          `json:"err,omitempty"`
+	StartedAt  int64           `json:"startedAt"`
+	FinishedAt int64           `json:"finishedAt,omitempty"`
+}
+
+// JobContext is given to a running job for pause, cancel and progress.
+type JobContext struct {
+	sync.Mutex
+
+	wx       *WxWeb
+	info     JobInfo
+	resume   chan struct{}
+	cancel   chan struct{}
+	lastSave int64
+	after    []func()
+}
+
+func (self *JobContext) Name() string {
+	return self.info.Name
+}
+
+// Wait blocks while the job is paused, false means it is cancelled.
+func (self *JobContext) Wait() bool {
+	for {
+		self.Lock()
+		status := self.info.Status
+		resume := self.resume
+		self.Unlock()
+		switch status {
+		case JOB_STATUS_RUNNING:
+			return true
+		case JOB_STATUS_PAUSED:
+			select {
+			case <-resume:
+			case <-self.cancel:
+				return false
+			}
+		default:
+			return false
+		}
+	}
+}
+
+// Cancelled reports whether the job is cancelled without blocking.
+func (self *JobContext) Cancelled() bool {
+	select {
+	case <-self.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stopped reports whether the job is stopped by logout, it is restored on next login.
+func (self *JobContext) Stopped() bool {
+	self.Lock()
+	defer self.Unlock()
+
+	return self.info.Status == JOB_STATUS_STOPPED
+}
+
+// Sleep waits d and then Wait, false means it is cancelled.
+func (self *JobContext) Sleep(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+	case <-self.cancel:
+		return false
+	}
+	return self.Wait()
+}
+
+func (self *JobContext) SetProgress(done, total int64) {
+	self.Lock()
+	self.info.Done = done
+	self.info.Total = total
+	self.Unlock()
+	self.save(false)
+}
+
+// Defer runs f after the job has ended and saved, even if cancelled.
+func (self *JobContext) Defer(f func()) {
+	self.Lock()
+	self.after = append(self.after, f)
+	self.Unlock()
+}
+
+// SetResult sets what the job reports, v should not be changed after.
+func (self *JobContext) SetResult(v interface{}) {
+	self.Lock()
+	self.info.Result = v
+	self.Unlock()
+	self.save(false)
+}
+
+func (self *JobContext) Info() JobInfo {
+	self.Lock()
+	defer self.Unlock()
+
+	return self.info
+}
+
+func (self *JobContext) setStatus(status string) error {
+	self.Lock()
+	switch {
+	case self.info.Status == status:
+	case status == JOB_STATUS_PAUSED && self.info.Status == JOB_STATUS_RUNNING:
+		self.resume = make(chan struct{})
+	case status == JOB_STATUS_RUNNING && self.info.Status == JOB_STATUS_PAUSED:
+		close(self.resume)
+	case status == JOB_STATUS_CANCELLED && self.info.Status == JOB_STATUS_RUNNING,
+		status == JOB_STATUS_CANCELLED && self.info.Status == JOB_STATUS_PAUSED:
+		close(self.cancel)
+	default:
+		self.Unlock()
+		return fmt.Errorf("job[%s] is %s, cannot be %s", self.info.Name, self.info.Status, status)
+	}
+	self.info.Status = status
+	self.Unlock()
+	self.save(true)
+	return nil
+}
+
+func (self *JobContext) finish(err error) {
+	self.Lock()
+	if self.info.Status == JOB_STATUS_STOPPED {
+		name := self.info.Name
+		self.Unlock()
+		logrus.Infof("wx[%s] job[%s] stopped by logout.", self.wx.Session.MyNickName, name)
+		return
+	}
+	if self.info.Status != JOB_STATUS_CANCELLED {
+		self.info.Status = JOB_STATUS_FINISHED
+		if err != nil {
+			self.info.Status = JOB_STATUS_FAILED
+		}
+	}
+	if err != nil {
+		self.info.Err = err.Error()
+	}
+	self.info.FinishedAt = time.Now().Unix()
+	info := self.info
+	after := self.after
+	self.Unlock()
+	self.save(true)
+
+	logrus.Infof("wx[%s] job[%s] %s, done[%d/%d] %s", self.wx.Session.MyNickName, info.Name, info.Status, info.Done, info.Total, info.Err)
+	for _, f := range after {
+		f()
+	}
+}
+
+// save persists the job, progress is saved at most every JOB_SAVE_INTERVAL seconds.
+func (self *JobContext) save(force bool) {
+	if self.wx.cfg == nil || !self.wx.cfg.IfNeedOwnerDB {
+		return
+	}
+	now := time.Now().Unix()
+	self.Lock()
+	if !force && now-self.lastSave < JOB_SAVE_INTERVAL {
+		self.Unlock()
+		return
+	}
+	self.lastSave = now
+	info := self.info
+	self.Unlock()
+	if info.Status == JOB_STATUS_STOPPED {
+		// 保留登出前的状态, 下次登录恢复
+		return
+	}
+
+	job := &models.RobotJob{
+		RobotWx:    self.wx.Session.MyNickName,
+		Name:       info.Name,
+		Status:     info.Status,
+		Argv:       string(info.Argv),
+		Done:       info.Done,
+		Total:      info.Total,
+		ErrMsg:     info.Err,
+		StartedAt:  info.StartedAt,
+		FinishedAt: info.FinishedAt,
+	}
+	if info.Result != nil {
+		if result, err := json.Marshal(info.Result); err == nil {
+			job.Result = string(result)
+		}
+	}
+	if err := models.SaveRobotJob(job); err != nil {
+		logrus.Errorf("save robot job[%s] error: %v", info.Name, err)
+	}
+}
+
+// JobManager runs the background jobs of a robot, one job of each name at a time.
+type JobManager struct {
+	sync.Mutex
+
+	wx   *WxWeb
+	jobs map[string]*JobContext
+}
+
+func NewJobManager(wx *WxWeb) *JobManager {
+	return &JobManager{
+		wx:   wx,
+		jobs: make(map[string]*JobContext),
+	}
+}
+
+func (self *JobManager) Start(name string, argv json.RawMessage) (*JobInfo, error) {
+	return self.start(name, argv, false)
+}
+
+func (self *JobManager) start(name string, argv json.RawMessage, paused bool) (*JobInfo, error) {
+	factory, ok := jobFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown job[%s]", name)
+	}
+
+	self.Lock()
+	defer self.Unlock()
+
+	if ctx := self.jobs[name]; ctx != nil {
+		status := ctx.Info().Status
+		if status == JOB_STATUS_RUNNING || status == JOB_STATUS_PAUSED {
+			return nil, fmt.Errorf("job[%s] is already %s", name, status)
+		}
+	}
+	if string(argv) == "null" {
+		argv = nil
+	}
+	f, err := factory(self.wx, argv)
+	if err != nil {
+		return nil, err
+	}
+	ctx := &JobContext{
+		wx: self.wx,
+		info: JobInfo{
+			Name:      name,
+			Status:    JOB_STATUS_RUNNING,
+			Argv:      argv,
+			StartedAt: time.Now().Unix(),
+		},
+		cancel: make(chan struct{}),
+	}
+	if paused {
+		ctx.info.Status = JOB_STATUS_PAUSED
+		ctx.resume = make(chan struct{})
+	}
+	self.jobs[name] = ctx
+	ctx.save(true)
+	logrus.Infof("wx[%s] job[%s] start.", self.wx.Session.MyNickName, name)
+	go func() {
+		ctx.finish(f(ctx))
+	}()
+
+	info := ctx.Info()
+	return &info, nil
+}
+
+func (self *JobManager) get(name string) (*JobContext, error) {
+	self.Lock()
+	defer self.Unlock()
+
+	ctx := self.jobs[name]
+	if ctx == nil {
+		return nil, fmt.Errorf("job[%s] has not started", name)
+	}
+	return ctx, nil
+}
+
+func (self *JobManager) control(name, status string) (*JobInfo, error) {
+	ctx, err := self.get(name)
+	if err != nil {
+		return nil, err
+	}
+	if err = ctx.setStatus(status); err != nil {
+		return nil, err
+	}
+	info := ctx.Info()
+	return &info, nil
+}
+
+func (self *JobManager) Pause(name string) (*JobInfo, error) {
+	return self.control(name, JOB_STATUS_PAUSED)
+}
+
+func (self *JobManager) Resume(name string) (*JobInfo, error) {
+	return self.control(name, JOB_STATUS_RUNNING)
+}
+
+func (self *JobManager) Cancel(name string) (*JobInfo, error) {
+	return self.control(name, JOB_STATUS_CANCELLED)
+}
+
+func (self *JobManager) Get(name string) (*JobInfo, error) {
+	ctx, err := self.get(name)
+	if err != nil {
+		return nil, err
+	}
+	info := ctx.Info()
+	return &info, nil
+}
+
+// IsRunning reports whether the job is running or paused.
+func (self *JobManager) IsRunning(name string) bool {
+	info, err := self.Get(name)
+	if err != nil {
+		return false
+	}
+	return info.Status == JOB_STATUS_RUNNING || info.Status == JOB_STATUS_PAUSED
+}
+
+func (self *JobManager) List() []JobInfo {
+	self.Lock()
+	defer self.Unlock()
+
+	var list []JobInfo
+	for _, v := range self.jobs {
+		list = append(list, v.Info())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// restore starts again the jobs left running or paused by the last login of the robot.
+func (self *JobManager) restore() {
+	if self.wx.cfg == nil || !self.wx.cfg.IfNeedOwnerDB {
+		return
+	}
+	list, err := models.GetRobotJobsByStatus(self.wx.Session.MyNickName, JOB_STATUS_RUNNING, JOB_STATUS_PAUSED)
+	if err != nil {
+		logrus.Errorf("get robot jobs error: %v", err)
+		return
+	}
+	for _, v := range list {
+		if self.IsRunning(v.Name) {
+			continue
+		}
+		var argv json.RawMessage
+		if v.Argv != "" {
+			argv = json.RawMessage(v.Argv)
+		}
+		if _, err = self.start(v.Name, argv, v.Status == JOB_STATUS_PAUSED); err != nil {
+			logrus.Errorf("wx[%s] restore job[%s] error: %v", self.wx.Session.MyNickName, v.Name, err)
+		}
+	}
+}
+
+// stopAll cancels the jobs on logout without waiting, a job may stop the robot
+// itself. The saved status is kept so they are restored on next login.
+func (self *JobManager) stopAll() {
+	self.Lock()
+	defer self.Unlock()
+
+	for _, v := range self.jobs {
+		v.Lock()
+		if v.info.Status == JOB_STATUS_RUNNING || v.info.Status == JOB_STATUS_PAUSED {
+			close(v.cancel)
+			v.info.Status = JOB_STATUS_STOPPED
+		}
+		v.Unlock()
+	}
+}
+
+func (self *WxWeb) StartJob(name string, argv json.RawMessage) (*JobInfo, error) {
+	return self.jobs.Start(name, argv)
+}
+
+func (self *WxWeb) PauseJob(name string) (*JobInfo, error) {
+	return self.jobs.Pause(name)
+}
+
+func (self *WxWeb) ResumeJob(name string) (*JobInfo, error) {
+	return self.jobs.Resume(name)
+}
+
+func (self *WxWeb) CancelJob(name string) (*JobInfo, error) {
+	return self.jobs.Cancel(name)
+}
+
+func (self *WxWeb) GetJob(name string) (*JobInfo, error) {
+	return self.jobs.Get(name)
+}
+
+func (self *WxWeb) ListJobs() []JobInfo {
+	return self.jobs.List()
+}
+
+func decodeJobArgv(argv json.RawMessage, v interface{}) error {
+	if len(argv) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(argv, v); err != nil {
+		return fmt.Errorf("job argv json decode error: %v", err)
+	}
+	return nil
+}
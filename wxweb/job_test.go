@@ -0,0 +1,60 @@
+package wxweb
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestJobManagerControl(t *testing.T) {
+	steps := make(chan int, 10)
+	jobFactories["test"] = func(wx *WxWeb, argv json.RawMessage) (JobFunc, error) {
+		return func(ctx *JobContext) error {
+			for i := 1; ctx.Sleep(10 * time.Millisecond); i++ {
+				ctx.SetProgress(int64(i), 0)
+				steps <- i
+			}
+			return nil
+		}, nil
+	}
+	defer delete(jobFactories, "test")
+
+	jm := NewJobManager(newTestWxWeb())
+	if _, err := jm.Start("test", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jm.Start("test", nil); err == nil {
+		t.Fatal("start a running job twice")
+	}
+	<-steps
+	if _, err := jm.Pause("test"); err != nil {
+		t.Fatal(err)
+	}
+	// 暂停前可能已进入下一步
+	select {
+	case <-steps:
+	case <-time.After(50 * time.Millisecond):
+	}
+	select {
+	case <-steps:
+		t.Fatal("paused job still runs")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, err := jm.Resume("test"); err != nil {
+		t.Fatal(err)
+	}
+	<-steps
+	if _, err := jm.Cancel("test"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if info, _ := jm.Get("test"); info.FinishedAt != 0 {
+			if info.Status != JOB_STATUS_CANCELLED || info.Done == 0 {
+				t.Fatalf("job info: %+v", info)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("cancelled job does not end")
+}
@@ -4,6 +4,7 @@ import (
 	//"encoding/json"
 	"encoding/xml"
 	"fmt"
+	"html"
 	"net/url"
 	"regexp"
 	"strconv"
@@ -53,50 +54,20 @@ func (self *WxWeb) handleMsg(r interface{}) {
 					if group == nil {
 						group = NewUserGroup(groupContactFlag, groupNickName, userName, self)
 					} else {
-						group.ContactFlag = groupContactFlag
-						if group.NickName != groupNickName {
-							if self.argv.IfNotChangeGroupName {
-								// 不准修改群名
-								self.WebwxupdatechatroomModTopic(userName, group.NickName)
-							} else {
-								group.NickName = groupNickName
-							}
+						group.SetContactFlag(groupContactFlag)
+						if group.GetNickName() != groupNickName {
+							self.checkGroupRename(group, groupNickName)
 						}
 					}
+					group.SetOwner(self.parseGroupOwner(modContact))
 					memberList := modContact["MemberList"].([]interface{})
-					memberListMap := make(map[string]*GroupUserInfo)
-					nickMemberListMap := make(map[string]*GroupUserInfo)
-					var originalMemberList []*GroupUserInfo
-					for _, v2 := range memberList {
-						member := v2.(map[string]interface{})
-						if member == nil {
-							logrus.Errorf("handlemsg get member[%v] error", v2)
-							continue
-						}
-						displayName := member["DisplayName"].(string)
-						nickName := member["NickName"].(string)
-						if !self.argv.IfNotReplaceEmoji {
-							nickName = replaceEmoji(nickName)
-						}
-						userName := member["UserName"].(string)
-						gui := &GroupUserInfo{
-							DisplayName: displayName,
-							NickName:    nickName,
-							UserName:    userName,
-						}
-						memberListMap[userName] = gui
-						nickMemberListMap[nickName] = gui
-						if self.argv.IfSaveGroupMember {
-							originalMemberList = append(originalMemberList, gui)
-						}
-					}
+					memberListMap, nickMemberListMap, originalMemberList := self.parseGroupMembers(memberList)
 					group.ModMember(memberListMap)
 					group.SetMemberList(memberListMap, nickMemberListMap, originalMemberList)
 					if self.argv.IfSaveGroupMember {
 						self.agml.AddGroup(userName)
 					}
-					self.Contact.SetGroup(userName, group)
-					self.Contact.SetNickGroup(groupNickName, group)
+					self.Contact.AddGroup(group)
 
 					// test
 					//if groupNickName == "xxxx" {
@@ -117,7 +88,7 @@ func (self *WxWeb) handleMsg(r interface{}) {
 					alias := modContact["Alias"].(string)
 					city := modContact["City"].(string)
 					sex := modContact["Sex"].(int)
-					user := self.Contact.Friends[userName]
+					user := self.Contact.GetFriend(userName)
 					if user == nil {
 						//realName := userNickName
 						//_, ok := self.Contact.NickFriends[realName]
@@ -146,8 +117,7 @@ func (self *WxWeb) handleMsg(r interface{}) {
 							Sex:         sex,
 							UserName:    userName,
 						}
-						self.Contact.Friends[userName] = uf
-						self.Contact.NickFriends[realName] = uf
+						self.Contact.AddFriend(uf)
 
 						receiveMsg := &ReceiveMsgInfo{}
 						receiveMsg.BaseInfo.Uin = self.Session.Uin
@@ -164,6 +134,41 @@ func (self *WxWeb) handleMsg(r interface{}) {
 						if receiveMsg.BaseInfo.ReceiveEvent != "" {
 							self.wxh.ReceiveMsg(receiveMsg)
 						}
+					} else {
+						// 好友资料变化
+						uf := *user
+						uf.Alias = alias
+						uf.City = city
+						uf.VerifyFlag = userVerifyFlag
+						uf.ContactFlag = userContactFlag
+						uf.NickName = userNickName
+						uf.Sex = sex
+						if signature, ok := modContact["Signature"].(string); ok {
+							if !self.argv.IfNotReplaceEmoji {
+								signature = replaceEmoji(signature)
+							}
+							uf.Signature = signature
+						}
+						remarkName, _ := modContact["RemarkName"].(string)
+						if !self.argv.IfNotReplaceEmoji {
+							remarkName = replaceEmoji(remarkName)
+						}
+						if remarkName != "" {
+							uf.RemarkName = remarkName
+						} else if user.RemarkName == user.NickName {
+							// 没有备注时以昵称为索引
+							uf.RemarkName = userNickName
+						}
+						// 和其他好友重名时同初始化一样加后缀, 否则会覆盖对方的索引
+						if other := self.Contact.GetNickFriend(uf.RemarkName); other != nil && other.UserName != uf.UserName {
+							realName := fmt.Sprintf("%s__%s", uf.RemarkName, time.Now().Format("20060102_15:04"))
+							ok := self.WebwxOplog(uf.UserName, realName)
+							if ok {
+								logrus.Debugf("mod contact webwxoplog success.")
+							}
+							uf.RemarkName = realName
+						}
+						self.modFriend(user, &uf)
 					}
 				}
 			}
@@ -207,9 +212,9 @@ func (self *WxWeb) handleMsg(r interface{}) {
 			msgType == MSG_TYPE_SHARE_URL {
 			//logrus.Debugf("text msg: %s", content)
 			receiveMsg.MsgType = RECEIVE_MSG_MAP[msgType]
-			if strings.Contains(content, MSG_MEDIA_KEYWORD) {
-				continue
-			}
+			// 媒体消息也要存档和统计, 但不交给事件处理
+			ifMedia := strings.Contains(content, MSG_MEDIA_KEYWORD)
+			receiveMsg.IsMedia = ifMedia
 			if strings.HasPrefix(fromUserName, GROUP_PREFIX) {
 				contentSlice := strings.Split(content, ":<br/>")
 				if len(contentSlice) < 2 {
@@ -226,13 +231,22 @@ func (self *WxWeb) handleMsg(r interface{}) {
 				if sendPeople == nil {
 					continue
 				}
-				msg := &MsgInfo{
-					WXMsgId:  msgid,
-					NickName: sendPeople.NickName,
-					UserName: sendPeople.UserName,
-					Content:  content,
+				modMsg := &GroupModMsg{MsgType: msgType, Content: content}
+				modMsg.AppMsgType, _ = msg["AppMsgType"].(int)
+				modMsg.Url, _ = msg["Url"].(string)
+				if self.gm.Check(group, sendPeople, modMsg) {
+					continue
+				}
+				if ifMedia {
+					group.markActive(sendPeople.UserName)
+				} else {
+					group.AppendMsg(&MsgInfo{
+						WXMsgId:  msgid,
+						NickName: sendPeople.NickName,
+						UserName: sendPeople.UserName,
+						Content:  content,
+					})
 				}
-				group.AppendMsg(msg)
 
 				peopleNickname := sendPeople.NickName
 				uf := self.Contact.GetFriend(people)
@@ -240,25 +254,27 @@ func (self *WxWeb) handleMsg(r interface{}) {
 					peopleNickname = uf.RemarkName
 				}
 
-				receiveMsg.BaseInfo.FromGroupName = group.NickName
+				receiveMsg.BaseInfo.FromGroupName = group.GetNickName()
 				receiveMsg.BaseInfo.FromMemberUserName = sendPeople.UserName
 				receiveMsg.BaseInfo.FromNickName = peopleNickname
 				receiveMsg.BaseInfo.FromType = FROM_TYPE_GROUP
 				receiveMsg.GroupMemberNum = group.GetGroupMemberLen()
+				receiveMsg.IsGroupOwner = group.IsOwner()
 			} else {
 				if receiveMsg.BaseInfo.FromUserName == self.Session.MyUserName {
 					receiveMsg.BaseInfo.FromNickName = self.Session.MyNickName
 					toUserName := msg["ToUserName"].(string)
 					receiveMsg.BaseToUserInfo.ToUserName = toUserName
-					uf, ok := self.Contact.Friends[toUserName]
-					if ok {
+					uf := self.Contact.GetFriend(toUserName)
+					if uf != nil {
 						receiveMsg.BaseToUserInfo.ToNickName = uf.RemarkName
 					}
 				} else {
-					uf, ok := self.Contact.Friends[receiveMsg.BaseInfo.FromUserName]
-					if ok {
+					uf := self.Contact.GetFriend(receiveMsg.BaseInfo.FromUserName)
+					if uf != nil {
 						receiveMsg.BaseInfo.FromNickName = uf.RemarkName
 					}
+					self.dfs.markActive(receiveMsg.BaseInfo.FromUserName)
 				}
 				receiveMsg.BaseInfo.FromType = FROM_TYPE_PEOPLE
 				self.webwxstatusnotifyMsgRead(receiveMsg.BaseInfo.FromUserName)
@@ -275,6 +291,11 @@ func (self *WxWeb) handleMsg(r interface{}) {
 			default:
 				receiveMsg.Msg = "unknown msg"
 			}
+			mediaRef := receiveMsg.MediaTempUrl
+			if msgType == MSG_TYPE_SHARE_URL {
+				mediaRef, _ = msg["Url"].(string)
+			}
+			self.archiveReceiveMsg(receiveMsg, msgid, content, mediaRef)
 		} else if msgType == MSG_TYPE_INIT {
 			//logrus.Debug("[*] 成功截获微信初始化消息", msg)
 			statusNotifyCode := msg["StatusNotifyCode"]
@@ -302,11 +323,12 @@ func (self *WxWeb) handleMsg(r interface{}) {
 				receiveMsg.BaseInfo.Uin = self.Session.Uin
 				receiveMsg.BaseInfo.UserName = self.Session.MyUserName
 				receiveMsg.BaseInfo.WechatNick = self.Session.MyNickName
-				receiveMsg.BaseInfo.FromGroupName = group.NickName
+				receiveMsg.BaseInfo.FromGroupName = group.GetNickName()
 				receiveMsg.BaseInfo.FromUserName = fromUserName
 				receiveMsg.BaseInfo.ReceiveEvent = RECEIVE_EVENT_MOD_GROUP_ADD
 				receiveMsg.BaseInfo.FromType = FROM_TYPE_GROUP
 				receiveMsg.GroupMemberNum = group.GetGroupMemberLen()
+				receiveMsg.IsGroupOwner = group.IsOwner()
 				if receiveMsg.BaseInfo.ReceiveEvent != "" {
 					self.wxh.ReceiveMsg(receiveMsg)
 				}
@@ -314,18 +336,7 @@ func (self *WxWeb) handleMsg(r interface{}) {
 
 			// 系统消息不是好友
 			if strings.Contains(content, WX_SYSTEM_NOT_FRIEND) {
-				if self.argv.IfClearWx {
-					prefix := self.argv.ClearWxPrefix
-					if prefix == "" {
-						prefix = CLEAR_WX_PREFIX_DEFAULT
-					}
-					user := self.Contact.Friends[fromUserName]
-					userNick := ""
-					if user != nil {
-						userNick = user.NickName
-					}
-					self.WebwxOplog(fromUserName, fmt.Sprintf("%s %s", prefix, userNick))
-				}
+				self.dfs.markDead(fromUserName)
 			}
 		} else if msgType == MSG_TYPE_VERIFY_USER {
 			recommendInfo := msg["RecommendInfo"]
@@ -379,6 +390,12 @@ func (self *WxWeb) handleMsg(r interface{}) {
 			sex = strings.Replace(sex, "sex=", "", -1)
 			sexInt, _ := strconv.Atoi(sex)
 
+			var verifyContent string
+			reg = regexp.MustCompile(`\scontent="(.*?)"`)
+			if m := reg.FindStringSubmatch(string(content)); m != nil {
+				verifyContent = html.UnescapeString(m[1])
+			}
+
 			if !self.argv.IfNotReplaceEmoji {
 				nickName = replaceEmoji(nickName)
 				sourcenickname = replaceEmoji(sourcenickname)
@@ -421,6 +438,7 @@ func (self *WxWeb) handleMsg(r interface{}) {
 			receiveMsg.AddFriend.UserNick = realName
 			receiveMsg.AddFriend.UserCity = city
 			receiveMsg.AddFriend.UserSex = sexInt
+			receiveMsg.AddFriend.VerifyContent = verifyContent
 
 			uf := &UserFriend{
 				Alias:      alias,
@@ -430,8 +448,7 @@ func (self *WxWeb) handleMsg(r interface{}) {
 				Sex:        sexInt,
 				UserName:   userName,
 			}
-			self.Contact.Friends[userName] = uf
-			self.Contact.NickFriends[realName] = uf
+			self.Contact.AddFriend(uf)
 		}
 		//logrus.Debugf("receiveMsg: %v", receiveMsg)
 		if receiveMsg.BaseInfo.ReceiveEvent != "" {
@@ -440,6 +457,30 @@ func (self *WxWeb) handleMsg(r interface{}) {
 	}
 }
 
+func (self *WxWeb) modFriend(old, uf *UserFriend) {
+	changes := diffUserFriend(old, uf)
+	if len(changes) == 0 {
+		return
+	}
+	self.Contact.UpdateFriend(uf)
+	logrus.Debugf("wx[%s] friend[%s] changed: %v", self.Session.MyNickName, uf.UserName, changes)
+
+	receiveMsg := &ReceiveMsgInfo{}
+	receiveMsg.BaseInfo.Uin = self.Session.Uin
+	receiveMsg.BaseInfo.UserName = self.Session.MyUserName
+	receiveMsg.BaseInfo.WechatNick = self.Session.MyNickName
+	receiveMsg.BaseInfo.FromNickName = uf.RemarkName
+	receiveMsg.BaseInfo.FromUserName = uf.UserName
+	receiveMsg.BaseInfo.ReceiveEvent = RECEIVE_EVENT_FRIEND_UPDATE
+	receiveMsg.BaseInfo.FromType = FROM_TYPE_PEOPLE
+	receiveMsg.AddFriend.UserWechat = uf.Alias
+	receiveMsg.AddFriend.UserNick = uf.RemarkName
+	receiveMsg.AddFriend.UserCity = uf.City
+	receiveMsg.AddFriend.UserSex = uf.Sex
+	receiveMsg.FriendChanges = changes
+	self.wxh.ReceiveMsg(receiveMsg)
+}
+
 func (self *WxWeb) getMsgImgUrl(msgId string) string {
 	return fmt.Sprintf("%s/webwxgetmsgimg?MsgID=%s&skey=%s", self.Session.BaseUri, msgId, url.QueryEscape(self.Session.SKey))
 }
@@ -470,11 +511,10 @@ func (self *WxWeb) getBigContactList(usernameList []string) {
 				}
 			}
 		} else {
-			_, ok := self.Contact.Friends[v]
-			if !ok {
+			if self.Contact.GetFriend(v) == nil {
 				needGetList = append(needGetList, v)
 				if len(needGetList) >= 50 {
-					ok = self.webwxbatchgetcontact(needGetList)
+					ok := self.webwxbatchgetcontact(needGetList)
 					if !ok {
 						logrus.Errorf("webwxbatchgetcontact get error for [%v].", needGetList)
 					}
@@ -25,14 +25,16 @@ import (
 type StartWxArgv struct {
 	RobotType int `json:"robotType"`
 
-	IfInvite           bool   `json:"ifInvite,omitempty"`
-	IfInviteEndExit    bool   `json:"inviteEndExit,omitempty"`
-	InviteMsg          string `json:"inviteMsg,omitempty"`
-	IfClearWx          bool   `json:"ifClearWx,omitempty"`
-	ClearWxMsg         string `json:"clearWxMsg,omitempty"`
-	ClearWxPrefix      string `json:"clearWxPrefix,omitempty"`
-	IfSaveRobotFriends bool   `json:"ifSaveRobotFriends,omitempty"`
-	IfSaveRobotGroups  bool   `json:"ifSaveRobotGroups,omitempty"`
+	IfInvite        bool   `json:"ifInvite,omitempty"`
+	IfInviteEndExit bool   `json:"inviteEndExit,omitempty"`
+	InviteMsg       string `json:"inviteMsg,omitempty"`
+	// 拉群活动配置, 为空时按旧的默认行为
+	InviteCampaign     *InviteCampaignConf `json:"inviteCampaign,omitempty"`
+	IfClearWx          bool                `json:"ifClearWx,omitempty"`
+	ClearWxMsg         string              `json:"clearWxMsg,omitempty"`
+	ClearWxPrefix      string              `json:"clearWxPrefix,omitempty"`
+	IfSaveRobotFriends bool                `json:"ifSaveRobotFriends,omitempty"`
+	IfSaveRobotGroups  bool                `json:"ifSaveRobotGroups,omitempty"`
 	// 是否替换emoji
 	IfNotReplaceEmoji bool `json:"ifNotReplaceEmoji,omitempty"`
 	// 建群逻辑
@@ -43,10 +45,13 @@ type StartWxArgv struct {
 	CreateGroupUsers  []string `json:"createGroupUsers,omitempty"`
 	// 不准修改群名
 	IfNotChangeGroupName bool `json:"ifNotChangeGroupName,omitempty"`
+	// 群管: 按db中的群规则警告和踢人
+	IfGroupModeration bool `json:"ifGroupModeration,omitempty"`
 	// 群加人逻辑
-	IfSaveGroupMember         bool  `json:"ifSaveGroupMember,omitempty"`
-	AddGroupMemberCycleOfTime int64 `json:"addGroupMemberCycleOfTime,omitempty"`
-	AddGroupMemberCycleOfNum  int64 `json:"addGroupMemberCycleOfNum,omitempty"`
+	IfSaveGroupMember         bool                `json:"ifSaveGroupMember,omitempty"`
+	AddGroupMemberCycleOfTime int64               `json:"addGroupMemberCycleOfTime,omitempty"`
+	AddGroupMemberCycleOfNum  int64               `json:"addGroupMemberCycleOfNum,omitempty"`
+	AddGroupMemberConf        *AddGroupMemberConf `json:"addGroupMemberConf,omitempty"`
 }
 
 func (self *WxWeb) _run(desc string, f func(...interface{}) bool, args ...interface{}) bool {
@@ -102,6 +107,9 @@ func (self *WxWeb) _init() {
 	self.Session.DeviceId = "e" + str[2:17]
 	self.Contact = NewUserContact(self)
 	self.agml = NewAddGroupMember(self.Contact, self)
+	self.gm = NewGroupModerator(self)
+	self.dfs = NewDeadFriendScan(self)
+	self.jobs = NewJobManager(self)
 }
 
 func (self *WxWeb) getUuid(args ...interface{}) bool {
@@ -255,6 +263,8 @@ func (self *WxWeb) Start() {
 		self.argv = &StartWxArgv{}
 	}
 
+	self.applyRole()
+
 	self.startTime = time.Now().Unix()
 
 	self.Lock()
@@ -278,6 +288,7 @@ func (self *WxWeb) quickLogin(robot *models.Robot) bool {
 		logrus.Debugf("[quick login] error, maybe need login with scan.")
 		return false
 	}
+	self.applyRole()
 	logrus.Infof("[*] 正在快速登录微信, 初始化 ... ")
 	ok = self.wechatInit()
 	if !ok {
@@ -342,21 +353,29 @@ func (self *WxWeb) wechatInit() bool {
 }
 
 func (self *WxWeb) wechatLoop() {
-	if self.argv.IfInvite {
-		go self.Contact.InviteMembers()
-	}
-	if self.argv.IfClearWx {
-		go self.Contact.ClearWx()
-	}
-	if self.argv.IfSaveRobotFriends {
-		go self.Contact.SaveRobotFriends()
-	}
-	if self.argv.IfSaveRobotGroups {
-		go self.Contact.SaveRobotGroups()
-	}
-	if self.argv.IfCreateGroup {
-		go self.Contact.CreateGroups()
+	// 先恢复上次登录未完成的任务, 再按argv启动
+	self.jobs.restore()
+	argvJobs := []struct {
+		enable bool
+		name   string
+	}{
+		{self.argv.IfInvite, JOB_INVITE_MEMBERS},
+		{self.argv.IfClearWx, JOB_DEAD_FRIEND_SCAN},
+		{self.argv.IfSaveRobotFriends, JOB_SAVE_ROBOT_FRIENDS},
+		{self.argv.IfSaveRobotGroups, JOB_SAVE_ROBOT_GROUPS},
+		{self.argv.IfCreateGroup, JOB_CREATE_GROUPS},
+		{self.argv.IfSaveGroupMember, JOB_ADD_GROUP_MEMBER},
+	}
+	for _, v := range argvJobs {
+		if !v.enable || self.jobs.IsRunning(v.name) {
+			continue
+		}
+		if _, err := self.jobs.Start(v.name, nil); err != nil {
+			logrus.Errorf("wx[%s] start job[%s] error: %v", self.Session.MyNickName, v.name, err)
+		}
 	}
+	self.startRoleJobs()
+	defer self.jobs.stopAll()
 
 	//self.testUploadMedia()
 	//self.Contact.PrintGroupInfo()
@@ -0,0 +1,101 @@
+package wxweb
+
+import (
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/reechou/wxrobot/models"
+)
+
+func (self *WxWeb) ifArchiveMsg() bool {
+	return self.cfg != nil && self.cfg.IfNeedOwnerDB && self.cfg.MsgArchive.IfArchive
+}
+
+// chatInfo returns the chat type and name of a group or friend username.
+func (self *WxWeb) chatInfo(userName string) (string, string) {
+	if strings.HasPrefix(userName, GROUP_PREFIX) {
+		if ug := self.Contact.GetGroup(userName); ug != nil {
+			return FROM_TYPE_GROUP, ug.GetNickName()
+		}
+		return FROM_TYPE_GROUP, ""
+	}
+	if uf := self.Contact.GetFriend(userName); uf != nil {
+		return FROM_TYPE_PEOPLE, uf.RemarkName
+	}
+	return FROM_TYPE_PEOPLE, ""
+}
+
+// archiveSendMsg records a message the robot sent through webwx,
+// res is the webwx response which carries the MsgID.
+func (self *WxWeb) archiveSendMsg(toUserName, msgType, content, mediaRef, res string) {
+	if !self.ifArchiveMsg() {
+		return
+	}
+	chatType, chatName := self.chatInfo(toUserName)
+	m := &models.RobotMessage{
+		RobotWx:        self.Session.MyNickName,
+		ChatType:       chatType,
+		ChatUserName:   toUserName,
+		ChatName:       chatName,
+		Direction:      MSG_DIRECTION_OUT,
+		SenderUserName: self.Session.MyUserName,
+		SenderNick:     self.Session.MyNickName,
+		MsgType:        msgType,
+		Content:        content,
+		MediaRef:       mediaRef,
+	}
+	if data, ok := CheckWebwxResData(res); ok {
+		m.WxMsgId, _ = data["MsgID"].(string)
+	}
+	if err := models.CreateRobotMessage(m); err != nil {
+		logrus.Errorf("wx[%s] archive send msg error: %v", self.Session.MyNickName, err)
+	}
+}
+
+// archiveReceiveMsg records a message from the sync loop, including the ones
+// the robot sent from other devices.
+func (self *WxWeb) archiveReceiveMsg(receiveMsg *ReceiveMsgInfo, wxMsgId, content, mediaRef string) {
+	if !self.ifArchiveMsg() {
+		return
+	}
+	m := &models.RobotMessage{
+		RobotWx:   self.Session.MyNickName,
+		Direction: MSG_DIRECTION_IN,
+		MsgType:   receiveMsg.MsgType,
+		Content:   content,
+		MediaRef:  mediaRef,
+		WxMsgId:   wxMsgId,
+	}
+	switch {
+	case receiveMsg.BaseInfo.FromType == FROM_TYPE_GROUP:
+		m.ChatType = FROM_TYPE_GROUP
+		m.ChatUserName = receiveMsg.BaseInfo.FromUserName
+		m.ChatName = receiveMsg.BaseInfo.FromGroupName
+		m.SenderUserName = receiveMsg.BaseInfo.FromMemberUserName
+		m.SenderNick = receiveMsg.BaseInfo.FromNickName
+	case receiveMsg.BaseInfo.FromUserName == self.Session.MyUserName:
+		// 发送成功时已存过的不再重复存
+		has, err := models.HasRobotMessage(self.Session.MyNickName, wxMsgId)
+		if err != nil {
+			logrus.Errorf("wx[%s] check archived msg error: %v", self.Session.MyNickName, err)
+			return
+		}
+		if has {
+			return
+		}
+		m.Direction = MSG_DIRECTION_OUT
+		m.ChatUserName = receiveMsg.BaseToUserInfo.ToUserName
+		m.ChatType, m.ChatName = self.chatInfo(m.ChatUserName)
+		m.SenderUserName = self.Session.MyUserName
+		m.SenderNick = self.Session.MyNickName
+	default:
+		m.ChatType = FROM_TYPE_PEOPLE
+		m.ChatUserName = receiveMsg.BaseInfo.FromUserName
+		m.ChatName = receiveMsg.BaseInfo.FromNickName
+		m.SenderUserName = receiveMsg.BaseInfo.FromUserName
+		m.SenderNick = receiveMsg.BaseInfo.FromNickName
+	}
+	if err := models.CreateRobotMessage(m); err != nil {
+		logrus.Errorf("wx[%s] archive receive msg error: %v", self.Session.MyNickName, err)
+	}
+}
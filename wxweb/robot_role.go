@@ -0,0 +1,148 @@
+package wxweb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// RobotRole bundles the behaviour of the robots with the RobotType, so a
+// robot started or resumed with the type needs no repeated argv flags.
+type RobotRole struct {
+	RobotType int    `json:"robotType"`
+	Name      string `json:"name"`
+	// 默认启动参数, 启动时传入的非空参数优先
+	Argv json.RawMessage `json:"argv,omitempty"`
+	// 登录后启动的任务, 任务名 -> 任务参数
+	Jobs map[string]json.RawMessage `json:"jobs,omitempty"`
+	// 同事件文件的filter行, 其中的微信昵称不生效, 对该类型的所有机器人生效
+	Events []string `json:"events,omitempty"`
+	// 允许调用的接口路径, 如/sendmsgs, 为空不限制
+	AllowOps []string `json:"allowOps,omitempty"`
+}
+
+var (
+	robotRoleMutex sync.Mutex
+	robotRoles     = make(map[int]*RobotRole)
+)
+
+// LoadRobotRoles loads the role profiles from the json file, the loaded
+// roles are kept when the file is broken.
+func LoadRobotRoles(file string) error {
+	if file == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("read robot role file[%s] error: %v", file, err)
+	}
+	var list []RobotRole
+	if err = json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("robot role file[%s] json decode error: %v", file, err)
+	}
+	roles := make(map[int]*RobotRole)
+	for i := range list {
+		role := &list[i]
+		if len(role.Argv) != 0 {
+			if err = json.Unmarshal(role.Argv, &StartWxArgv{}); err != nil {
+				return fmt.Errorf("robot role[%d][%s] argv json decode error: %v", role.RobotType, role.Name, err)
+			}
+		}
+		for name := range role.Jobs {
+			if _, ok := jobFactories[name]; !ok {
+				return fmt.Errorf("robot role[%d][%s] unknown job[%s]", role.RobotType, role.Name, name)
+			}
+		}
+		roles[role.RobotType] = role
+	}
+
+	robotRoleMutex.Lock()
+	robotRoles = roles
+	robotRoleMutex.Unlock()
+	logrus.Infof("load robot role file[%s] success, %d roles.", file, len(roles))
+
+	return nil
+}
+
+func GetRobotRole(robotType int) *RobotRole {
+	robotRoleMutex.Lock()
+	defer robotRoleMutex.Unlock()
+
+	return robotRoles[robotType]
+}
+
+func RobotRoles() []*RobotRole {
+	robotRoleMutex.Lock()
+	defer robotRoleMutex.Unlock()
+
+	list := make([]*RobotRole, 0, len(robotRoles))
+	for _, v := range robotRoles {
+		list = append(list, v)
+	}
+	return list
+}
+
+// Allow checks whether the robot of the role may be called by the api path.
+func (self *RobotRole) Allow(op string) bool {
+	if len(self.AllowOps) == 0 {
+		return true
+	}
+	for _, v := range self.AllowOps {
+		if v == op {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeArgv fills the empty fields of argv with the defaults of the role.
+func (self *RobotRole) mergeArgv(argv *StartWxArgv) (*StartWxArgv, error) {
+	merged := &StartWxArgv{}
+	if len(self.Argv) != 0 {
+		if err := json.Unmarshal(self.Argv, merged); err != nil {
+			return nil, err
+		}
+	}
+	// 启动参数都是omitempty, 只覆盖传入的非空值
+	data, err := json.Marshal(argv)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, merged); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// applyRole merges the role defaults into the argv before login.
+func (self *WxWeb) applyRole() {
+	role := GetRobotRole(self.argv.RobotType)
+	if role == nil {
+		return
+	}
+	argv, err := role.mergeArgv(self.argv)
+	if err != nil {
+		logrus.Errorf("robot type[%d] merge role[%s] argv error: %v", self.argv.RobotType, role.Name, err)
+		return
+	}
+	self.argv = argv
+}
+
+// startRoleJobs starts the jobs of the role which are not running.
+func (self *WxWeb) startRoleJobs() {
+	role := GetRobotRole(self.argv.RobotType)
+	if role == nil {
+		return
+	}
+	for name, argv := range role.Jobs {
+		if self.jobs.IsRunning(name) {
+			continue
+		}
+		if _, err := self.jobs.Start(name, argv); err != nil {
+			logrus.Errorf("wx[%s] start role[%s] job[%s] error: %v", self.Session.MyNickName, role.Name, name, err)
+		}
+	}
+}
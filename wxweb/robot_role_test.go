@@ -0,0 +1,33 @@
+package wxweb
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRobotRoleMergeArgv(t *testing.T) {
+	role := &RobotRole{
+		RobotType: 1,
+		Argv:      json.RawMessage(`{"ifInvite":true,"inviteMsg":"role","ifSaveRobotGroups":true}`),
+	}
+	argv, err := role.mergeArgv(&StartWxArgv{RobotType: 1, InviteMsg: "start", IfClearWx: true})
+	if err != nil {
+		t.Fatalf("merge argv error: %v", err)
+	}
+	if argv.RobotType != 1 || !argv.IfInvite || !argv.IfSaveRobotGroups || !argv.IfClearWx {
+		t.Errorf("merged argv %+v lost options", argv)
+	}
+	if argv.InviteMsg != "start" {
+		t.Errorf("start argv should win, got inviteMsg %q", argv.InviteMsg)
+	}
+}
+
+func TestRobotRoleAllow(t *testing.T) {
+	if !(&RobotRole{}).Allow("/sendmsgs") {
+		t.Errorf("role without allowOps should allow all")
+	}
+	role := &RobotRole{AllowOps: []string{"/sendmsgs"}}
+	if !role.Allow("/sendmsgs") || role.Allow("/grouptiren") {
+		t.Errorf("role allowOps %v not applied", role.AllowOps)
+	}
+}
@@ -25,6 +25,7 @@ type SendBaseInfo struct {
 	UserName   string `json:"userName,omitempty"`
 	MsgType    string `json:"msgType,omitempty"`
 	Msg        string `json:"msg,omitempty"`
+	Label      string `json:"label,omitempty"` // 不为空时发给有该标签的所有好友
 }
 
 type RetResponse struct {
@@ -40,7 +41,15 @@ type AddFriend struct {
 	UserNick     string `json:"userNick,omitempty"`
 	UserCity     string `json:"userCity,omitempty"`
 	UserSex      int    `json:"userSex,omitempty"`
-	Ticket       string `json:"-"` // for verify
+	// 好友验证消息
+	VerifyContent string `json:"verifyContent,omitempty"`
+	Ticket        string `json:"-"` // for verify
+}
+
+type FriendChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
 }
 
 type ReceiveMsgInfo struct {
@@ -52,6 +61,11 @@ type ReceiveMsgInfo struct {
 	Msg            string `json:"msg,omitempty"`
 	MediaTempUrl   string `json:"mediaTempUrl,omitempty"`
 	GroupMemberNum int    `json:"groupMemberNum,omitempty"`
+	IsGroupOwner   bool   `json:"isGroupOwner,omitempty"`
+	// 分享/appmsg等媒体消息, 只存档和统计, 不触发事件
+	IsMedia bool `json:"isMedia,omitempty"`
+
+	FriendChanges []FriendChange `json:"friendChanges,omitempty"`
 }
 
 type CallbackMsgInfo struct {
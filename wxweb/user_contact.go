@@ -1,6 +1,7 @@
 package wxweb
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
 	"os"
@@ -26,6 +27,8 @@ type WxGroup struct {
 	NickName       string `json:"nickname"`
 	UserName       string `json:"username"`
 	GroupMemberNum int    `json:"groupMemberNum"`
+	OwnerUserName  string `json:"ownerUserName,omitempty"`
+	IsOwner        bool   `json:"isOwner"`
 }
 
 type UserFriend struct {
@@ -37,6 +40,7 @@ type UserFriend struct {
 	RemarkName  string `json:"remarkName"`
 	Sex         int    `json:"sex"`
 	UserName    string `json:"userName"`
+	Signature   string `json:"signature,omitempty"`
 }
 
 type GroupUserInfo struct {
@@ -46,11 +50,11 @@ type GroupUserInfo struct {
 	Sex         int    `json:"sex"`
 }
 type MsgInfo struct {
-	MsgID    int
-	WXMsgId  string
-	NickName string
-	UserName string
-	Content  string
+	MsgID    int    `json:"msgId"`
+	WXMsgId  string `json:"wxMsgId"`
+	NickName string `json:"nickname"`
+	UserName string `json:"username"`
+	Content  string `json:"content"`
 }
 type MsgOffset struct {
 	SliceStart int
@@ -60,77 +64,198 @@ type MsgOffset struct {
 }
 type UserGroup struct {
 	sync.Mutex
-	ContactFlag int
-	NickName    string
-	UserName    string
+	UserName string
+
+	infoMutex     sync.Mutex
+	contactFlag   int
+	nickName      string
+	ownerUserName string
+	expectTopic   string // 机器人自己改的群名, 收到后不回滚
 
 	memberMutex        sync.Mutex
-	MemberList         map[string]*GroupUserInfo
-	NickMemberList     map[string]*GroupUserInfo
-	OriginalMemberList []*GroupUserInfo
+	memberList         map[string]*GroupUserInfo
+	nickMemberList     map[string]*GroupUserInfo
+	originalMemberList []*GroupUserInfo
+	// 成员首次出现和最后发言的时间, 从机器人看到该群开始记录
+	memberSeenTime    map[string]int64
+	memberLastMsgTime map[string]int64
 
 	wx *WxWeb
 
-	offset *MsgOffset
-	msgs   []*MsgInfo
-	msgId  int
+	// 消息环形缓冲, 由Mutex保护, msgNotify在每次AppendMsg时关闭以唤醒等待者
+	offset    *MsgOffset
+	msgs      []*MsgInfo
+	msgId     int
+	msgNotify chan struct{}
 
-	LastMsg     string
-	LastMsgTime int64
+	createTime  int64
+	lastMsg     string
+	lastMsgTime int64
 }
 
 func NewUserGroup(contactFlag int, nickName, userName string, wx *WxWeb) *UserGroup {
 	return &UserGroup{
-		ContactFlag:    contactFlag,
-		NickName:       nickName,
-		UserName:       userName,
-		MemberList:     make(map[string]*GroupUserInfo),
-		NickMemberList: make(map[string]*GroupUserInfo),
+		contactFlag:       contactFlag,
+		nickName:          nickName,
+		UserName:          userName,
+		memberList:        make(map[string]*GroupUserInfo),
+		nickMemberList:    make(map[string]*GroupUserInfo),
+		memberSeenTime:    make(map[string]int64),
+		memberLastMsgTime: make(map[string]int64),
 		offset: &MsgOffset{
 			SliceStart: -1,
 			SliceEnd:   -1,
 			MsgIDStart: -1,
 			MsgIDEnd:   -1,
 		},
-		msgs: make([]*MsgInfo, MSG_LEN),
-		wx:   wx,
+		msgs:       make([]*MsgInfo, MSG_LEN),
+		msgNotify:  make(chan struct{}),
+		createTime: time.Now().Unix(),
+		wx:         wx,
 	}
 }
 
+func (self *UserGroup) GetNickName() string {
+	self.infoMutex.Lock()
+	defer self.infoMutex.Unlock()
+
+	return self.nickName
+}
+
+func (self *UserGroup) SetNickName(nickName string) {
+	self.infoMutex.Lock()
+	defer self.infoMutex.Unlock()
+
+	self.nickName = nickName
+}
+
+func (self *UserGroup) SetExpectTopic(topic string) {
+	self.infoMutex.Lock()
+	defer self.infoMutex.Unlock()
+
+	self.expectTopic = topic
+}
+
+// TakeExpectTopic reports whether topic is the name the robot itself set,
+// and clears it.
+func (self *UserGroup) TakeExpectTopic(topic string) bool {
+	self.infoMutex.Lock()
+	defer self.infoMutex.Unlock()
+
+	if self.expectTopic == "" || self.expectTopic != topic {
+		return false
+	}
+	self.expectTopic = ""
+	return true
+}
+
+func (self *UserGroup) GetContactFlag() int {
+	self.infoMutex.Lock()
+	defer self.infoMutex.Unlock()
+
+	return self.contactFlag
+}
+
+func (self *UserGroup) SetContactFlag(contactFlag int) {
+	self.infoMutex.Lock()
+	defer self.infoMutex.Unlock()
+
+	self.contactFlag = contactFlag
+}
+
+// SetOwner records the group owner, an empty owner keeps the known one.
+func (self *UserGroup) SetOwner(ownerUserName string) {
+	if ownerUserName == "" {
+		return
+	}
+
+	self.infoMutex.Lock()
+	defer self.infoMutex.Unlock()
+
+	self.ownerUserName = ownerUserName
+}
+
+func (self *UserGroup) GetOwner() string {
+	self.infoMutex.Lock()
+	defer self.infoMutex.Unlock()
+
+	return self.ownerUserName
+}
+
+// IsOwner reports whether the robot owns the group.
+func (self *UserGroup) IsOwner() bool {
+	owner := self.GetOwner()
+	return owner != "" && owner == self.wx.Session.MyUserName
+}
+
+// CheckOwner fails when the group owner is known and it is not the robot,
+// for operations only the owner may do (e.g. remove members).
+func (self *UserGroup) CheckOwner() error {
+	owner := self.GetOwner()
+	if owner != "" && owner != self.wx.Session.MyUserName {
+		return fmt.Errorf("robot[%s] is not the owner of group[%s], owner is [%s]", self.wx.Session.MyNickName, self.GetNickName(), owner)
+	}
+	return nil
+}
+
+func (self *UserGroup) WxGroup() WxGroup {
+	return WxGroup{
+		NickName:       self.GetNickName(),
+		UserName:       self.UserName,
+		GroupMemberNum: self.GetGroupMemberLen(),
+		OwnerUserName:  self.GetOwner(),
+		IsOwner:        self.IsOwner(),
+	}
+}
+
+// ModMember reports the members not in the old member list as joined. A group
+// first seen (e.g. after relogin) has no old member list, nobody is reported.
 func (self *UserGroup) ModMember(memberList map[string]*GroupUserInfo) {
 	self.memberMutex.Lock()
-	defer self.memberMutex.Unlock()
-
-	//logrus.Debugf("old member: %v", self.MemberList)
-	//logrus.Debugf("mod member: %v", memberList)
+	if len(self.memberList) == 0 {
+		self.memberMutex.Unlock()
+		return
+	}
+	var newMembers []*GroupUserInfo
 	for k, v := range memberList {
-		_, ok := self.MemberList[k]
+		_, ok := self.memberList[k]
 		if !ok {
-			receiveMsg := &ReceiveMsgInfo{}
-			receiveMsg.BaseInfo.Uin = self.wx.Session.Uin
-			receiveMsg.BaseInfo.UserName = self.wx.Session.MyUserName
-			receiveMsg.BaseInfo.WechatNick = self.wx.Session.MyNickName
-			receiveMsg.BaseInfo.FromGroupName = self.NickName
-			receiveMsg.BaseInfo.FromNickName = v.NickName
-			receiveMsg.BaseInfo.FromUserName = self.UserName
-			receiveMsg.BaseInfo.FromMemberUserName = v.UserName
-			receiveMsg.BaseInfo.ReceiveEvent = RECEIVE_EVENT_MOD_GROUP_ADD_DETAIL
-			receiveMsg.BaseInfo.FromType = FROM_TYPE_GROUP
-			receiveMsg.GroupMemberNum = len(memberList)
-			self.wx.wxh.ReceiveMsg(receiveMsg)
+			newMembers = append(newMembers, v)
 		}
 	}
+	self.memberMutex.Unlock()
+
+	// report outside the member lock, the handler may call back into the group
+	groupNickName := self.GetNickName()
+	isOwner := self.IsOwner()
+	for _, v := range newMembers {
+		receiveMsg := &ReceiveMsgInfo{}
+		receiveMsg.BaseInfo.Uin = self.wx.Session.Uin
+		receiveMsg.BaseInfo.UserName = self.wx.Session.MyUserName
+		receiveMsg.BaseInfo.WechatNick = self.wx.Session.MyNickName
+		receiveMsg.BaseInfo.FromGroupName = groupNickName
+		receiveMsg.BaseInfo.FromNickName = v.NickName
+		receiveMsg.BaseInfo.FromUserName = self.UserName
+		receiveMsg.BaseInfo.FromMemberUserName = v.UserName
+		receiveMsg.BaseInfo.ReceiveEvent = RECEIVE_EVENT_MOD_GROUP_ADD_DETAIL
+		receiveMsg.BaseInfo.FromType = FROM_TYPE_GROUP
+		receiveMsg.GroupMemberNum = len(memberList)
+		receiveMsg.IsGroupOwner = isOwner
+		self.wx.wxh.ReceiveMsg(receiveMsg)
+	}
 }
 
 func (self *UserGroup) DelMember(username string) {
 	self.memberMutex.Lock()
 	defer self.memberMutex.Unlock()
 
-	gui := self.MemberList[username]
+	gui := self.memberList[username]
 	if gui != nil {
-		logrus.Debugf("usergroup[%s] del member[%s][%s]", self.NickName, username, gui.NickName)
-		delete(self.NickMemberList, gui.NickName)
-		delete(self.MemberList, username)
+		logrus.Debugf("usergroup[%s] del member[%s][%s]", self.GetNickName(), username, gui.NickName)
+		delete(self.nickMemberList, gui.NickName)
+		delete(self.memberList, username)
+		delete(self.memberSeenTime, username)
+		delete(self.memberLastMsgTime, username)
 	}
 }
 
@@ -139,64 +264,117 @@ func (self *UserGroup) FindMember(username, nickname string) *GroupUserInfo {
 	defer self.memberMutex.Unlock()
 
 	if username != "" {
-		gui := self.MemberList[username]
+		gui := self.memberList[username]
 		if gui != nil {
 			return gui
 		}
 	}
 
-	return self.NickMemberList[nickname]
+	return self.nickMemberList[nickname]
 }
 
 func (self *UserGroup) SetMemberList(memberList, nickMemberList map[string]*GroupUserInfo, originalMemberList []*GroupUserInfo) {
 	self.memberMutex.Lock()
 	defer self.memberMutex.Unlock()
 
-	self.MemberList = memberList
-	self.NickMemberList = nickMemberList
-	self.OriginalMemberList = originalMemberList
+	now := time.Now().Unix()
+	seenTime := make(map[string]int64, len(memberList))
+	lastMsgTime := make(map[string]int64)
+	for k := range memberList {
+		if t, ok := self.memberSeenTime[k]; ok {
+			seenTime[k] = t
+		} else {
+			seenTime[k] = now
+		}
+		if t, ok := self.memberLastMsgTime[k]; ok {
+			lastMsgTime[k] = t
+		}
+	}
+	self.memberSeenTime = seenTime
+	self.memberLastMsgTime = lastMsgTime
+
+	self.memberList = memberList
+	self.nickMemberList = nickMemberList
+	self.originalMemberList = originalMemberList
 }
 
+// GetMemberList returns a copy of the member map, safe to iterate without the group lock.
 func (self *UserGroup) GetMemberList() map[string]*GroupUserInfo {
 	self.memberMutex.Lock()
 	defer self.memberMutex.Unlock()
 
-	return self.MemberList
+	list := make(map[string]*GroupUserInfo, len(self.memberList))
+	for k, v := range self.memberList {
+		list[k] = v
+	}
+	return list
+}
+
+// MembersSnapshot returns copies of all group members.
+func (self *UserGroup) MembersSnapshot() []GroupUserInfo {
+	self.memberMutex.Lock()
+	defer self.memberMutex.Unlock()
+
+	list := make([]GroupUserInfo, 0, len(self.memberList))
+	for _, v := range self.memberList {
+		list = append(list, *v)
+	}
+	return list
 }
 
 func (self *UserGroup) GetOriginalMemberList() []*GroupUserInfo {
 	self.memberMutex.Lock()
 	defer self.memberMutex.Unlock()
 
-	return self.OriginalMemberList
+	list := make([]*GroupUserInfo, len(self.originalMemberList))
+	copy(list, self.originalMemberList)
+	return list
 }
 
 func (self *UserGroup) GetMemberFromList(username string) *GroupUserInfo {
 	self.memberMutex.Lock()
 	defer self.memberMutex.Unlock()
 
-	return self.MemberList[username]
+	return self.memberList[username]
 }
 
 func (self *UserGroup) GetMemberFromNickList(nickname string) *GroupUserInfo {
 	self.memberMutex.Lock()
 	defer self.memberMutex.Unlock()
 
-	return self.MemberList[nickname]
+	return self.nickMemberList[nickname]
 }
 
 func (self *UserGroup) GetGroupMemberLen() int {
 	self.memberMutex.Lock()
 	defer self.memberMutex.Unlock()
 
-	return len(self.MemberList)
+	return len(self.memberList)
+}
+
+// markActive records that username just spoke in the group, media messages
+// which are not buffered count as well.
+func (self *UserGroup) markActive(username string) {
+	now := time.Now().Unix()
+	if username != "" {
+		self.memberMutex.Lock()
+		self.memberLastMsgTime[username] = now
+		self.memberMutex.Unlock()
+	}
+
+	self.Lock()
+	self.lastMsgTime = now
+	self.Unlock()
 }
 
 func (self *UserGroup) AppendMsg(msg *MsgInfo) {
+	self.markActive(msg.UserName)
+
 	self.Lock()
 	defer self.Unlock()
 
 	msg.MsgID = self.msgId
+	self.lastMsg = msg.Content
 
 	if self.offset.SliceStart == -1 && self.offset.SliceEnd == -1 && self.offset.MsgIDStart == -1 && self.offset.MsgIDEnd == -1 {
 		self.msgs[0] = msg
@@ -218,27 +396,94 @@ func (self *UserGroup) AppendMsg(msg *MsgInfo) {
 	//logrus.Debugf("group[%s] add msg[%v] offset[%v]", self.UserName, msg, self.offset)
 
 	self.msgId++
+	close(self.msgNotify)
+	self.msgNotify = make(chan struct{})
 }
 
-func (self *UserGroup) GetMsgList(msgId int) []*MsgInfo {
-	if msgId >= self.offset.MsgIDEnd {
-		return nil
+// GetLastMsg returns the content and unix time of the last message seen in the
+// group, the time is 0 if nobody has spoken since the robot logged in.
+func (self *UserGroup) GetLastMsg() (string, int64) {
+	self.Lock()
+	defer self.Unlock()
+
+	return self.lastMsg, self.lastMsgTime
+}
+
+// GetCreateTime returns when the robot started tracking the group.
+func (self *UserGroup) GetCreateTime() int64 {
+	return self.createTime
+}
+
+// SilentMembers returns the members who have not said anything since they were
+// first seen, and were first seen before the given unix time.
+func (self *UserGroup) SilentMembers(seenBefore int64) []GroupUserInfo {
+	self.memberMutex.Lock()
+	defer self.memberMutex.Unlock()
+
+	var list []GroupUserInfo
+	for k, v := range self.memberList {
+		if _, ok := self.memberLastMsgTime[k]; ok {
+			continue
+		}
+		if t, ok := self.memberSeenTime[k]; !ok || t > seenBefore {
+			continue
+		}
+		list = append(list, *v)
 	}
-	jump := 0
-	if msgId > self.offset.MsgIDStart {
-		jump = msgId - self.offset.MsgIDStart
+	return list
+}
+
+// GetMsgList returns copies of the buffered messages after lastMsgId, a negative
+// lastMsgId reads from the oldest one. lost reports that messages after
+// lastMsgId have fallen off the buffer (or the ids restarted with a new login),
+// the list then starts from the oldest buffered message.
+func (self *UserGroup) GetMsgList(lastMsgId int) ([]MsgInfo, bool) {
+	self.Lock()
+	defer self.Unlock()
+
+	return self.getMsgList(lastMsgId)
+}
+
+func (self *UserGroup) getMsgList(lastMsgId int) ([]MsgInfo, bool) {
+	if self.offset.MsgIDEnd == -1 {
+		return nil, lastMsgId >= 0
 	}
-	start := self.offset.SliceStart
-	for i := 0; i < jump; i++ {
-		start = (start + 1) % MSG_LEN
+	from := lastMsgId + 1
+	lost := false
+	if lastMsgId >= 0 && (from < self.offset.MsgIDStart || lastMsgId > self.offset.MsgIDEnd) {
+		lost = true
+		from = self.offset.MsgIDStart
 	}
-	if start > self.offset.SliceEnd {
-		return append(self.msgs[start:], self.msgs[:self.offset.SliceEnd]...)
-	} else {
-		return self.msgs[start:self.offset.SliceEnd]
+	if from < self.offset.MsgIDStart {
+		from = self.offset.MsgIDStart
 	}
+	var list []MsgInfo
+	for id := from; id <= self.offset.MsgIDEnd; id++ {
+		idx := (self.offset.SliceStart + id - self.offset.MsgIDStart) % MSG_LEN
+		list = append(list, *self.msgs[idx])
+	}
+	return list, lost
+}
 
-	return nil
+// WaitMsgList is GetMsgList that waits up to timeout for new messages.
+func (self *UserGroup) WaitMsgList(lastMsgId int, timeout time.Duration) ([]MsgInfo, bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		self.Lock()
+		list, lost := self.getMsgList(lastMsgId)
+		notify := self.msgNotify
+		self.Unlock()
+		if len(list) != 0 || lost {
+			return list, lost
+		}
+		select {
+		case <-notify:
+		case <-timer.C:
+			return nil, false
+		}
+	}
 }
 
 type UserContact struct {
@@ -246,10 +491,14 @@ type UserContact struct {
 	friendMutex sync.Mutex
 
 	wx          *WxWeb
-	Friends     map[string]*UserFriend
-	NickFriends map[string]*UserFriend
-	Groups      map[string]*UserGroup
-	NickGroups  map[string]*UserGroup
+	friends     map[string]*UserFriend
+	nickFriends map[string]*UserFriend
+	groups      map[string]*UserGroup
+	nickGroups  map[string]*UserGroup
+
+	// 拉人进群串行执行, 被限频后在inviteLimitUntil之前不再拉人
+	inviteMutex      sync.Mutex
+	inviteLimitUntil int64
 
 	IfInviteMemberSuccess bool
 }
@@ -257,31 +506,34 @@ type UserContact struct {
 func NewUserContact(wx *WxWeb) *UserContact {
 	return &UserContact{
 		wx:          wx,
-		Friends:     make(map[string]*UserFriend),
-		NickFriends: make(map[string]*UserFriend),
-		Groups:      make(map[string]*UserGroup),
-		NickGroups:  make(map[string]*UserGroup),
+		friends:     make(map[string]*UserFriend),
+		nickFriends: make(map[string]*UserFriend),
+		groups:      make(map[string]*UserGroup),
+		nickGroups:  make(map[string]*UserGroup),
 	}
 }
 
+// ChangeFriend replaces the friend record instead of mutating it,
+// so pointers handed out earlier keep a consistent view.
 func (self *UserContact) ChangeFriend(username, remark string) {
 	self.friendMutex.Lock()
 	defer self.friendMutex.Unlock()
 
-	uf := self.Friends[username]
+	uf := self.friends[username]
 	if uf == nil {
 		logrus.Errorf("change friend not found [%s]", username)
 		return
 	}
-	nuf := self.NickFriends[uf.RemarkName]
-	if nuf != nil {
-		delete(self.NickFriends, uf.RemarkName)
+	if self.nickFriends[uf.RemarkName] == uf {
+		delete(self.nickFriends, uf.RemarkName)
 		logrus.Debugf("wx[%s] delete nick friend: %s", self.wx.Session.MyNickName, uf.RemarkName)
 	}
-	uf.RemarkName = remark
+	nuf := *uf
+	nuf.RemarkName = remark
 
-	self.NickFriends[remark] = uf
-	logrus.Debugf("wx[%s] add nick friend: %v", self.wx.Session.MyNickName, uf)
+	self.friends[username] = &nuf
+	self.nickFriends[remark] = &nuf
+	logrus.Debugf("wx[%s] add nick friend: %v", self.wx.Session.MyNickName, nuf)
 }
 
 func (self *UserContact) FindGroup(username, nickname string) *UserGroup {
@@ -289,19 +541,18 @@ func (self *UserContact) FindGroup(username, nickname string) *UserGroup {
 	defer self.groupMutex.Unlock()
 
 	if username != "" {
-		group := self.Groups[username]
+		group := self.groups[username]
 		if group != nil {
 			return group
 		}
 	}
 
-	return self.NickGroups[nickname]
+	return self.nickGroups[nickname]
 }
 
 func (self *UserContact) FindGroupUser(groupUsername, groupNickname, memberUsername, memberNickname string) (*UserGroup, *GroupUserInfo) {
 	ug := self.FindGroup(groupUsername, groupNickname)
 	if ug != nil {
-		//logrus.Debugf("ug: %v", ug.NickMemberList)
 		return ug, ug.FindMember(memberUsername, memberNickname)
 	}
 
@@ -312,28 +563,77 @@ func (self *UserContact) GetGroup(username string) *UserGroup {
 	self.groupMutex.Lock()
 	defer self.groupMutex.Unlock()
 
-	return self.Groups[username]
+	return self.groups[username]
 }
 
 func (self *UserContact) SetGroup(username string, ug *UserGroup) {
 	self.groupMutex.Lock()
 	defer self.groupMutex.Unlock()
 
-	self.Groups[username] = ug
+	self.groups[username] = ug
 }
 
 func (self *UserContact) GetNickGroup(nickname string) *UserGroup {
 	self.groupMutex.Lock()
 	defer self.groupMutex.Unlock()
 
-	return self.NickGroups[nickname]
+	return self.nickGroups[nickname]
 }
 
 func (self *UserContact) SetNickGroup(nickname string, ug *UserGroup) {
 	self.groupMutex.Lock()
 	defer self.groupMutex.Unlock()
 
-	self.NickGroups[nickname] = ug
+	self.nickGroups[nickname] = ug
+}
+
+// AddGroup registers the group under both its username and its current nickname.
+func (self *UserContact) AddGroup(ug *UserGroup) {
+	nickName := ug.GetNickName()
+
+	self.groupMutex.Lock()
+	defer self.groupMutex.Unlock()
+
+	self.groups[ug.UserName] = ug
+	if nickName != "" {
+		self.nickGroups[nickName] = ug
+	}
+}
+
+// RenameGroup changes the group nickname and moves its nickname index entry.
+func (self *UserContact) RenameGroup(ug *UserGroup, nickName string) {
+	oldNickName := ug.GetNickName()
+	ug.SetNickName(nickName)
+
+	self.groupMutex.Lock()
+	defer self.groupMutex.Unlock()
+
+	if self.nickGroups[oldNickName] == ug {
+		delete(self.nickGroups, oldNickName)
+	}
+	if nickName != "" {
+		self.nickGroups[nickName] = ug
+	}
+}
+
+func (self *UserContact) GroupNum() int {
+	self.groupMutex.Lock()
+	defer self.groupMutex.Unlock()
+
+	return len(self.groups)
+}
+
+// GroupsSnapshot returns the groups known at call time. The groups
+// themselves guard their own state, so the pointers are shared.
+func (self *UserContact) GroupsSnapshot() []*UserGroup {
+	self.groupMutex.Lock()
+	defer self.groupMutex.Unlock()
+
+	list := make([]*UserGroup, 0, len(self.groups))
+	for _, v := range self.groups {
+		list = append(list, v)
+	}
+	return list
 }
 
 func (self *UserContact) FindFriend(username, nickname string) *UserFriend {
@@ -341,69 +641,202 @@ func (self *UserContact) FindFriend(username, nickname string) *UserFriend {
 	defer self.friendMutex.Unlock()
 
 	if username != "" {
-		uf := self.Friends[username]
+		uf := self.friends[username]
 		if uf != nil {
 			return uf
 		}
 	}
 
-	return self.NickFriends[nickname]
+	return self.nickFriends[nickname]
 }
 
 func (self *UserContact) GetFriend(username string) *UserFriend {
 	self.friendMutex.Lock()
 	defer self.friendMutex.Unlock()
 
-	return self.Friends[username]
+	return self.friends[username]
 }
 
 func (self *UserContact) SetFriend(username string, uf *UserFriend) {
 	self.friendMutex.Lock()
 	defer self.friendMutex.Unlock()
 
-	self.Friends[username] = uf
+	self.friends[username] = uf
 }
 
 func (self *UserContact) GetNickFriend(nickname string) *UserFriend {
 	self.friendMutex.Lock()
 	defer self.friendMutex.Unlock()
 
-	return self.NickFriends[nickname]
+	return self.nickFriends[nickname]
 }
 
 func (self *UserContact) SetNickFriend(nickname string, uf *UserFriend) {
 	self.friendMutex.Lock()
 	defer self.friendMutex.Unlock()
 
-	self.NickFriends[nickname] = uf
+	self.nickFriends[nickname] = uf
 }
 
-func (self *UserContact) CreateGroups() {
-	if self.wx.argv.IfCreateGroup {
-		logrus.Debugf("wx[%s] create groups start.", self.wx.Session.MyNickName)
-		var usernameList []string
-		for _, v := range self.wx.argv.CreateGroupUsers {
-			uf := self.NickFriends[v]
-			if uf == nil {
-				logrus.Errorf("create groups error, uf[%s] not found", v)
-				return
-			}
-			usernameList = append(usernameList, uf.UserName)
+// AddFriend registers the friend under both its username and its remark name.
+func (self *UserContact) AddFriend(uf *UserFriend) {
+	self.friendMutex.Lock()
+	defer self.friendMutex.Unlock()
+
+	self.friends[uf.UserName] = uf
+	self.nickFriends[uf.RemarkName] = uf
+}
+
+// UpdateFriend replaces the friend record and moves its remark index entry.
+func (self *UserContact) UpdateFriend(uf *UserFriend) {
+	self.friendMutex.Lock()
+	defer self.friendMutex.Unlock()
+
+	old := self.friends[uf.UserName]
+	if old != nil && self.nickFriends[old.RemarkName] == old {
+		delete(self.nickFriends, old.RemarkName)
+	}
+	self.friends[uf.UserName] = uf
+	self.nickFriends[uf.RemarkName] = uf
+}
+
+func (self *UserContact) FriendNum() int {
+	self.friendMutex.Lock()
+	defer self.friendMutex.Unlock()
+
+	return len(self.friends)
+}
+
+// FriendsSnapshot returns copies of all friend records.
+func (self *UserContact) FriendsSnapshot() []UserFriend {
+	self.friendMutex.Lock()
+	defer self.friendMutex.Unlock()
+
+	list := make([]UserFriend, 0, len(self.friends))
+	for _, v := range self.friends {
+		list = append(list, *v)
+	}
+	return list
+}
+
+// 批量建群配置, 为空时用StartWxArgv中的值
+type CreateGroupsConf struct {
+	Prefix string   `json:"prefix"`
+	Start  int      `json:"start"`
+	Num    int      `json:"num"`
+	Users  []string `json:"users"`
+}
+
+func newCreateGroupsJob(wx *WxWeb, argv json.RawMessage) (JobFunc, error) {
+	conf := &CreateGroupsConf{
+		Prefix: wx.argv.CreateGroupPrefix,
+		Start:  wx.argv.CreateGroupStart,
+		Num:    wx.argv.CreateGroupNum,
+		Users:  wx.argv.CreateGroupUsers,
+	}
+	if len(argv) != 0 {
+		conf = &CreateGroupsConf{}
+		if err := decodeJobArgv(argv, conf); err != nil {
+			return nil, err
 		}
-		for i := 0; i < self.wx.argv.CreateGroupNum; i++ {
-			idx := self.wx.argv.CreateGroupStart + i
-			res, ok := self.wx.webwxcreatechatroom(usernameList, fmt.Sprintf("%s%d", self.wx.argv.CreateGroupPrefix, idx))
-			if !ok {
-				logrus.Errorf("create groups error")
-				return
-			}
-			if !CheckWebwxRetcode(res) {
-				logrus.Errorf("create groups error result: %s", res)
-				return
+	}
+	if conf.Num <= 0 {
+		return nil, fmt.Errorf("create groups num[%d] must be positive", conf.Num)
+	}
+	return func(ctx *JobContext) error {
+		return wx.Contact.CreateGroups(ctx, conf)
+	}, nil
+}
+
+func (self *UserContact) CreateGroups(ctx *JobContext, conf *CreateGroupsConf) error {
+	logrus.Debugf("wx[%s] create groups start.", self.wx.Session.MyNickName)
+	var usernameList []string
+	for _, v := range conf.Users {
+		uf := self.GetNickFriend(v)
+		if uf == nil {
+			return fmt.Errorf("create groups error, uf[%s] not found", v)
+		}
+		usernameList = append(usernameList, uf.UserName)
+	}
+	var groups []string
+	for i := 0; i < conf.Num; i++ {
+		if !ctx.Wait() {
+			return nil
+		}
+		idx := conf.Start + i
+		ug, err := self.CreateGroup(usernameList, fmt.Sprintf("%s%d", conf.Prefix, idx))
+		if err != nil {
+			return fmt.Errorf("create groups error: %v", err)
+		}
+		groups = append(groups, ug.GetNickName())
+		ctx.SetProgress(int64(i+1), int64(conf.Num))
+		ctx.SetResult(append([]string(nil), groups...))
+		if !ctx.Sleep(2 * time.Second) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// CreateGroup creates a group with the given friends and registers it at once,
+// without waiting for the contact sync.
+func (self *UserContact) CreateGroup(usernameList []string, topic string) (*UserGroup, error) {
+	if len(usernameList) < CREATE_GROUP_MIN_MEMBER {
+		return nil, fmt.Errorf("create group needs at least %d members", CREATE_GROUP_MIN_MEMBER)
+	}
+	res, ok := self.wx.webwxcreatechatroom(usernameList, topic)
+	if !ok {
+		return nil, fmt.Errorf("webwx create chatroom[%s] error", topic)
+	}
+	data, ok := CheckWebwxResData(res)
+	if !ok || !CheckWebwxRetcodeFromData(data) {
+		return nil, fmt.Errorf("webwx create chatroom[%s] error result: %s", topic, res)
+	}
+	chatRoomName, _ := data["ChatRoomName"].(string)
+	if chatRoomName == "" {
+		return nil, fmt.Errorf("webwx create chatroom[%s] has no chatroom name: %s", topic, res)
+	}
+
+	memberListMap := make(map[string]*GroupUserInfo)
+	nickMemberListMap := make(map[string]*GroupUserInfo)
+	var originalMemberList []*GroupUserInfo
+	addMember := func(gui *GroupUserInfo) {
+		memberListMap[gui.UserName] = gui
+		nickMemberListMap[gui.NickName] = gui
+		if self.wx.argv.IfSaveGroupMember {
+			originalMemberList = append(originalMemberList, gui)
+		}
+	}
+	addMember(&GroupUserInfo{NickName: self.wx.Session.MyNickName, UserName: self.wx.Session.MyUserName})
+	memberList, _ := data["MemberList"].([]interface{})
+	for _, v := range memberList {
+		member, _ := v.(map[string]interface{})
+		if member == nil {
+			continue
+		}
+		userName, _ := member["UserName"].(string)
+		if userName == "" {
+			continue
+		}
+		nickName, _ := member["NickName"].(string)
+		if !self.wx.argv.IfNotReplaceEmoji {
+			nickName = replaceEmoji(nickName)
+		}
+		if nickName == "" {
+			if uf := self.GetFriend(userName); uf != nil {
+				nickName = uf.NickName
 			}
-			time.Sleep(2 * time.Second)
 		}
+		addMember(&GroupUserInfo{NickName: nickName, UserName: userName})
 	}
+
+	ug := NewUserGroup(0, topic, chatRoomName, self.wx)
+	ug.SetOwner(self.wx.Session.MyUserName)
+	ug.SetMemberList(memberListMap, nickMemberListMap, originalMemberList)
+	self.AddGroup(ug)
+	logrus.Infof("wx[%s] create group[%s][%s] with %d members success.", self.wx.Session.MyNickName, topic, chatRoomName, len(memberListMap))
+
+	return ug, nil
 }
 
 func (self *UserContact) setIpPort(r *models.Robot) {
@@ -411,115 +844,140 @@ func (self *UserContact) setIpPort(r *models.Robot) {
 	r.OfPort = self.wx.cfg.Host
 }
 
-func (self *UserContact) SaveRobotGroups() {
-	if self.wx.argv.IfSaveRobotGroups {
-		robot := &models.Robot{
-			RobotWx: self.wx.Session.MyNickName,
-		}
-		has, err := models.GetRobot(robot)
-		if err != nil {
-			logrus.Errorf("get robot error: %v", err)
-			return
-		}
-		if has {
-			if robot.IfSaveGroup != 0 {
-				logrus.Debugf("Robot[%s] group has saved.", self.wx.Session.MyNickName)
-				self.setIpPort(robot)
-				err = models.UpdateRobotHost(robot)
-				if err != nil {
-					logrus.Errorf("update robot save group error: %v", err)
-				}
-				return
-			}
-		} else {
-			self.setIpPort(robot)
-			err = models.CreateRobot(robot)
-			if err != nil {
-				logrus.Errorf("create robot error: %v", err)
-				return
-			}
+// 保存好友和群的任务参数, force为已保存过也重新保存
+type SaveRobotJobArgv struct {
+	Force bool `json:"force,omitempty"`
+}
+
+func newSaveRobotGroupsJob(wx *WxWeb, argv json.RawMessage) (JobFunc, error) {
+	conf := &SaveRobotJobArgv{}
+	if err := decodeJobArgv(argv, conf); err != nil {
+		return nil, err
+	}
+	return func(ctx *JobContext) error {
+		return wx.Contact.SaveRobotGroups(ctx, conf.Force)
+	}, nil
+}
+
+func newSaveRobotFriendsJob(wx *WxWeb, argv json.RawMessage) (JobFunc, error) {
+	conf := &SaveRobotJobArgv{}
+	if err := decodeJobArgv(argv, conf); err != nil {
+		return nil, err
+	}
+	return func(ctx *JobContext) error {
+		return wx.Contact.SaveRobotFriends(ctx, conf.Force)
+	}, nil
+}
+
+// getSaveRobot gets or creates the robot record, nil means saved before.
+func (self *UserContact) getSaveRobot(force bool, saved func(r *models.Robot) bool) (*models.Robot, error) {
+	robot := &models.Robot{
+		RobotWx: self.wx.Session.MyNickName,
+	}
+	has, err := models.GetRobot(robot)
+	if err != nil {
+		return nil, fmt.Errorf("get robot error: %v", err)
+	}
+	if !has {
+		self.setIpPort(robot)
+		if err = models.CreateRobot(robot); err != nil {
+			return nil, fmt.Errorf("create robot error: %v", err)
 		}
-		var list []WxGroup
-		for _, v := range self.Groups {
-			list = append(list, WxGroup{
-				NickName:       v.NickName,
-				UserName:       v.UserName,
-				GroupMemberNum: v.GetGroupMemberLen(),
-			})
-			if len(list) >= 20 {
-				self.wx.wxh.RobotAddGroups(self.wx.Session.MyNickName, list)
-				list = nil
-				time.Sleep(time.Second)
-			}
+		return robot, nil
+	}
+	if saved(robot) && !force {
+		self.setIpPort(robot)
+		if err = models.UpdateRobotHost(robot); err != nil {
+			logrus.Errorf("update robot host error: %v", err)
 		}
-		if list != nil {
+		return nil, nil
+	}
+	return robot, nil
+}
+
+func (self *UserContact) SaveRobotGroups(ctx *JobContext, force bool) error {
+	robot, err := self.getSaveRobot(force, func(r *models.Robot) bool { return r.IfSaveGroup != 0 })
+	if err != nil {
+		return err
+	}
+	if robot == nil {
+		logrus.Debugf("Robot[%s] group has saved.", self.wx.Session.MyNickName)
+		return nil
+	}
+	groups := self.GroupsSnapshot()
+	var list []WxGroup
+	for i, v := range groups {
+		list = append(list, v.WxGroup())
+		if len(list) >= JOB_SAVE_BATCH || i == len(groups)-1 {
 			self.wx.wxh.RobotAddGroups(self.wx.Session.MyNickName, list)
 			list = nil
+			ctx.SetProgress(int64(i+1), int64(len(groups)))
+			if !ctx.Sleep(time.Second) {
+				return nil
+			}
 		}
-		robot.IfSaveGroup = 1
-		self.setIpPort(robot)
-		err = models.UpdateRobotSaveGroup(robot)
-		if err != nil {
-			logrus.Errorf("update robot save group error: %v", err)
-		}
 	}
+	robot.IfSaveGroup = 1
+	self.setIpPort(robot)
+	if err = models.UpdateRobotSaveGroup(robot); err != nil {
+		return fmt.Errorf("update robot save group error: %v", err)
+	}
+	return nil
 }
 
-func (self *UserContact) SaveRobotFriends() {
-	if self.wx.argv.IfSaveRobotFriends {
-		robot := &models.Robot{
-			RobotWx: self.wx.Session.MyNickName,
-		}
-		has, err := models.GetRobot(robot)
-		if err != nil {
-			logrus.Errorf("get robot error: %v", err)
-			return
+func (self *UserContact) SaveRobotFriends(ctx *JobContext, force bool) error {
+	robot, err := self.getSaveRobot(force, func(r *models.Robot) bool { return r.IfSaveFriend != 0 })
+	if err != nil {
+		return err
+	}
+	if robot == nil {
+		logrus.Debugf("Robot[%s] friend has saved.", self.wx.Session.MyNickName)
+		return nil
+	}
+	var friends []UserFriend
+	for _, v := range self.FriendsSnapshot() {
+		_, ok := self.wx.SpecialUsers[v.UserName]
+		if ok {
+			continue
 		}
-		if has {
-			if robot.IfSaveFriend != 0 {
-				logrus.Debugf("Robot[%s] friend has saved.", self.wx.Session.MyNickName)
-				self.setIpPort(robot)
-				err = models.UpdateRobotHost(robot)
-				if err != nil {
-					logrus.Errorf("update robot save error: %v", err)
-				}
-				return
-			}
-		} else {
-			self.setIpPort(robot)
-			err = models.CreateRobot(robot)
-			if err != nil {
-				logrus.Errorf("create robot error: %v", err)
-				return
-			}
+		if v.VerifyFlag != WX_FRIEND_VERIFY_FLAG_USER {
+			continue
 		}
-		var list []UserFriend
-		for _, v := range self.Friends {
-			_, ok := self.wx.SpecialUsers[v.UserName]
-			if ok {
-				continue
-			}
-			if v.VerifyFlag != WX_FRIEND_VERIFY_FLAG_USER {
-				continue
-			}
-			list = append(list, *v)
-			if len(list) >= 20 {
-				self.wx.wxh.RobotAddFriends(self.wx.Session.MyNickName, list)
-				list = nil
-				time.Sleep(time.Second)
-			}
+		friends = append(friends, v)
+	}
+	for i := 0; i < len(friends); i += JOB_SAVE_BATCH {
+		end := i + JOB_SAVE_BATCH
+		if end > len(friends) {
+			end = len(friends)
 		}
-		if list != nil {
-			self.wx.wxh.RobotAddFriends(self.wx.Session.MyNickName, list)
-			list = nil
+		self.wx.wxh.RobotAddFriends(self.wx.Session.MyNickName, friends[i:end])
+		ctx.SetProgress(int64(end), int64(len(friends)))
+		if !ctx.Sleep(time.Second) {
+			return nil
 		}
-		robot.IfSaveFriend = 1
-		self.setIpPort(robot)
-		err = models.UpdateRobotSaveFriend(robot)
-		if err != nil {
-			logrus.Errorf("update robot save friend error: %v", err)
+	}
+	robot.IfSaveFriend = 1
+	self.setIpPort(robot)
+	if err = models.UpdateRobotSaveFriend(robot); err != nil {
+		return fmt.Errorf("update robot save friend error: %v", err)
+	}
+	return nil
+}
+
+func diffUserFriend(old, uf *UserFriend) []FriendChange {
+	var changes []FriendChange
+	check := func(field, oldValue, newValue string) {
+		if oldValue != newValue {
+			changes = append(changes, FriendChange{Field: field, Old: oldValue, New: newValue})
 		}
 	}
+	check(FRIEND_FIELD_NICKNAME, old.NickName, uf.NickName)
+	check(FRIEND_FIELD_REMARK, old.RemarkName, uf.RemarkName)
+	check(FRIEND_FIELD_ALIAS, old.Alias, uf.Alias)
+	check(FRIEND_FIELD_CITY, old.City, uf.City)
+	check(FRIEND_FIELD_SEX, strconv.Itoa(old.Sex), strconv.Itoa(uf.Sex))
+	check(FRIEND_FIELD_SIGNATURE, old.Signature, uf.Signature)
+	return changes
 }
 
 func GetHostName() string {
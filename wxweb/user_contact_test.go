@@ -0,0 +1,247 @@
+package wxweb
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testWxHandler struct {
+	sync.Mutex
+	msgs []*ReceiveMsgInfo
+}
+
+func (self *testWxHandler) Login(uuid string)  {}
+func (self *testWxHandler) Logout(uuid string) {}
+func (self *testWxHandler) ReceiveMsg(msg *ReceiveMsgInfo) {
+	self.Lock()
+	defer self.Unlock()
+	self.msgs = append(self.msgs, msg)
+}
+func (self *testWxHandler) RobotAddFriends(robot string, friends []UserFriend) {}
+func (self *testWxHandler) RobotAddGroups(robot string, groups []WxGroup)      {}
+func (self *testWxHandler) RunInviteCampaign(wx *WxWeb, conf *InviteCampaignConf, ctx *JobContext) error {
+	return nil
+}
+
+func newTestWxWeb() *WxWeb {
+	wx := &WxWeb{
+		Session: &WebWxSession{MyNickName: "robot", MyUserName: "@robot"},
+		wxh:     &testWxHandler{},
+		argv:    &StartWxArgv{},
+	}
+	wx.Contact = NewUserContact(wx)
+	return wx
+}
+
+func TestUserContactChangeFriend(t *testing.T) {
+	wx := newTestWxWeb()
+	uc := wx.Contact
+	uc.AddFriend(&UserFriend{UserName: "@a", NickName: "a", RemarkName: "a"})
+
+	old := uc.GetFriend("@a")
+	uc.ChangeFriend("@a", "b")
+	if old.RemarkName != "a" {
+		t.Errorf("old friend record was mutated: %v", old)
+	}
+	if uc.GetNickFriend("a") != nil {
+		t.Errorf("old remark still indexed")
+	}
+	uf := uc.GetNickFriend("b")
+	if uf == nil || uf.UserName != "@a" {
+		t.Errorf("new remark not indexed: %v", uf)
+	}
+}
+
+func TestUserContactRenameGroup(t *testing.T) {
+	wx := newTestWxWeb()
+	uc := wx.Contact
+	ug := NewUserGroup(0, "g1", "@@g", wx)
+	uc.AddGroup(ug)
+	uc.RenameGroup(ug, "g2")
+	if uc.GetNickGroup("g1") != nil {
+		t.Errorf("old group nickname still indexed")
+	}
+	if uc.FindGroup("", "g2") != ug {
+		t.Errorf("new group nickname not indexed")
+	}
+	if ug.GetNickName() != "g2" {
+		t.Errorf("group nickname not changed: %s", ug.GetNickName())
+	}
+}
+
+// run with -race: contact state is touched from the sync loop,
+// the http handlers and the background jobs at the same time.
+func TestUserContactConcurrent(t *testing.T) {
+	wx := newTestWxWeb()
+	uc := wx.Contact
+	ug := NewUserGroup(0, "group", "@@group", wx)
+	uc.AddGroup(ug)
+
+	const workers = 8
+	const loops = 200
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(4)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < loops; j++ {
+				username := fmt.Sprintf("@u%d_%d", i, j)
+				uc.AddFriend(&UserFriend{UserName: username, NickName: username, RemarkName: username})
+				uc.ChangeFriend(username, username+"_r")
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < loops; j++ {
+				for _, v := range uc.FriendsSnapshot() {
+					uc.FindFriend(v.UserName, v.RemarkName)
+				}
+				uc.FriendNum()
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < loops; j++ {
+				memberList := make(map[string]*GroupUserInfo)
+				nickMemberList := make(map[string]*GroupUserInfo)
+				gui := &GroupUserInfo{UserName: fmt.Sprintf("@m%d_%d", i, j), NickName: fmt.Sprintf("m%d_%d", i, j)}
+				memberList[gui.UserName] = gui
+				nickMemberList[gui.NickName] = gui
+				ug.ModMember(memberList)
+				ug.SetMemberList(memberList, nickMemberList, []*GroupUserInfo{gui})
+				uc.RenameGroup(ug, fmt.Sprintf("group%d", j%3))
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < loops; j++ {
+				for _, g := range uc.GroupsSnapshot() {
+					g.GetNickName()
+					for _, m := range g.MembersSnapshot() {
+						g.FindMember(m.UserName, m.NickName)
+					}
+					for range g.GetMemberList() {
+					}
+					g.GetGroupMemberLen()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := uc.FriendNum(); n != workers*loops {
+		t.Errorf("friend num: %d, want %d", n, workers*loops)
+	}
+	if n := uc.GroupNum(); n != 1 {
+		t.Errorf("group num: %d, want 1", n)
+	}
+}
+
+func TestUserGroupModMember(t *testing.T) {
+	wx := newTestWxWeb()
+	ug := NewUserGroup(0, "group", "@@group", wx)
+	members := func(names ...string) (map[string]*GroupUserInfo, map[string]*GroupUserInfo, []*GroupUserInfo) {
+		memberList := make(map[string]*GroupUserInfo)
+		nickMemberList := make(map[string]*GroupUserInfo)
+		var list []*GroupUserInfo
+		for _, v := range names {
+			gui := &GroupUserInfo{UserName: "@" + v, NickName: v}
+			memberList[gui.UserName] = gui
+			nickMemberList[gui.NickName] = gui
+			list = append(list, gui)
+		}
+		return memberList, nickMemberList, list
+	}
+
+	// 第一次看到的群没有旧成员, 不报告入群
+	memberList, nickMemberList, list := members("a", "b", "c")
+	ug.ModMember(memberList)
+	ug.SetMemberList(memberList, nickMemberList, list)
+	h := wx.wxh.(*testWxHandler)
+	if len(h.msgs) != 0 {
+		t.Fatalf("first seen group reported %d joins", len(h.msgs))
+	}
+
+	memberList, nickMemberList, list = members("a", "b", "c", "d")
+	ug.ModMember(memberList)
+	ug.SetMemberList(memberList, nickMemberList, list)
+	if len(h.msgs) != 1 || h.msgs[0].BaseInfo.FromNickName != "d" || h.msgs[0].BaseInfo.ReceiveEvent != RECEIVE_EVENT_MOD_GROUP_ADD_DETAIL {
+		t.Errorf("joins %+v, want d", h.msgs)
+	}
+}
+
+func TestUserContactUpdateFriend(t *testing.T) {
+	wx := newTestWxWeb()
+	uc := wx.Contact
+	old := &UserFriend{UserName: "@a", NickName: "a", RemarkName: "a", City: "sh", Sex: WX_GIRL}
+	uc.AddFriend(old)
+
+	uf := *old
+	uf.NickName = "b"
+	uf.RemarkName = "b"
+	uf.City = "bj"
+	changes := diffUserFriend(old, &uf)
+	if len(changes) != 3 {
+		t.Fatalf("changes: %v, want nickName, remarkName and city", changes)
+	}
+	uc.UpdateFriend(&uf)
+	if uc.GetNickFriend("a") != nil {
+		t.Errorf("old remark still indexed")
+	}
+	if uc.GetNickFriend("b") != uc.GetFriend("@a") {
+		t.Errorf("new remark not indexed")
+	}
+	if len(diffUserFriend(&uf, uc.GetFriend("@a"))) != 0 {
+		t.Errorf("stored friend differs from update")
+	}
+}
+
+func TestUserGroupMsgList(t *testing.T) {
+	wx := newTestWxWeb()
+	ug := NewUserGroup(0, "group", "@@group", wx)
+	if list, lost := ug.GetMsgList(-1); len(list) != 0 || lost {
+		t.Fatalf("empty group: %v %v", list, lost)
+	}
+
+	for i := 0; i < 10; i++ {
+		ug.AppendMsg(&MsgInfo{Content: fmt.Sprintf("%d", i)})
+	}
+	list, lost := ug.GetMsgList(6)
+	if lost || len(list) != 3 || list[0].MsgID != 7 || list[2].MsgID != 9 {
+		t.Errorf("after 6: %v %v", list, lost)
+	}
+	if list, lost = ug.GetMsgList(9); lost || len(list) != 0 {
+		t.Errorf("after last: %v %v", list, lost)
+	}
+
+	for i := 10; i < 3*MSG_LEN; i++ {
+		ug.AppendMsg(&MsgInfo{Content: fmt.Sprintf("%d", i)})
+	}
+	list, lost = ug.GetMsgList(-1)
+	if lost || len(list) != MSG_LEN-1 || list[len(list)-1].MsgID != 3*MSG_LEN-1 {
+		t.Errorf("all: %d %v", len(list), lost)
+	}
+	for i, v := range list {
+		if v.Content != fmt.Sprintf("%d", v.MsgID) || (i > 0 && v.MsgID != list[i-1].MsgID+1) {
+			t.Fatalf("msg %d out of order: %v", i, v)
+		}
+	}
+	if list, lost = ug.GetMsgList(5); !lost || list[0].MsgID != 2*MSG_LEN+1 {
+		t.Errorf("fell off: %v", lost)
+	}
+	if _, lost = ug.GetMsgList(5 * MSG_LEN); !lost {
+		t.Errorf("cursor ahead of buffer not reported")
+	}
+
+	done := make(chan []MsgInfo)
+	go func() {
+		list, _ := ug.WaitMsgList(3*MSG_LEN-1, time.Second)
+		done <- list
+	}()
+	ug.AppendMsg(&MsgInfo{Content: "new"})
+	if list := <-done; len(list) != 1 || list[0].Content != "new" {
+		t.Errorf("wait: %v", list)
+	}
+}
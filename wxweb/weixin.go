@@ -38,6 +38,7 @@ type WxHandler interface {
 	ReceiveMsg(msg *ReceiveMsgInfo)
 	RobotAddFriends(robot string, friends []UserFriend)
 	RobotAddGroups(robot string, groups []WxGroup)
+	RunInviteCampaign(wx *WxWeb, conf *InviteCampaignConf, ctx *JobContext) error
 }
 
 type WebWxSession struct {
@@ -86,6 +87,9 @@ type WxWeb struct {
 	wxh       WxHandler
 	argv      *StartWxArgv
 	agml      *AddGroupMember
+	dfs       *DeadFriendScan
+	jobs      *JobManager
+	gm        *GroupModerator
 
 	lastSaveCookieTime int64
 
@@ -474,7 +478,8 @@ func (self *WxWeb) webwxgetcontact(args ...interface{}) bool {
 			//logrus.Debugf("nickname[%s] username[%s] %v", nickName, userName, member)
 			if strings.HasPrefix(userName, GROUP_PREFIX) {
 				ug := NewUserGroup(contactFlag, nickName, userName, self)
-				self.Contact.Groups[userName] = ug
+				ug.SetOwner(self.parseGroupOwner(member))
+				self.Contact.SetGroup(userName, ug)
 			} else {
 				remarkName := member["RemarkName"].(string)
 				alias := member["Alias"].(string)
@@ -494,16 +499,19 @@ func (self *WxWeb) webwxgetcontact(args ...interface{}) bool {
 					realName = replaceEmoji(realName)
 				}
 
-				_, ok := self.Contact.NickFriends[realName]
-				if ok {
+				if self.Contact.GetNickFriend(realName) != nil {
 					realName = fmt.Sprintf("%s__%s", realName, time.Now().Format("20060102_15:04"))
-					ok = self.WebwxOplog(userName, realName)
+					ok := self.WebwxOplog(userName, realName)
 					if ok {
 						logrus.Debugf("webwxgetcontact webwxoplog success.")
 					}
 					time.Sleep(time.Second)
 				}
 
+				signature, _ := member["Signature"].(string)
+				if !self.argv.IfNotReplaceEmoji {
+					signature = replaceEmoji(signature)
+				}
 				uf := &UserFriend{
 					Alias:       alias,
 					City:        city,
@@ -513,9 +521,9 @@ func (self *WxWeb) webwxgetcontact(args ...interface{}) bool {
 					RemarkName:  realName,
 					Sex:         sex,
 					UserName:    userName,
+					Signature:   signature,
 				}
-				self.Contact.Friends[userName] = uf
-				self.Contact.NickFriends[realName] = uf
+				self.Contact.AddFriend(uf)
 				if realName == self.cfg.TestNickName {
 					self.TestUserName = userName
 					logrus.Debugf("test realname[%s] username[%s]", realName, userName)
@@ -523,9 +531,9 @@ func (self *WxWeb) webwxgetcontact(args ...interface{}) bool {
 			}
 		}
 	}
-	logrus.Debugf("webwxgetcontact get group num: %d", len(self.Contact.Groups))
-	logrus.Debugf("webwxgetcontact get user friend num: %d", len(self.Contact.Friends))
-	//for _,v := range self.Contact.Friends {
+	logrus.Debugf("webwxgetcontact get group num: %d", self.Contact.GroupNum())
+	logrus.Debugf("webwxgetcontact get user friend num: %d", self.Contact.FriendNum())
+	//for _,v := range self.Contact.FriendsSnapshot() {
 	//	logrus.Debugf("friend: %s", v.NickName)
 	//}
 
@@ -582,32 +590,10 @@ func (self *WxWeb) webwxbatchgetcontact(usernameList []string) bool {
 
 		if strings.HasPrefix(userName, GROUP_PREFIX) {
 			ug := NewUserGroup(contactFlag, nickName, userName, self)
+			ug.SetOwner(self.parseGroupOwner(Contact))
 			memberList := Contact["MemberList"].([]interface{})
-			for _, v2 := range memberList {
-				member := v2.(map[string]interface{})
-				if member == nil {
-					logrus.Errorf("webwxbatchgetcontact get member[%v] error", v2)
-					continue
-				}
-				displayName := member["DisplayName"].(string)
-				memberNickName := member["NickName"].(string)
-				if !self.argv.IfNotReplaceEmoji {
-					memberNickName = replaceEmoji(memberNickName)
-				}
-				userName := member["UserName"].(string)
-				gui := &GroupUserInfo{
-					DisplayName: displayName,
-					NickName:    memberNickName,
-					UserName:    userName,
-				}
-				ug.MemberList[userName] = gui
-				ug.NickMemberList[memberNickName] = gui
-				if self.argv.IfSaveGroupMember {
-					ug.OriginalMemberList = append(ug.OriginalMemberList, gui)
-				}
-			}
-			self.Contact.Groups[userName] = ug
-			self.Contact.NickGroups[nickName] = ug
+			ug.SetMemberList(self.parseGroupMembers(memberList))
+			self.Contact.AddGroup(ug)
 			// save group member
 			if self.argv.IfSaveGroupMember {
 				self.agml.AddGroup(userName)
@@ -615,11 +601,7 @@ func (self *WxWeb) webwxbatchgetcontact(usernameList []string) bool {
 			// check if save groups
 			if self.argv.IfSaveRobotGroups {
 				if nickName != "" {
-					groupList = append(groupList, WxGroup{
-						UserName:       userName,
-						NickName:       nickName,
-						GroupMemberNum: ug.GetGroupMemberLen(),
-					})
+					groupList = append(groupList, ug.WxGroup())
 				}
 			}
 			logrus.Debugf("get big contact add group[%s]", nickName)
@@ -640,16 +622,19 @@ func (self *WxWeb) webwxbatchgetcontact(usernameList []string) bool {
 			if !self.argv.IfNotReplaceEmoji {
 				realName = replaceEmoji(realName)
 			}
-			_, ok := self.Contact.NickFriends[realName]
-			if ok {
+			if self.Contact.GetNickFriend(realName) != nil {
 				realName = fmt.Sprintf("%s__%s", realName, time.Now().Format("20060102_15:04"))
-				ok = self.WebwxOplog(userName, realName)
+				ok := self.WebwxOplog(userName, realName)
 				if ok {
 					logrus.Debugf("webwxbatchgetcontact webwxoplog success.")
 				}
 				time.Sleep(time.Second)
 			}
 
+			signature, _ := Contact["Signature"].(string)
+			if !self.argv.IfNotReplaceEmoji {
+				signature = replaceEmoji(signature)
+			}
 			uf := &UserFriend{
 				Alias:       alias,
 				City:        city,
@@ -659,9 +644,9 @@ func (self *WxWeb) webwxbatchgetcontact(usernameList []string) bool {
 				RemarkName:  realName,
 				Sex:         sex,
 				UserName:    userName,
+				Signature:   signature,
 			}
-			self.Contact.Friends[userName] = uf
-			self.Contact.NickFriends[realName] = uf
+			self.Contact.AddFriend(uf)
 			if realName == self.cfg.TestNickName {
 				self.TestUserName = userName
 				logrus.Debugf("test realname[%s] username[%s]", realName, userName)
@@ -678,189 +663,139 @@ func (self *WxWeb) webwxbatchgetcontact(usernameList []string) bool {
 }
 
 func (self *WxWeb) GroupWebwxbatchgetcontact(args ...interface{}) bool {
+	var list []string
+	for _, v := range self.Contact.GroupsSnapshot() {
+		list = append(list, v.UserName)
+		if len(list) == 20 {
+			if !self.groupWebwxbatchgetcontact(list) {
+				return false
+			}
+			// clear
+			list = nil
+		}
+	}
+	if len(list) != 0 {
+		return self.groupWebwxbatchgetcontact(list)
+	}
+
+	return true
+}
+
+func (self *WxWeb) groupWebwxbatchgetcontact(groupList []string) bool {
 	urlstr := fmt.Sprintf("%s/webwxbatchgetcontact?type=ex&lang=zh_CN&pass_ticket=%s&r=%s", self.Session.BaseUri, self.Session.PassTicket, self._unixStr())
 	params := make(map[string]interface{})
 	params["BaseRequest"] = self.Session.BaseRequest
 	list := make([]map[string]interface{}, 0)
-	num := 0
-	for _, v := range self.Contact.Groups {
+	for _, v := range groupList {
 		gInfo := make(map[string]interface{})
 		gInfo["EncryChatRoomId"] = ""
-		gInfo["UserName"] = v.UserName
+		gInfo["UserName"] = v
 		list = append(list, gInfo)
-		num++
-		if num == 20 {
-			params["List"] = list
-			params["Count"] = len(list)
-			res, err := self._post(urlstr, params, true)
-			if err != nil {
-				logrus.Errorf("webwxbatchgetcontact _post error: %v", err)
-				return false
-			}
+	}
+	params["List"] = list
+	params["Count"] = len(list)
+	res, err := self._post(urlstr, params, true)
+	if err != nil {
+		logrus.Errorf("webwxbatchgetcontact _post error: %v", err)
+		return false
+	}
 
-			dataJson := JsonDecode(res)
-			if dataJson == nil {
-				logrus.Errorf("json decode error.")
-				return false
-			}
-			data := dataJson.(map[string]interface{})
-			if data == nil {
-				logrus.Errorf("webwxbatchgetcontact translate map error: %v", err)
-				return false
-			}
-			retCode := data["BaseResponse"].(map[string]interface{})["Ret"].(int)
-			if retCode != WX_RET_SUCCESS {
-				logrus.Errorf("webwxbatchgetcontact get error retcode[%d]", retCode)
-				return false
-			}
+	dataJson := JsonDecode(res)
+	if dataJson == nil {
+		logrus.Errorf("json decode error.")
+		return false
+	}
+	data := dataJson.(map[string]interface{})
+	if data == nil {
+		logrus.Errorf("webwxbatchgetcontact translate map error: %v", err)
+		return false
+	}
+	retCode := data["BaseResponse"].(map[string]interface{})["Ret"].(int)
+	if retCode != WX_RET_SUCCESS {
+		logrus.Errorf("webwxbatchgetcontact get error retcode[%d]", retCode)
+		return false
+	}
 
-			contactList := data["ContactList"].([]interface{})
-			if contactList == nil {
-				logrus.Errorf("webwxbatchgetcontact get contactList error")
-				return false
-			}
-			for _, v := range contactList {
-				Contact := v.(map[string]interface{})
-				if Contact == nil {
-					logrus.Errorf("webwxbatchgetcontact get Contact[%v] error", v)
-					continue
-				}
-				groupUserName := Contact["UserName"].(string)
-				groupContactFlag := Contact["ContactFlag"].(int)
-				groupNickName := Contact["NickName"].(string)
-				if !self.argv.IfNotReplaceEmoji {
-					groupNickName = replaceEmoji(groupNickName)
-				}
-				memberList := Contact["MemberList"].([]interface{})
-				for _, v2 := range memberList {
-					member := v2.(map[string]interface{})
-					if member == nil {
-						logrus.Errorf("webwxbatchgetcontact get member[%v] error", v2)
-						continue
-					}
-					//logrus.Debugf("%s member: %v", groupNickName, member)
-					displayName := member["DisplayName"].(string)
-					nickName := member["NickName"].(string)
-					if !self.argv.IfNotReplaceEmoji {
-						nickName = replaceEmoji(nickName)
-					}
-					userName := member["UserName"].(string)
-					gui := &GroupUserInfo{
-						DisplayName: displayName,
-						NickName:    nickName,
-						UserName:    userName,
-					}
-					gv := self.Contact.Groups[groupUserName]
-					if gv == nil {
-						logrus.Errorf("Contact groups have no this username[%s]", groupUserName)
-						continue
-					}
-					gv.MemberList[userName] = gui
-					gv.NickMemberList[nickName] = gui
-					if self.argv.IfSaveGroupMember {
-						gv.OriginalMemberList = append(gv.OriginalMemberList, gui)
-					}
-					gv.NickName = groupNickName
-					gv.ContactFlag = groupContactFlag
-				}
-				if self.argv.IfSaveGroupMember {
-					self.agml.AddGroup(groupUserName)
-				}
-				gv := self.Contact.Groups[groupUserName]
-				if gv != nil {
-					self.Contact.NickGroups[groupNickName] = gv
-				}
-			}
-			// clear
-			num = 0
-			list = nil
-		}
+	contactList := data["ContactList"].([]interface{})
+	if contactList == nil {
+		logrus.Errorf("webwxbatchgetcontact get contactList error")
+		return false
 	}
-	if num != 0 {
-		params["List"] = list
-		params["Count"] = len(list)
-		res, err := self._post(urlstr, params, true)
-		if err != nil {
-			logrus.Errorf("webwxbatchgetcontact _post error: %v", err)
-			return false
+	for _, v := range contactList {
+		Contact := v.(map[string]interface{})
+		if Contact == nil {
+			logrus.Errorf("webwxbatchgetcontact get Contact[%v] error", v)
+			continue
 		}
-
-		dataJson := JsonDecode(res)
-		if dataJson == nil {
-			logrus.Errorf("json decode error.")
-			return false
+		groupUserName := Contact["UserName"].(string)
+		groupContactFlag := Contact["ContactFlag"].(int)
+		groupNickName := Contact["NickName"].(string)
+		if !self.argv.IfNotReplaceEmoji {
+			groupNickName = replaceEmoji(groupNickName)
 		}
-		data := dataJson.(map[string]interface{})
-		if data == nil {
-			logrus.Errorf("webwxbatchgetcontact translate map error: %v", err)
-			return false
+		gv := self.Contact.GetGroup(groupUserName)
+		if gv == nil {
+			logrus.Errorf("Contact groups have no this username[%s]", groupUserName)
+			continue
 		}
-		retCode := data["BaseResponse"].(map[string]interface{})["Ret"].(int)
-		if retCode != WX_RET_SUCCESS {
-			logrus.Errorf("webwxbatchgetcontact get error retcode[%d]", retCode)
-			return false
+		memberList := Contact["MemberList"].([]interface{})
+		gv.SetMemberList(self.parseGroupMembers(memberList))
+		gv.SetContactFlag(groupContactFlag)
+		gv.SetOwner(self.parseGroupOwner(Contact))
+		self.Contact.RenameGroup(gv, groupNickName)
+		if self.argv.IfSaveGroupMember {
+			self.agml.AddGroup(groupUserName)
 		}
+	}
 
-		contactList := data["ContactList"].([]interface{})
-		if contactList == nil {
-			logrus.Errorf("webwxbatchgetcontact get contactList error")
-			return false
+	return true
+}
+
+// parseGroupOwner returns the owner username of a webwx group contact,
+// or empty when the contact does not say.
+func (self *WxWeb) parseGroupOwner(contact map[string]interface{}) string {
+	owner, _ := contact["ChatRoomOwner"].(string)
+	if owner != "" {
+		return owner
+	}
+	if isOwner, _ := contact["IsOwner"].(int); isOwner == 1 {
+		return self.Session.MyUserName
+	}
+	if ownerUin, _ := contact["OwnerUin"].(int); ownerUin != 0 && strconv.Itoa(ownerUin) == self.Session.Uin {
+		return self.Session.MyUserName
+	}
+	return ""
+}
+
+// parseGroupMembers builds the member indexes of a group from a webwx MemberList.
+func (self *WxWeb) parseGroupMembers(memberList []interface{}) (map[string]*GroupUserInfo, map[string]*GroupUserInfo, []*GroupUserInfo) {
+	memberListMap := make(map[string]*GroupUserInfo)
+	nickMemberListMap := make(map[string]*GroupUserInfo)
+	var originalMemberList []*GroupUserInfo
+	for _, v := range memberList {
+		member := v.(map[string]interface{})
+		if member == nil {
+			logrus.Errorf("parse group member[%v] error", v)
+			continue
 		}
-		for _, v := range contactList {
-			Contact := v.(map[string]interface{})
-			if Contact == nil {
-				logrus.Errorf("webwxbatchgetcontact get Contact[%v] error", v)
-				continue
-			}
-			groupUserName := Contact["UserName"].(string)
-			groupContactFlag := Contact["ContactFlag"].(int)
-			groupNickName := Contact["NickName"].(string)
-			if !self.argv.IfNotReplaceEmoji {
-				groupNickName = replaceEmoji(groupNickName)
-			}
-			memberList := Contact["MemberList"].([]interface{})
-			for _, v2 := range memberList {
-				member := v2.(map[string]interface{})
-				if member == nil {
-					logrus.Errorf("webwxbatchgetcontact get member[%v] error", v2)
-					continue
-				}
-				//logrus.Debugf("%s member: %v", groupNickName, member)
-				displayName := member["DisplayName"].(string)
-				nickName := member["NickName"].(string)
-				if !self.argv.IfNotReplaceEmoji {
-					nickName = replaceEmoji(nickName)
-				}
-				userName := member["UserName"].(string)
-				gui := &GroupUserInfo{
-					DisplayName: displayName,
-					NickName:    nickName,
-					UserName:    userName,
-				}
-				gv := self.Contact.Groups[groupUserName]
-				if gv == nil {
-					logrus.Errorf("Contact groups have no this username[%s]", groupUserName)
-					continue
-				}
-				gv.MemberList[userName] = gui
-				gv.NickMemberList[nickName] = gui
-				if self.argv.IfSaveGroupMember {
-					gv.OriginalMemberList = append(gv.OriginalMemberList, gui)
-				}
-				gv.NickName = groupNickName
-				gv.ContactFlag = groupContactFlag
-			}
-			if self.argv.IfSaveGroupMember {
-				self.agml.AddGroup(groupUserName)
-			}
-			gv := self.Contact.Groups[groupUserName]
-			if gv != nil {
-				self.Contact.NickGroups[groupNickName] = gv
-			}
+		displayName := member["DisplayName"].(string)
+		nickName := member["NickName"].(string)
+		if !self.argv.IfNotReplaceEmoji {
+			nickName = replaceEmoji(nickName)
+		}
+		userName := member["UserName"].(string)
+		gui := &GroupUserInfo{
+			DisplayName: displayName,
+			NickName:    nickName,
+			UserName:    userName,
+		}
+		memberListMap[userName] = gui
+		nickMemberListMap[nickName] = gui
+		if self.argv.IfSaveGroupMember {
+			originalMemberList = append(originalMemberList, gui)
 		}
 	}
-
-	return true
+	return memberListMap, nickMemberListMap, originalMemberList
 }
 
 func (self *WxWeb) webgetchatroommember(chatroomId string) (map[string]string, error) {
@@ -1201,6 +1136,7 @@ func (self *WxWeb) Webwxsendmsgimg(toUserName, mediaId string) bool {
 	} else {
 		if CheckWebwxRetcode(data) {
 			logrus.Debugf("wx[%s] send img toUserName[%s] success.", self.Session.MyNickName, toUserName)
+			self.archiveSendMsg(toUserName, RECEIVE_MSG_TYPE_IMG, "", mediaId, data)
 			return true
 		}
 		logrus.Errorf("wx[%s] send msg img error.", self.Session.MyNickName)
@@ -1231,6 +1167,7 @@ func (self *WxWeb) Webwxsendvideomsg(toUserName, mediaId string) bool {
 	} else {
 		if CheckWebwxRetcode(data) {
 			logrus.Debugf("wx[%s] send video toUserName[%s] success.", self.Session.MyNickName, toUserName)
+			self.archiveSendMsg(toUserName, RECEIVE_MSG_TYPE_VIDEO, "", mediaId, data)
 			return true
 		}
 		logrus.Errorf("wx[%s] send msg video error.", self.Session.MyNickName)
@@ -1259,6 +1196,7 @@ func (self *WxWeb) Webwxsendmsg(message string, toUserName string) bool {
 	} else {
 		if CheckWebwxRetcode(data) {
 			logrus.Debugf("wx[%s] send msg[%s] toUserName[%s] success.", self.Session.MyNickName, message, toUserName)
+			self.archiveSendMsg(toUserName, RECEIVE_MSG_TYPE_TEXT, message, "", data)
 			return true
 		}
 		logrus.Errorf("wx[%s] send msg[%s] error.", self.Session.MyNickName, message)
@@ -1267,16 +1205,21 @@ func (self *WxWeb) Webwxsendmsg(message string, toUserName string) bool {
 }
 
 func (self *WxWeb) WebwxsendmsgOfShare(message string, toUserName string) bool {
+	return self.WebwxsendmsgLink(message, "百度一下", "http://www.baidu.com", toUserName)
+}
+
+// 发送链接消息
+func (self *WxWeb) WebwxsendmsgLink(title, desc, link string, toUserName string) bool {
 	urlstr := fmt.Sprintf("%s/webwxsendmsg?pass_ticket=%s", self.Session.BaseUri, self.Session.PassTicket)
 	clientMsgId := self._unixStr() + "0" + strconv.Itoa(rand.Int())[3:6]
 	params := make(map[string]interface{})
 	params["BaseRequest"] = self.Session.BaseRequest
 	msg := make(map[string]interface{})
 	msg["Type"] = 7
-	msg["Title"] = message
-	msg["Desc"] = "百度一下"
-	msg["Url"] = "http://www.baidu.com"
-	msg["From"] = "http://www.baidu.com"
+	msg["Title"] = title
+	msg["Desc"] = desc
+	msg["Url"] = link
+	msg["From"] = link
 	msg["FromUserName"] = self.Session.User["UserName"]
 	msg["ToUserName"] = toUserName
 	msg["LocalID"] = clientMsgId
@@ -1284,14 +1227,15 @@ func (self *WxWeb) WebwxsendmsgOfShare(message string, toUserName string) bool {
 	params["Msg"] = msg
 	data, err := self._post(urlstr, params, true)
 	if err != nil {
-		logrus.Errorf("wx send share msg[%s] toUserName[%s] error: %s", message, toUserName, err)
+		logrus.Errorf("wx send share msg[%s] toUserName[%s] error: %s", title, toUserName, err)
 		return false
 	} else {
 		if CheckWebwxRetcode(data) {
-			logrus.Debugf("wx[%s] send share msg[%s] toUserName[%s] success.", self.Session.MyNickName, message, toUserName)
+			logrus.Debugf("wx[%s] send share msg[%s] toUserName[%s] success.", self.Session.MyNickName, title, toUserName)
+			self.archiveSendMsg(toUserName, RECEIVE_MSG_TYPE_LINK, title, link, data)
 			return true
 		}
-		logrus.Errorf("wx[%s] send share msg[%s] error.", self.Session.MyNickName, message)
+		logrus.Errorf("wx[%s] send share msg[%s] error.", self.Session.MyNickName, title)
 	}
 	return false
 }
@@ -1314,6 +1258,29 @@ func (self *WxWeb) WebwxupdatechatroomInvitemember(groupUserName string, userNam
 	}
 }
 
+// 直接拉人进群(群人数未超过邀请阈值时可用)
+func (self *WxWeb) WebwxupdatechatroomAddmember(groupUserName string, userNames []string) (string, bool) {
+	urlstr := fmt.Sprintf("%s/webwxupdatechatroom?fun=addmember&pass_ticket=%s",
+		self.Session.BaseUri, self.Session.PassTicket)
+	params := make(map[string]interface{})
+	params["BaseRequest"] = self.Session.BaseRequest
+	params["ChatRoomName"] = groupUserName
+	params["AddMemberList"] = strings.Join(userNames, ",")
+	data, err := self._post(urlstr, params, true)
+	if err != nil {
+		logrus.Errorf("wx add member groupUserName[%s] error: %s", groupUserName, err)
+		return "", false
+	} else {
+		logrus.Debugf("wx add member groupUserName[%s] get data[%s] success.", groupUserName, data)
+		return data, true
+	}
+}
+
+// 重新拉取群信息及群成员
+func (self *WxWeb) RefreshGroup(groupUserName string) bool {
+	return self.groupWebwxbatchgetcontact([]string{groupUserName})
+}
+
 // 修改群名
 func (self *WxWeb) WebwxupdatechatroomModTopic(groupUserName, newTopic string) bool {
 	urlstr := fmt.Sprintf("%s/webwxupdatechatroom?fun=modtopic",
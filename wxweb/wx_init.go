@@ -46,10 +46,10 @@ func (self *WxWeb) webwxinit(args ...interface{}) bool {
 	for _, v := range chats {
 		if strings.HasPrefix(v, GROUP_PREFIX) {
 			ug := NewUserGroup(0, "", v, self)
-			self.Contact.Groups[v] = ug
+			self.Contact.SetGroup(v, ug)
 		}
 	}
-	logrus.Debugf("webwxinit get group num: %d", len(self.Contact.Groups))
+	logrus.Debugf("webwxinit get group num: %d", self.Contact.GroupNum())
 
 	return true
 }